@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+
+	"agent-service-prototype/internal/app/agent-service-prototype/repository"
+	"agent-service-prototype/internal/app/agent-service-prototype/service"
+	"agent-service-prototype/internal/bootstrap"
+	"agent-service-prototype/internal/config"
+	"agent-service-prototype/pkg/logger"
+)
+
+const usage = `Usage: backup <command> [flags]
+
+Commands:
+  list       List pre-migration backups in WORK_DIR/backups
+  restore    Load a backup back into the database (-id <run-id> [-tables schema.table,...])
+`
+
+func main() {
+	logger.Init()
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to load config")
+	}
+	if err := logger.Configure(cfg.HTTP.LogFormat, cfg.HTTP.LogLevel); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to configure logger")
+	}
+
+	switch os.Args[1] {
+	case "list":
+		runList(cfg)
+	case "restore":
+		runRestore(cfg, os.Args[2:])
+	case "-h", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+}
+
+func runList(cfg *config.Config) {
+	svc := service.NewService(cfg, repository.NewRepository(nil, metaNamespace(cfg)))
+	backups, err := svc.ListBackups()
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to list backups")
+	}
+	for _, b := range backups {
+		tables := make([]string, 0, len(b.Tables))
+		for _, t := range b.Tables {
+			tables = append(tables, t.Name)
+		}
+		restored := ""
+		if b.RestoredAt != nil {
+			restored = " (restored " + b.RestoredAt.Format("2006-01-02 15:04:05") + ")"
+		}
+		fmt.Printf("%s  %s  %s%s\n", b.RunID, b.CreatedAt.Format("2006-01-02 15:04:05"), strings.Join(tables, ","), restored)
+	}
+}
+
+func runRestore(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	id := fs.String("id", "", "backup run ID (see `backup list`)")
+	tables := fs.String("tables", "", "comma-separated schema.table list (default: all tables in the backup)")
+	fs.Parse(args)
+	if *id == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var only []string
+	if *tables != "" {
+		only = strings.Split(*tables, ",")
+	}
+
+	db, err := bootstrap.ConnectDatabase(context.Background(), cfg)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to connect to database")
+	}
+	defer bootstrap.CloseDatabase(db)
+
+	svc := service.NewService(cfg, repository.NewRepository(db, metaNamespace(cfg)))
+	m, err := svc.RestoreBackup(context.Background(), *id, only, service.Actor{User: currentUser()})
+	if err != nil {
+		logger.Fatal().Err(err).Str("run_id", *id).Msg("Failed to restore backup")
+	}
+	fmt.Printf("Backup %s restored (%d tables)\n", m.RunID, len(m.Tables))
+}
+
+func metaNamespace(cfg *config.Config) repository.MetaNamespace {
+	return repository.MetaNamespace{Schema: cfg.DB.MetaSchema, MigrationsTable: cfg.DB.MetaTable}
+}
+
+// currentUser names the OS user running the command, for the audit trail.
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"agent-service-prototype/pkg/logger"
+	"agent-service-prototype/pkg/setup"
+)
+
+const usage = `Usage: bundle <command> [flags]
+
+Commands:
+  build    Generate manifest.json and checksums.json for a migrations directory and zip it
+`
+
+func main() {
+	logger.Init()
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "build":
+		runBuild(os.Args[2:])
+	case "-h", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+}
+
+func runBuild(args []string) {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	src := fs.String("src", "db-bundle/db-bundle", "bundle source directory (baseline/, migrations/, checks/, optional manifest.json)")
+	out := fs.String("out", "db-bundle/db-bundle.zip", "output zip path")
+	prefix := fs.String("prefix", "", "top-level folder inside the zip (default: base name of -src)")
+	app := fs.String("app", "hris-db-migrator-prototype", "manifest app name")
+	version := fs.String("version", "", "bundle_version (default: target schema version)")
+	schema := fs.String("schema", "hris", "manifest db.default_schema")
+	minPG := fs.Int("min-pg", 13, "manifest db.min_version")
+	regenerate := fs.Bool("regenerate", false, "ignore the manifest.json in -src and infer every field from the files")
+	fs.Parse(args)
+
+	// Flags given on the command line replace the values of an existing manifest.json;
+	// the others only fill fields it leaves empty.
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	defaultDB := setup.ManifestDB{Type: "postgres", MinVersion: *minPG, DefaultSchema: *schema}
+	opts := setup.BuildOptions{
+		SrcDir:        *src,
+		OutZip:        *out,
+		Prefix:        *prefix,
+		BundleVersion: *version,
+		DefaultApp:    *app,
+		DefaultDB:     defaultDB,
+		Regenerate:    *regenerate,
+	}
+	if set["app"] {
+		opts.App = *app
+	}
+	if set["schema"] {
+		opts.DB.DefaultSchema = *schema
+	}
+	if set["min-pg"] {
+		opts.DB.MinVersion = *minPG
+	}
+
+	m, err := setup.BuildBundle(opts)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to build bundle")
+	}
+
+	fmt.Printf("Bundle %s (schema %s, %d migrations) written to %s\n",
+		m.BundleVersion, m.TargetSchemaVersion, len(m.Migrations), *out)
+}
@@ -14,6 +14,9 @@ func main() {
 	if err != nil {
 		logger.Fatal().Err(err).Msg("Failed to load config")
 	}
+	if err := logger.Configure(cfg.HTTP.LogFormat, cfg.HTTP.LogLevel); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to configure logger")
+	}
 
 	serverManager, err := server.NewServerManager(cfg)
 	if err != nil {
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"agent-service-prototype/internal/app/agent-service-prototype/repository"
+	"agent-service-prototype/internal/app/agent-service-prototype/service"
+	"agent-service-prototype/internal/bootstrap"
+	"agent-service-prototype/internal/config"
+	"agent-service-prototype/pkg/logger"
+)
+
+const usage = `Usage: user <command> [flags]
+
+Commands:
+  list       List admin users for POST /auth/login
+  set        Create a user or change its password (-name <username> [-role viewer|operator|admin];
+             the password is read from stdin)
+  role       Change the role of a user (-name <username> -role viewer|operator|admin)
+  delete     Delete a user and end its sessions (-name <username>)
+`
+
+func main() {
+	logger.Init()
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to load config")
+	}
+	if err := logger.Configure(cfg.HTTP.LogFormat, cfg.HTTP.LogLevel); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to configure logger")
+	}
+
+	switch os.Args[1] {
+	case "list":
+		runList(cfg)
+	case "set":
+		runSet(cfg, os.Args[2:])
+	case "role":
+		runRole(cfg, os.Args[2:])
+	case "delete":
+		runDelete(cfg, os.Args[2:])
+	case "-h", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+}
+
+func runList(cfg *config.Config) {
+	db := connect(cfg)
+	defer bootstrap.CloseDatabase(db)
+
+	users, err := newService(cfg, db).ListUsers(context.Background())
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to list users")
+	}
+	for _, u := range users {
+		disabled := ""
+		if u.Disabled {
+			disabled = " (disabled)"
+		}
+		fmt.Printf("%s  %s  %s%s\n", u.Username, u.Role, u.UpdatedAt.Format("2006-01-02 15:04:05"), disabled)
+	}
+}
+
+func runSet(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("set", flag.ExitOnError)
+	name := fs.String("name", "", "username")
+	role := fs.String("role", "", "viewer, operator or admin (default: keep the current role; viewer for new users)")
+	fs.Parse(args)
+	if *name == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	fmt.Fprintf(os.Stderr, "Password for %s: ", *name)
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		logger.Fatal().Err(err).Msg("Failed to read password from stdin")
+	}
+	fmt.Fprintln(os.Stderr)
+	password = strings.TrimRight(password, "\r\n")
+
+	db := connect(cfg)
+	defer bootstrap.CloseDatabase(db)
+
+	if err := newService(cfg, db).SetUserPassword(context.Background(), *name, password, *role); err != nil {
+		logger.Fatal().Err(err).Str("username", *name).Msg("Failed to set password")
+	}
+	fmt.Printf("Password of %s set, existing sessions ended\n", *name)
+}
+
+func runRole(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("role", flag.ExitOnError)
+	name := fs.String("name", "", "username")
+	role := fs.String("role", "", "viewer, operator or admin")
+	fs.Parse(args)
+	if *name == "" || *role == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	db := connect(cfg)
+	defer bootstrap.CloseDatabase(db)
+
+	if err := newService(cfg, db).SetUserRole(context.Background(), *name, *role); err != nil {
+		logger.Fatal().Err(err).Str("username", *name).Msg("Failed to set role")
+	}
+	fmt.Printf("Role of %s set to %s\n", *name, *role)
+}
+
+func runDelete(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("delete", flag.ExitOnError)
+	name := fs.String("name", "", "username")
+	fs.Parse(args)
+	if *name == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	db := connect(cfg)
+	defer bootstrap.CloseDatabase(db)
+
+	if err := newService(cfg, db).DeleteUser(context.Background(), *name); err != nil {
+		logger.Fatal().Err(err).Str("username", *name).Msg("Failed to delete user")
+	}
+	fmt.Printf("User %s deleted\n", *name)
+}
+
+func connect(cfg *config.Config) *sql.DB {
+	db, err := bootstrap.ConnectDatabase(context.Background(), cfg)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to connect to database")
+	}
+	return db
+}
+
+func newService(cfg *config.Config, db *sql.DB) *service.Service {
+	return service.NewService(cfg, repository.NewRepository(db, repository.MetaNamespace{Schema: cfg.DB.MetaSchema, MigrationsTable: cfg.DB.MetaTable}))
+}
@@ -1,53 +1,600 @@
 package controller
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"agent-service-prototype/internal/app/agent-service-prototype/dto"
+	"agent-service-prototype/internal/app/agent-service-prototype/repository"
 	"agent-service-prototype/internal/app/agent-service-prototype/service"
+	"agent-service-prototype/internal/config"
+	"agent-service-prototype/internal/middleware"
+	"agent-service-prototype/pkg/backup"
+	"agent-service-prototype/pkg/rbac"
 	"agent-service-prototype/pkg/setup"
+	"agent-service-prototype/pkg/utils"
 
 	"github.com/labstack/echo/v4"
 )
 
 type Controller struct {
 	service *service.Service
+	cfg     *config.Config
 }
 
-func NewController(svc *service.Service) *Controller {
-	return &Controller{service: svc}
+func NewController(svc *service.Service, cfg *config.Config) *Controller {
+	return &Controller{service: svc, cfg: cfg}
 }
 
-// Installation handles POST /setup/installation
+// Installation handles POST /setup/installation.
+// An optional body {"bundle_id": ...} or {"bundle_url": ...} selects the bundle;
+// without it the configured BUNDLE_URL is installed. A bundle_url fetches an arbitrary
+// bundle and needs the bundle_upload permission. With "mode": "multi" the bundle
+// is installed on every target in "targets" (or DB_TARGETS). "force": true re-applies
+// migrations that already succeeded and, like FORCE=true, needs the force permission.
 func (c *Controller) Installation(ctx echo.Context) error {
-	if !c.service.TryStart() {
-		s := c.service.GetStatus()
-		return ctx.JSON(http.StatusConflict, setup.NewStatusPayload(s.Status, s.Step, s.Error, s.StartedAt, s.FinishedAt))
+	var req dto.InstallationRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err.Error(), "INVALID_REQUEST")
+	}
+	if (req.Force || c.cfg.HTTP.Force) && !middleware.Can(ctx, rbac.PermForce) {
+		return middleware.Forbidden(ctx, rbac.PermForce)
+	}
+	if resp := c.checkBundleSelection(ctx, req.BundleID, req.BundleURL); resp != nil {
+		return resp
+	}
+	opts := service.InstallOptions{
+		BundleID:  req.BundleID,
+		BundleURL: req.BundleURL,
+		Backup:    req.Backup,
+		Force:     req.Force,
+		Actor:     actor(ctx),
 	}
 
-	result := c.service.RunInstallation(ctx.Request().Context())
+	switch req.Mode {
+	case "", "single":
+	case "multi":
+		return c.multiInstallation(ctx, req, opts)
+	default:
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid installation mode", req.Mode, "INVALID_REQUEST")
+	}
+
+	if !c.service.TryStart(opts.Actor) {
+		return ctx.JSON(http.StatusConflict, statusPayload(c.service.GetStatus()))
+	}
+
+	result := c.service.RunInstallation(ctx.Request().Context(), opts)
 
 	if result.Success {
 		return ctx.JSON(http.StatusOK, dto.InstallationSuccess{
+			RunID:           result.RunID,
 			Status:          "SUCCESS",
 			SchemaVersion:   result.SchemaVersion,
+			BundleID:        result.BundleID,
+			BundleVersion:   result.BundleVersion,
 			DurationSeconds: result.Duration.Seconds(),
+			TraceID:         result.TraceID,
 		})
 	}
 
 	return ctx.JSON(http.StatusOK, dto.InstallationFailed{
-		Status: "FAILED",
-		Step:   result.Step,
-		Error:  result.Error,
+		RunID:    result.RunID,
+		Status:   "FAILED",
+		Step:     result.Step,
+		Error:    result.Error,
+		BundleID: result.BundleID,
+		TraceID:  result.TraceID,
 	})
 }
 
+func (c *Controller) multiInstallation(ctx echo.Context, req dto.InstallationRequest, opts service.InstallOptions) error {
+	requested := make([]string, 0, len(req.Targets))
+	for _, t := range req.Targets {
+		requested = append(requested, t.Name)
+	}
+	targets, err := c.service.ResolveTargets(requested)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid installation targets", err.Error(), "INVALID_REQUEST")
+	}
+
+	if !c.service.TryStart(opts.Actor) {
+		return ctx.JSON(http.StatusConflict, statusPayload(c.service.GetStatus()))
+	}
+
+	result := c.service.RunMultiInstallation(ctx.Request().Context(), opts, targets, req.Concurrency)
+
+	resp := dto.MultiInstallationResult{
+		RunID:           result.RunID,
+		Status:          "SUCCESS",
+		Step:            result.Step,
+		Error:           result.Error,
+		BundleID:        result.BundleID,
+		BundleVersion:   result.BundleVersion,
+		Succeeded:       result.Succeeded,
+		Failed:          result.Failed,
+		Targets:         make([]dto.TargetResult, 0, len(result.Targets)),
+		DurationSeconds: result.Duration.Seconds(),
+		TraceID:         result.TraceID,
+	}
+	if !result.Success {
+		resp.Status = "FAILED"
+	}
+	for _, t := range result.Targets {
+		if t.InstallationResult == nil {
+			continue
+		}
+		tr := dto.TargetResult{
+			Target:          t.Target,
+			Status:          "SUCCESS",
+			SchemaVersion:   t.SchemaVersion,
+			DurationSeconds: t.Duration.Seconds(),
+		}
+		if !t.Success {
+			tr.Status = "FAILED"
+			tr.Step = t.Step
+			tr.Error = t.Error
+		}
+		resp.Targets = append(resp.Targets, tr)
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
 // Status handles GET /setup/status
 func (c *Controller) Status(ctx echo.Context) error {
-	s := c.service.GetStatus()
-	if s == nil {
-		return ctx.JSON(http.StatusOK, setup.NewStatusPayload("idle", "", "", time.Time{}, time.Time{}))
+	var p setup.StatusPayload
+	if s := c.service.GetStatus(); s != nil {
+		p = statusPayload(s)
+	} else {
+		p = setup.NewStatusPayload("idle", "", "", time.Time{}, time.Time{})
+	}
+	if u := c.service.GetUpdateCheck(); u != nil {
+		p.Updates = &setup.UpdateStatus{
+			LastCheckAt:            timePtr(u.LastCheckAt),
+			LastError:              u.LastError,
+			AvailableVersion:       u.AvailableVersion,
+			AvailableBundleID:      u.AvailableBundleID,
+			AvailableSchemaVersion: u.AvailableSchemaVersion,
+			InstalledSchemaVersion: u.InstalledSchemaVersion,
+			InstalledVersion:       u.InstalledVersion,
+			UpgradePending:         u.UpgradePending,
+			NextWindowStart:        timePtr(u.NextWindowStart),
+			NextWindowEnd:          timePtr(u.NextWindowEnd),
+		}
+	}
+	return ctx.JSON(http.StatusOK, p)
+}
+
+// timePtr returns nil for the zero time, so it is omitted from JSON.
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func statusPayload(s *service.RunStatus) setup.StatusPayload {
+	p := setup.NewStatusPayload(s.Status, s.Step, s.Error, s.StartedAt, s.FinishedAt)
+	p.BundleID = s.BundleID
+	p.BundleVersion = s.BundleVersion
+	p.LockHolder = s.LockHolder
+	p.Targets = s.Targets
+	p.StartedBy = s.Actor.User
+	p.StartedByRole = s.Actor.Role
+	return p
+}
+
+// UploadBundle handles POST /setup/bundles (multipart field "file").
+func (c *Controller) UploadBundle(ctx echo.Context) error {
+	fh, err := ctx.FormFile("file")
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Bundle file is required", err.Error(), "INVALID_REQUEST")
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid bundle upload", err.Error(), "INVALID_BUNDLE")
+	}
+	defer f.Close()
+
+	b, err := c.service.UploadBundle(f)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusUnprocessableEntity, "Failed to store bundle", err.Error(), "INVALID_BUNDLE")
+	}
+	return ctx.JSON(http.StatusCreated, b)
+}
+
+// ListBundles handles GET /setup/bundles
+func (c *Controller) ListBundles(ctx echo.Context) error {
+	bundles, err := c.service.ListBundles()
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to list bundles", err.Error(), "INTERNAL_ERROR")
+	}
+	return ctx.JSON(http.StatusOK, dto.BundleList{Bundles: bundles})
+}
+
+// InspectBundle handles POST /setup/bundles/inspect.
+// Accepts a multipart "file" upload or a "url" (JSON or form field). Fetching a url needs
+// the bundle_upload permission, like POST /setup/bundles.
+func (c *Controller) InspectBundle(ctx echo.Context) error {
+	var src service.BundleSource
+	if fh, err := ctx.FormFile("file"); err == nil {
+		f, err := fh.Open()
+		if err != nil {
+			return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid bundle upload", err.Error(), "INVALID_BUNDLE")
+		}
+		defer f.Close()
+		src.Upload = f
+	} else {
+		var req dto.InspectBundleRequest
+		if err := ctx.Bind(&req); err != nil {
+			return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err.Error(), "INVALID_REQUEST")
+		}
+		src.URL = req.URL
+	}
+	if src.Upload == nil && src.URL == "" {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Bundle file or url is required", "missing file and url", "INVALID_REQUEST")
+	}
+	if src.URL != "" && !middleware.Can(ctx, rbac.PermBundleUpload) {
+		return middleware.Forbidden(ctx, rbac.PermBundleUpload)
+	}
+
+	ins, err := c.service.InspectBundle(ctx.Request().Context(), src)
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusUnprocessableEntity, "Failed to inspect bundle", err.Error(), "INVALID_BUNDLE")
+	}
+
+	resp := dto.BundleInspection{
+		SHA256:      ins.SHA256,
+		Manifest:    ins.Manifest,
+		Checksums:   ins.Checksums,
+		ChecksumsOK: ins.ChecksumsOK,
+		LintErrors:  ins.LintErrors,
+		Valid:       ins.ChecksumsOK && len(ins.LintErrors) == 0,
+		FreshDB:     ins.FreshDB,
+		Diff:        make([]dto.MigrationDiff, 0, len(ins.Diff)),
+		DiffError:   ins.DiffError,
+	}
+	for _, d := range ins.Diff {
+		item := dto.MigrationDiff{
+			Version:          d.Version,
+			Name:             d.Name,
+			State:            d.State,
+			BundleChecksum:   d.BundleChecksum,
+			RecordedChecksum: d.RecordedChecksum,
+		}
+		if !d.AppliedAt.IsZero() {
+			t := d.AppliedAt
+			item.AppliedAt = &t
+		}
+		resp.Diff = append(resp.Diff, item)
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// Drift handles GET /setup/drift?against=installed|bundle&bundle_id=...&app=...
+func (c *Controller) Drift(ctx echo.Context) error {
+	against := ctx.QueryParam("against")
+	if against == "" {
+		against = service.DriftAgainstInstalled
+	}
+	if against != service.DriftAgainstInstalled && against != service.DriftAgainstBundle {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid drift source", "against must be installed or bundle", "INVALID_REQUEST")
+	}
+
+	report, err := c.service.DetectDrift(ctx.Request().Context(), against, ctx.QueryParam("bundle_id"), ctx.QueryParam("app"))
+	if errors.Is(err, service.ErrSnapshotNotFound) || errors.Is(err, setup.ErrBundleNotFound) {
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "Expected snapshot not available", err.Error(), "SNAPSHOT_NOT_FOUND")
+	}
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to detect drift", err.Error(), "INTERNAL_ERROR")
+	}
+
+	resp := dto.DriftReport{
+		Against:       report.Against,
+		BundleID:      report.BundleID,
+		SchemaVersion: report.SchemaVersion,
+		Drifted:       len(report.Items) > 0,
+		Items:         report.Items,
+	}
+	if !report.ExpectedAt.IsZero() {
+		t := report.ExpectedAt
+		resp.ExpectedAt = &t
+	}
+	for _, item := range report.Items {
+		switch item.Change {
+		case setup.DriftAdded:
+			resp.Summary.Added++
+		case setup.DriftRemoved:
+			resp.Summary.Removed++
+		case setup.DriftChanged:
+			resp.Summary.Changed++
+		}
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// ListBackups handles GET /setup/backups
+func (c *Controller) ListBackups(ctx echo.Context) error {
+	backups, err := c.service.ListBackups()
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to list backups", err.Error(), "INTERNAL_ERROR")
+	}
+	return ctx.JSON(http.StatusOK, dto.BackupList{Backups: backups})
+}
+
+// RestoreBackup handles POST /setup/backups/:id/restore.
+// An optional body {"tables": ["hris.employees"]} restores only those tables.
+func (c *Controller) RestoreBackup(ctx echo.Context) error {
+	var req dto.RestoreBackupRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err.Error(), "INVALID_REQUEST")
+	}
+
+	m, err := c.service.RestoreBackup(ctx.Request().Context(), ctx.Param("id"), req.Tables, actor(ctx))
+	if errors.Is(err, backup.ErrBackupNotFound) {
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "Backup not found", err.Error(), "BACKUP_NOT_FOUND")
+	}
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to restore backup", err.Error(), "RESTORE_FAILED")
+	}
+	return ctx.JSON(http.StatusOK, m)
+}
+
+// ListTenants handles GET /setup/tenants
+func (c *Controller) ListTenants(ctx echo.Context) error {
+	tenants, err := c.service.ListTenants(ctx.Request().Context())
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to list tenants", err.Error(), "INTERNAL_ERROR")
+	}
+	resp := dto.TenantList{Tenants: make([]dto.Tenant, 0, len(tenants))}
+	for _, t := range tenants {
+		resp.Tenants = append(resp.Tenants, tenantPayload(t))
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// CreateTenant handles POST /setup/tenants with body {"id": "acme"}.
+// The tenant schema is provisioned at the schema version of the registered tenants.
+func (c *Controller) CreateTenant(ctx echo.Context) error {
+	var req dto.CreateTenantRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err.Error(), "INVALID_REQUEST")
+	}
+
+	t, err := c.service.CreateTenant(ctx.Request().Context(), req.ID, actor(ctx))
+	switch {
+	case errors.Is(err, service.ErrInvalidTenantID):
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid tenant id", err.Error(), "INVALID_REQUEST")
+	case errors.Is(err, service.ErrTenantExists):
+		return utils.ErrorResponse(ctx, http.StatusConflict, "Tenant already exists", err.Error(), "TENANT_EXISTS")
+	case errors.Is(err, service.ErrNoInstalledBundle), errors.Is(err, service.ErrTenantsNotSupported), errors.Is(err, service.ErrNoDefaultSchema):
+		return utils.ErrorResponse(ctx, http.StatusUnprocessableEntity, "Tenants are not available", err.Error(), "TENANTS_NOT_SUPPORTED")
+	case err != nil:
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to create tenant", err.Error(), "TENANT_CREATE_FAILED")
+	}
+	return ctx.JSON(http.StatusCreated, tenantPayload(*t))
+}
+
+// DeleteTenant handles DELETE /setup/tenants/:id. The tenant schema is dropped and the deletion is audited.
+func (c *Controller) DeleteTenant(ctx echo.Context) error {
+	err := c.service.DeleteTenant(ctx.Request().Context(), ctx.Param("id"), actor(ctx))
+	if errors.Is(err, service.ErrTenantNotFound) {
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "Tenant not found", err.Error(), "TENANT_NOT_FOUND")
+	}
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to delete tenant", err.Error(), "TENANT_DELETE_FAILED")
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func tenantPayload(t repository.Tenant) dto.Tenant {
+	return dto.Tenant{
+		ID:            t.ID,
+		Schema:        t.Schema,
+		SchemaVersion: t.SchemaVersion,
+		CreatedAt:     t.CreatedAt,
+		UpdatedAt:     t.UpdatedAt,
+	}
+}
+
+// Adopt handles POST /setup/adopt with body {"version": ..., "bundle_id"|"bundle_url": ...}.
+// The baseline and migrations up to version are recorded as applied without running them.
+func (c *Controller) Adopt(ctx echo.Context) error {
+	var req dto.AdoptRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err.Error(), "INVALID_REQUEST")
+	}
+	if req.Version == "" {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Version is required", "missing version", "INVALID_REQUEST")
+	}
+	if resp := c.checkBundleSelection(ctx, req.BundleID, req.BundleURL); resp != nil {
+		return resp
+	}
+
+	result, err := c.service.Adopt(ctx.Request().Context(), service.InstallOptions{BundleID: req.BundleID, BundleURL: req.BundleURL, Actor: actor(ctx)}, req.Version)
+	if err != nil {
+		return maintenanceError(ctx, "Failed to adopt database", err)
+	}
+	return ctx.JSON(http.StatusOK, dto.AdoptResult{
+		BundleID:       result.BundleID,
+		BundleVersion:  result.BundleVersion,
+		Version:        result.Version,
+		Adopted:        result.Adopted,
+		AlreadyApplied: result.AlreadyApplied,
+	})
+}
+
+// Repair handles POST /setup/repair with optional body {"versions": [...], "bundle_id"|"bundle_url": ...,
+// "previous_bundle_id": ...}. Checksums of migrations changed only in whitespace are re-recorded.
+func (c *Controller) Repair(ctx echo.Context) error {
+	var req dto.RepairRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err.Error(), "INVALID_REQUEST")
+	}
+	if resp := c.checkBundleSelection(ctx, req.BundleID, req.BundleURL); resp != nil {
+		return resp
+	}
+
+	if req.PreviousBundleID != "" && !c.service.HasBundle(req.PreviousBundleID) {
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "Bundle not found", req.PreviousBundleID, "BUNDLE_NOT_FOUND")
+	}
+
+	result, err := c.service.Repair(ctx.Request().Context(), service.InstallOptions{BundleID: req.BundleID, BundleURL: req.BundleURL, Actor: actor(ctx)}, req.Versions, req.PreviousBundleID)
+	if err != nil {
+		return maintenanceError(ctx, "Failed to repair checksums", err)
+	}
+	resp := dto.RepairResult{
+		BundleID: result.BundleID,
+		Repaired: make([]dto.RepairItem, 0, len(result.Repaired)),
+		Skipped:  make([]dto.RepairItem, 0, len(result.Skipped)),
+	}
+	for _, it := range result.Repaired {
+		resp.Repaired = append(resp.Repaired, dto.RepairItem(it))
+	}
+	for _, it := range result.Skipped {
+		resp.Skipped = append(resp.Skipped, dto.RepairItem(it))
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// VerifyAudit handles GET /setup/audit/verify: it checks the HMAC chain of the installer's
+// rows in AUDIT_SCHEMA.audit_logs against ?anchor_id=&anchor_hash= (a link recorded outside the
+// agent), or against the anchor the agent saved in WORK_DIR.
+func (c *Controller) VerifyAudit(ctx echo.Context) error {
+	var anchor *repository.AuditLink
+	if id, hash := ctx.QueryParam("anchor_id"), ctx.QueryParam("anchor_hash"); id != "" || hash != "" {
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil || n <= 0 || hash == "" {
+			return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid audit anchor", "anchor_id and anchor_hash must be set together", "INVALID_REQUEST")
+		}
+		anchor = &repository.AuditLink{ID: n, Hash: hash}
+	}
+
+	report, err := c.service.VerifyAudit(ctx.Request().Context(), anchor)
+	if errors.Is(err, service.ErrAuditUnavailable) {
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "Audit log not available", err.Error(), "AUDIT_NOT_FOUND")
+	}
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusInternalServerError, "Failed to verify audit log", err.Error(), "INTERNAL_ERROR")
+	}
+	return ctx.JSON(http.StatusOK, dto.AuditVerification{
+		Valid:     report.Valid,
+		Checked:   report.Checked,
+		Unchained: report.Unchained,
+		BrokenAt:  report.BrokenAt,
+		Reason:    report.Reason,
+		LastID:    report.LastID,
+		LastHash:  report.LastHash,
+		Anchored:  report.Anchored,
+	})
+}
+
+// Livez handles GET /livez: the process is up and serving HTTP. It does not touch the database.
+func (c *Controller) Livez(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readyz handles GET /readyz: 200 when every readiness check passes, 503 otherwise.
+func (c *Controller) Readyz(ctx echo.Context) error {
+	r := c.service.Readiness(ctx.Request().Context())
+	resp := dto.Readiness{Status: "ready", Checks: make([]dto.ReadinessCheck, 0, len(r.Checks))}
+	for _, check := range r.Checks {
+		resp.Checks = append(resp.Checks, dto.ReadinessCheck(check))
+	}
+	if !r.Ready {
+		resp.Status = "not_ready"
+		return ctx.JSON(http.StatusServiceUnavailable, resp)
+	}
+	return ctx.JSON(http.StatusOK, resp)
+}
+
+// Login handles POST /auth/login with body {"username": ..., "password": ...}. On success a
+// new session is started and its ID is set as the nuha_session cookie; a session the client
+// already had is ended.
+func (c *Controller) Login(ctx echo.Context) error {
+	var req dto.LoginRequest
+	if err := ctx.Bind(&req); err != nil {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Invalid request body", err.Error(), "INVALID_REQUEST")
+	}
+	if req.Username == "" || req.Password == "" {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "username and password are required", "missing username or password", "INVALID_REQUEST")
+	}
+	reqCtx := ctx.Request().Context()
+	sess, err := c.service.Login(reqCtx, req.Username, req.Password, middleware.SessionClientOf(ctx))
+	if errors.Is(err, service.ErrInvalidCredentials) {
+		return utils.ErrorResponse(ctx, http.StatusUnauthorized, "Login failed", err.Error(), "INVALID_CREDENTIALS")
+	}
+	if err != nil {
+		return utils.ErrorResponse(ctx, http.StatusServiceUnavailable, "Login failed", err.Error(), "SESSION_UNAVAILABLE")
+	}
+	if old, err := ctx.Cookie(utils.SessionCookieName); err == nil && old.Value != "" {
+		_ = c.service.Logout(reqCtx, old.Value)
+	}
+	middleware.SetSessionCookie(ctx, c.cfg, sess)
+	return ctx.JSON(http.StatusOK, dto.LoginResponse{Username: sess.Username, Role: string(sess.Role), ExpiresAt: sess.ExpiresAt})
+}
+
+// Logout handles POST /auth/logout: the session of the nuha_session cookie is ended and the
+// cookie cleared. It succeeds without a session too.
+func (c *Controller) Logout(ctx echo.Context) error {
+	if cookie, err := ctx.Cookie(utils.SessionCookieName); err == nil && cookie.Value != "" {
+		if err := c.service.Logout(ctx.Request().Context(), cookie.Value); err != nil {
+			return utils.ErrorResponse(ctx, http.StatusServiceUnavailable, "Logout failed", err.Error(), "SESSION_UNAVAILABLE")
+		}
+	}
+	middleware.ClearSessionCookie(ctx, c.cfg)
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Me handles GET /auth/me: the authenticated caller.
+func (c *Controller) Me(ctx echo.Context) error {
+	p := middleware.PrincipalFromContext(ctx.Request().Context())
+	if p == nil {
+		return utils.ErrorResponse(ctx, http.StatusUnauthorized, "Authentication required", "not logged in", "UNAUTHORIZED")
+	}
+	return ctx.JSON(http.StatusOK, dto.CurrentUser{Username: p.Subject, Method: p.Method, Role: string(p.Role)})
+}
+
+// actor identifies the caller for the audit trail: the authenticated principal and its role
+// (the X-User-ID header only when AUTH_DISABLED is set) and the X-Request-ID.
+func actor(ctx echo.Context) service.Actor {
+	requestID := ctx.Request().Header.Get(echo.HeaderXRequestID)
+	if requestID == "" {
+		requestID = ctx.Response().Header().Get(echo.HeaderXRequestID)
+	}
+	a := service.Actor{RequestID: requestID}
+	if p := middleware.PrincipalFromContext(ctx.Request().Context()); p != nil {
+		a.User, a.Role = p.Subject, string(p.Role)
+	}
+	return a
+}
+
+// checkBundleSelection validates an optional bundle_id / bundle_url pair. A bundle_url makes
+// the service fetch and cache an arbitrary bundle, so it needs the bundle_upload permission.
+// It returns nil when the selection is usable.
+func (c *Controller) checkBundleSelection(ctx echo.Context, bundleID, bundleURL string) error {
+	if bundleURL != "" && !middleware.Can(ctx, rbac.PermBundleUpload) {
+		return middleware.Forbidden(ctx, rbac.PermBundleUpload)
+	}
+	if bundleID != "" && bundleURL != "" {
+		return utils.ErrorResponse(ctx, http.StatusBadRequest, "Only one of bundle_id or bundle_url may be set", "both bundle_id and bundle_url set", "INVALID_REQUEST")
+	}
+	if bundleID != "" && !c.service.HasBundle(bundleID) {
+		return utils.ErrorResponse(ctx, http.StatusNotFound, "Bundle not found", bundleID, "BUNDLE_NOT_FOUND")
+	}
+	return nil
+}
+
+// maintenanceError maps adopt/repair errors to responses.
+func maintenanceError(ctx echo.Context, message string, err error) error {
+	switch {
+	case errors.Is(err, service.ErrSchemaMismatch):
+		return utils.ErrorResponse(ctx, http.StatusConflict, message, err.Error(), "SCHEMA_MISMATCH")
+	case errors.Is(err, service.ErrUnknownVersion), errors.Is(err, service.ErrTenantScopedOnly), errors.Is(err, service.ErrOtherApp):
+		return utils.ErrorResponse(ctx, http.StatusUnprocessableEntity, message, err.Error(), "INVALID_REQUEST")
+	case errors.Is(err, service.ErrLockTimeout):
+		return utils.ErrorResponse(ctx, http.StatusConflict, message, err.Error(), "LOCK_TIMEOUT")
 	}
-	return ctx.JSON(http.StatusOK, setup.NewStatusPayload(s.Status, s.Step, s.Error, s.StartedAt, s.FinishedAt))
+	return utils.ErrorResponse(ctx, http.StatusInternalServerError, message, err.Error(), "INTERNAL_ERROR")
 }
@@ -1,17 +1,70 @@
 package dto
 
-import "time"
+import (
+	"time"
+
+	"agent-service-prototype/pkg/backup"
+	"agent-service-prototype/pkg/setup"
+)
+
+type InstallationRequest struct {
+	BundleID  string `json:"bundle_id"`
+	BundleURL string `json:"bundle_url"`
+	Backup    bool   `json:"backup"`
+	// Force re-applies migrations that already succeeded; it needs the force permission.
+	Force bool `json:"force"`
+	// Mode is "single" (default) or "multi".
+	Mode        string          `json:"mode"`
+	Targets     []InstallTarget `json:"targets"`
+	Concurrency int             `json:"concurrency"`
+}
+
+// InstallTarget names a database for a multi-target installation. The name is looked up
+// in DB_TARGETS; connection strings are never taken from the request.
+type InstallTarget struct {
+	Name string `json:"name"`
+}
 
 type InstallationSuccess struct {
+	RunID           string  `json:"run_id"`
 	Status          string  `json:"status"`
 	SchemaVersion   string  `json:"schema_version"`
+	BundleID        string  `json:"bundle_id"`
+	BundleVersion   string  `json:"bundle_version,omitempty"`
 	DurationSeconds float64 `json:"duration_seconds"`
+	TraceID         string  `json:"trace_id,omitempty"`
 }
 
 type InstallationFailed struct {
-	Status string `json:"status"`
-	Step   string `json:"step"`
-	Error  string `json:"error"`
+	RunID    string `json:"run_id"`
+	Status   string `json:"status"`
+	Step     string `json:"step"`
+	Error    string `json:"error"`
+	BundleID string `json:"bundle_id,omitempty"`
+	TraceID  string `json:"trace_id,omitempty"`
+}
+
+type TargetResult struct {
+	Target          string  `json:"target"`
+	Status          string  `json:"status"`
+	Step            string  `json:"step,omitempty"`
+	Error           string  `json:"error,omitempty"`
+	SchemaVersion   string  `json:"schema_version,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+type MultiInstallationResult struct {
+	RunID           string         `json:"run_id"`
+	Status          string         `json:"status"`
+	Step            string         `json:"step,omitempty"`
+	Error           string         `json:"error,omitempty"`
+	BundleID        string         `json:"bundle_id,omitempty"`
+	BundleVersion   string         `json:"bundle_version,omitempty"`
+	Succeeded       int            `json:"succeeded"`
+	Failed          int            `json:"failed"`
+	Targets         []TargetResult `json:"targets"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	TraceID         string         `json:"trace_id,omitempty"`
 }
 
 type SetupStatus struct {
@@ -21,3 +74,157 @@ type SetupStatus struct {
 	StartedAt  *time.Time `json:"started_at,omitempty"`
 	FinishedAt *time.Time `json:"finished_at,omitempty"`
 }
+
+type BundleList struct {
+	Bundles []setup.BundleMeta `json:"bundles"`
+}
+
+type InspectBundleRequest struct {
+	URL string `json:"url" form:"url"`
+}
+
+type MigrationDiff struct {
+	Version          string     `json:"version"`
+	Name             string     `json:"name,omitempty"`
+	State            string     `json:"state"`
+	BundleChecksum   string     `json:"bundle_checksum,omitempty"`
+	RecordedChecksum string     `json:"recorded_checksum,omitempty"`
+	AppliedAt        *time.Time `json:"applied_at,omitempty"`
+}
+
+type BundleInspection struct {
+	SHA256      string                 `json:"sha256"`
+	Manifest    *setup.Manifest        `json:"manifest"`
+	Checksums   []setup.ChecksumResult `json:"checksums"`
+	ChecksumsOK bool                   `json:"checksums_ok"`
+	LintErrors  []string               `json:"lint_errors"`
+	Valid       bool                   `json:"valid"`
+	FreshDB     bool                   `json:"fresh_db"`
+	Diff        []MigrationDiff        `json:"diff"`
+	DiffError   string                 `json:"diff_error,omitempty"`
+}
+
+type DriftSummary struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+	Changed int `json:"changed"`
+}
+
+type DriftReport struct {
+	Against       string            `json:"against"`
+	BundleID      string            `json:"bundle_id,omitempty"`
+	SchemaVersion string            `json:"schema_version,omitempty"`
+	ExpectedAt    *time.Time        `json:"expected_at,omitempty"`
+	Drifted       bool              `json:"drifted"`
+	Summary       DriftSummary      `json:"summary"`
+	Items         []setup.DriftItem `json:"items"`
+}
+
+type BackupList struct {
+	Backups []backup.Manifest `json:"backups"`
+}
+
+type RestoreBackupRequest struct {
+	Tables []string `json:"tables"`
+}
+
+type Tenant struct {
+	ID            string    `json:"id"`
+	Schema        string    `json:"schema"`
+	SchemaVersion string    `json:"schema_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type TenantList struct {
+	Tenants []Tenant `json:"tenants"`
+}
+
+type CreateTenantRequest struct {
+	ID string `json:"id"`
+}
+
+type AdoptRequest struct {
+	BundleID  string `json:"bundle_id"`
+	BundleURL string `json:"bundle_url"`
+	Version   string `json:"version"`
+}
+
+type AdoptResult struct {
+	BundleID       string   `json:"bundle_id"`
+	BundleVersion  string   `json:"bundle_version,omitempty"`
+	Version        string   `json:"version"`
+	Adopted        []string `json:"adopted"`
+	AlreadyApplied []string `json:"already_applied"`
+}
+
+type RepairRequest struct {
+	BundleID  string   `json:"bundle_id"`
+	BundleURL string   `json:"bundle_url"`
+	Versions  []string `json:"versions"`
+	// PreviousBundleID is the cached bundle the migrations were applied from.
+	PreviousBundleID string `json:"previous_bundle_id"`
+}
+
+type RepairItem struct {
+	Version     string `json:"version"`
+	Name        string `json:"name,omitempty"`
+	OldChecksum string `json:"old_checksum,omitempty"`
+	NewChecksum string `json:"new_checksum,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+type RepairResult struct {
+	BundleID string       `json:"bundle_id"`
+	Repaired []RepairItem `json:"repaired"`
+	Skipped  []RepairItem `json:"skipped"`
+}
+
+// AuditVerification is the response of GET /setup/audit/verify.
+type AuditVerification struct {
+	Valid     bool   `json:"valid"`
+	Checked   int    `json:"checked"`
+	Unchained int    `json:"unchained"`
+	BrokenAt  int64  `json:"broken_at,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	LastID    int64  `json:"last_id,omitempty"`
+	LastHash  string `json:"last_hash,omitempty"`
+	// Anchored is set when the chain was checked against an anchor, so truncation is detected.
+	Anchored bool `json:"anchored"`
+}
+
+// ReadinessCheck is one check of GET /readyz.
+type ReadinessCheck struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Required string `json:"required,omitempty"`
+	RunID    string `json:"run_id,omitempty"`
+}
+
+// Readiness is the response of GET /readyz.
+type Readiness struct {
+	Status string           `json:"status"`
+	Checks []ReadinessCheck `json:"checks"`
+}
+
+// LoginRequest is the body of POST /auth/login.
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// LoginResponse is the response of POST /auth/login; the session ID is only sent as cookie.
+type LoginResponse struct {
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CurrentUser is the response of GET /auth/me.
+type CurrentUser struct {
+	Username string `json:"username"`
+	Method   string `json:"method"`
+	Role     string `json:"role"`
+}
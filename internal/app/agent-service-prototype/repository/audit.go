@@ -0,0 +1,312 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrAuditTableMissing is returned when the audit_logs table does not exist (yet), e.g. before
+// the baseline has been applied or in a database whose bundle does not create it.
+var ErrAuditTableMissing = errors.New("audit_logs table does not exist")
+
+// ErrAuditKeyRequired is returned by WriteAuditLog without a key when the chain is already
+// keyed: an unkeyed row after a keyed one would break the chain.
+var ErrAuditKeyRequired = errors.New("the audit chain is HMAC-signed but no AUDIT_HMAC_KEY is set")
+
+// Payload keys that chain audit rows together; they are excluded from the hashed payload.
+const (
+	auditPrevHashKey = "prev_hash"
+	auditHashKey     = "hash"
+	auditHashAlgKey  = "hash_alg"
+)
+
+// auditHashAlg marks rows whose hash is an HMAC; rows without it were written before the chain
+// was keyed and carry a plain SHA-256.
+const auditHashAlg = "hmac-sha256"
+
+// AuditChain locates the audit_logs table and holds the key its chain is signed with. Without a
+// key rows are chained with a plain SHA-256, as before the chain was keyed.
+type AuditChain struct {
+	Schema string
+	Key    []byte
+}
+
+// table is the quoted, schema-qualified audit_logs table.
+func (c AuditChain) table() string {
+	return pq.QuoteIdentifier(c.Schema) + ".audit_logs"
+}
+
+// AuditEntry is one row for audit_logs.
+type AuditEntry struct {
+	Entity   string
+	EntityID string
+	Action   string
+	Payload  map[string]any
+}
+
+// AuditLink identifies a chained row by id and hash. The latest link is kept outside the
+// database as an anchor, so deleting rows from the end of the chain can be detected.
+type AuditLink struct {
+	ID   int64  `json:"id"`
+	Hash string `json:"hash"`
+}
+
+// AuditChainReport is the outcome of VerifyAuditChain.
+type AuditChainReport struct {
+	Valid     bool
+	Checked   int   // chained rows verified
+	Unchained int   // rows without a hash, written by something other than the installer
+	BrokenAt  int64 // id of the first row that fails verification, 0 when valid
+	Reason    string
+	LastID    int64
+	LastHash  string
+	// Anchored is set when the chain was checked against an anchor.
+	Anchored bool
+}
+
+// auditRow is one audit_logs row as VerifyAuditChain reads it.
+type auditRow struct {
+	ID        int64
+	Entity    string
+	EntityID  string
+	Action    string
+	Payload   []byte
+	CreatedAt time.Time
+}
+
+// WriteAuditLog inserts entry into audit_logs (created by the baseline) as the next link of an
+// HMAC chain: the payload gets prev_hash (the hash of the previous chained row) and hash, the
+// HMAC-SHA256 under chain.Key of prev_hash, created_at, entity, entity_id, action and the rest of
+// the payload. Writers are serialised by a transaction-level advisory lock so they cannot fork
+// the chain. It returns the new link for the caller to anchor.
+func (r *Repository) WriteAuditLog(ctx context.Context, conn *sql.Conn, chain AuditChain, entry AuditEntry) (AuditLink, error) {
+	table := chain.table()
+	if err := auditTableExists(ctx, conn, table); err != nil {
+		return AuditLink{}, err
+	}
+
+	payload, err := canonicalAuditPayload(entry.Payload)
+	if err != nil {
+		return AuditLink{}, err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return AuditLink{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('audit_chain'), hashtext($1))`, table); err != nil {
+		return AuditLink{}, fmt.Errorf("failed to lock audit chain: %w", err)
+	}
+	var prevHash, prevAlg string
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT payload->>'hash', COALESCE(payload->>'hash_alg', '') FROM %s
+		WHERE payload ? 'hash'
+		ORDER BY id DESC
+		LIMIT 1
+	`, table)).Scan(&prevHash, &prevAlg)
+	if err != nil && err != sql.ErrNoRows {
+		return AuditLink{}, err
+	}
+	if len(chain.Key) == 0 && prevAlg != "" {
+		return AuditLink{}, ErrAuditKeyRequired
+	}
+
+	createdAt := time.Now().UTC().Truncate(time.Microsecond)
+	payload[auditPrevHashKey] = prevHash
+	if len(chain.Key) > 0 {
+		payload[auditHashAlgKey] = auditHashAlg
+	}
+	hash := auditHash(chain.Key, prevHash, createdAt, entry.Entity, entry.EntityID, entry.Action, payload)
+	payload[auditHashKey] = hash
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return AuditLink{}, fmt.Errorf("failed to encode audit payload: %w", err)
+	}
+	var id int64
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(`
+		INSERT INTO %s (entity, entity_id, action, payload, created_at)
+		VALUES ($1, NULLIF($2, ''), $3, $4::jsonb, $5)
+		RETURNING id
+	`, table), entry.Entity, entry.EntityID, entry.Action, string(data), createdAt).Scan(&id)
+	if err != nil {
+		return AuditLink{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return AuditLink{}, err
+	}
+	return AuditLink{ID: id, Hash: hash}, nil
+}
+
+// VerifyAuditChain walks audit_logs in id order and recomputes every chained row's HMAC.
+// Editing a chained row, or deleting or inserting one in the middle of the chain, is reported
+// at the first row whose hash or prev_hash no longer matches. When anchor is set, the row it
+// names must still exist with the anchored hash; otherwise the end of the chain was deleted.
+func (r *Repository) VerifyAuditChain(ctx context.Context, conn *sql.Conn, chain AuditChain, anchor *AuditLink) (*AuditChainReport, error) {
+	table := chain.table()
+	if err := auditTableExists(ctx, conn, table); err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf(`
+		SELECT id, entity, COALESCE(entity_id, ''), action, COALESCE(payload, 'null'::jsonb), created_at
+		FROM %s
+		ORDER BY id
+	`, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	v := newAuditVerifier(chain.Key, anchor)
+	for rows.Next() {
+		var row auditRow
+		if err := rows.Scan(&row.ID, &row.Entity, &row.EntityID, &row.Action, &row.Payload, &row.CreatedAt); err != nil {
+			return nil, err
+		}
+		v.add(row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return v.finish(), nil
+}
+
+func auditTableExists(ctx context.Context, conn *sql.Conn, table string) error {
+	var exists bool
+	if err := conn.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, table).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrAuditTableMissing
+	}
+	return nil
+}
+
+// auditVerifier checks rows one at a time, in id order.
+type auditVerifier struct {
+	key         []byte
+	anchor      *AuditLink
+	anchorFound bool
+	keyed       bool // an HMAC row has been seen; unkeyed rows after it are forgeries
+	report      AuditChainReport
+}
+
+func newAuditVerifier(key []byte, anchor *AuditLink) *auditVerifier {
+	return &auditVerifier{key: key, anchor: anchor, report: AuditChainReport{Valid: true, Anchored: anchor != nil}}
+}
+
+func (v *auditVerifier) fail(id int64, reason string) {
+	v.report.Valid, v.report.BrokenAt, v.report.Reason = false, id, reason
+}
+
+func (v *auditVerifier) add(row auditRow) {
+	if !v.report.Valid {
+		return
+	}
+
+	var payload map[string]any
+	dec := json.NewDecoder(bytes.NewReader(row.Payload))
+	dec.UseNumber()
+	if err := dec.Decode(&payload); err != nil || payload == nil {
+		v.report.Unchained++
+		return
+	}
+	hash, _ := payload[auditHashKey].(string)
+	if hash == "" {
+		v.report.Unchained++
+		return
+	}
+	prevHash, _ := payload[auditPrevHashKey].(string)
+	alg, _ := payload[auditHashAlgKey].(string)
+
+	var key []byte
+	switch {
+	case alg == auditHashAlg && len(v.key) == 0:
+		v.fail(row.ID, "row is HMAC-signed but no AUDIT_HMAC_KEY is set")
+		return
+	case alg == auditHashAlg:
+		key, v.keyed = v.key, true
+	case alg != "":
+		v.fail(row.ID, fmt.Sprintf("unknown hash_alg %q", alg))
+		return
+	case v.keyed:
+		v.fail(row.ID, "unkeyed row after the chain was keyed")
+		return
+	}
+
+	switch {
+	case prevHash != v.report.LastHash:
+		v.fail(row.ID, "prev_hash does not match the previous chained row")
+	case auditHash(key, prevHash, row.CreatedAt.UTC(), row.Entity, row.EntityID, row.Action, payload) != hash:
+		v.fail(row.ID, "hash does not match the row contents")
+	case v.anchor != nil && row.ID == v.anchor.ID && hash != v.anchor.Hash:
+		v.fail(row.ID, "hash does not match the anchor")
+	default:
+		v.report.Checked++
+		v.report.LastID, v.report.LastHash = row.ID, hash
+		if v.anchor != nil && row.ID == v.anchor.ID {
+			v.anchorFound = true
+		}
+	}
+}
+
+func (v *auditVerifier) finish() *AuditChainReport {
+	if v.report.Valid && v.anchor != nil && !v.anchorFound {
+		v.fail(v.anchor.ID, "anchored row is missing: the chain was truncated")
+	}
+	return &v.report
+}
+
+// canonicalAuditPayload copies payload through a JSON round trip, so the hash is computed over
+// the same values VerifyAuditChain reads back from the JSONB column.
+func canonicalAuditPayload(payload map[string]any) (map[string]any, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit payload: %w", err)
+	}
+	out := map[string]any{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to encode audit payload: %w", err)
+	}
+	if out == nil {
+		out = map[string]any{}
+	}
+	return out, nil
+}
+
+// auditHash chains one row to prevHash: an HMAC-SHA256 under key, or a plain SHA-256 for rows
+// written before the chain was keyed or without AUDIT_HMAC_KEY (empty key). Keys of payload other than hash and prev_hash
+// are hashed in the sorted order json.Marshal gives maps.
+func auditHash(key []byte, prevHash string, createdAt time.Time, entity, entityID, action string, payload map[string]any) string {
+	body := make(map[string]any, len(payload))
+	for k, v := range payload {
+		if k != auditHashKey && k != auditPrevHashKey {
+			body[k] = v
+		}
+	}
+	data, _ := json.Marshal(body)
+	h := sha256.New()
+	if len(key) > 0 {
+		h = hmac.New(sha256.New, key)
+	}
+	for _, part := range []string{prevHash, createdAt.Format(time.RFC3339Nano), entity, entityID, action} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+var testAuditKey = []byte("0123456789abcdef0123456789abcdef")
+
+// chainRows builds n rows chained the way WriteAuditLog writes them. Rows with index below
+// legacy are unkeyed SHA-256 links, as written before the chain was keyed.
+func chainRows(t *testing.T, n, legacy int) []auditRow {
+	t.Helper()
+	var rows []auditRow
+	prev := ""
+	created := time.Date(2026, 1, 2, 3, 4, 5, 6000, time.UTC)
+	for i := 0; i < n; i++ {
+		payload := map[string]any{"run_id": "run-1", "step": json.Number("1"), auditPrevHashKey: prev}
+		var key []byte
+		if i >= legacy {
+			payload[auditHashAlgKey] = auditHashAlg
+			key = testAuditKey
+		}
+		row := auditRow{ID: int64(i + 1), Entity: "schema_migration", EntityID: "0001", Action: "APPLY", CreatedAt: created}
+		hash := auditHash(key, prev, created, row.Entity, row.EntityID, row.Action, payload)
+		payload[auditHashKey] = hash
+		row.Payload = mustJSON(t, payload)
+		rows = append(rows, row)
+		prev = hash
+	}
+	return rows
+}
+
+func mustJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// rewrite decodes a row's payload, lets edit change it and encodes it again.
+func rewrite(t *testing.T, row auditRow, edit func(map[string]any)) auditRow {
+	t.Helper()
+	var payload map[string]any
+	if err := json.Unmarshal(row.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	edit(payload)
+	row.Payload = mustJSON(t, payload)
+	return row
+}
+
+func linkOf(t *testing.T, row auditRow) *AuditLink {
+	t.Helper()
+	var payload map[string]any
+	if err := json.Unmarshal(row.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	return &AuditLink{ID: row.ID, Hash: payload[auditHashKey].(string)}
+}
+
+func TestAuditHash(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	payload := map[string]any{"a": "b"}
+	base := auditHash(testAuditKey, "prev", created, "e", "1", "APPLY", payload)
+
+	tests := []struct {
+		name string
+		got  string
+	}{
+		{"other key", auditHash([]byte("another key of thirty-two bytes!"), "prev", created, "e", "1", "APPLY", payload)},
+		{"unkeyed", auditHash(nil, "prev", created, "e", "1", "APPLY", payload)},
+		{"other prev_hash", auditHash(testAuditKey, "prev2", created, "e", "1", "APPLY", payload)},
+		{"other created_at", auditHash(testAuditKey, "prev", created.Add(time.Microsecond), "e", "1", "APPLY", payload)},
+		{"other action", auditHash(testAuditKey, "prev", created, "e", "1", "FAIL", payload)},
+		{"other payload", auditHash(testAuditKey, "prev", created, "e", "1", "APPLY", map[string]any{"a": "c"})},
+		{"shifted field boundary", auditHash(testAuditKey, "prev", created, "e1", "", "APPLY", payload)},
+	}
+	for _, tt := range tests {
+		if tt.got == base {
+			t.Errorf("%s: hash did not change", tt.name)
+		}
+	}
+
+	withChainKeys := map[string]any{"a": "b", auditHashKey: "x", auditPrevHashKey: "y"}
+	if got := auditHash(testAuditKey, "prev", created, "e", "1", "APPLY", withChainKeys); got != base {
+		t.Errorf("hash and prev_hash payload keys must not be hashed")
+	}
+}
+
+func TestAuditVerifier(t *testing.T) {
+	rows := chainRows(t, 5, 0)
+	foreign := auditRow{ID: 99, Entity: "employee", Action: "UPDATE", Payload: []byte(`{"name":"x"}`)}
+
+	tests := []struct {
+		name      string
+		rows      []auditRow
+		anchor    *AuditLink
+		key       []byte
+		noKey     bool
+		valid     bool
+		brokenAt  int64
+		checked   int
+		unchained int
+	}{
+		{name: "intact", rows: rows, valid: true, checked: 5},
+		{name: "empty", rows: nil, valid: true},
+		{name: "foreign rows are not chained", rows: append([]auditRow{foreign}, rows...), valid: true, checked: 5, unchained: 1},
+		{name: "null payload", rows: append([]auditRow{{ID: 98, Payload: []byte("null")}}, rows...), valid: true, checked: 5, unchained: 1},
+		{name: "legacy links before keyed links", rows: chainRows(t, 4, 2), valid: true, checked: 4},
+		{name: "wrong key", rows: rows, key: []byte("another key of thirty-two bytes!"), brokenAt: 1},
+		{name: "unkeyed chain without a key", rows: chainRows(t, 3, 3), noKey: true, valid: true, checked: 3},
+		{name: "keyed rows without a key", rows: chainRows(t, 4, 2), noKey: true, brokenAt: 3, checked: 2},
+		{
+			name:     "edited payload",
+			rows:     []auditRow{rows[0], rewrite(t, rows[1], func(p map[string]any) { p["run_id"] = "run-2" }), rows[2]},
+			brokenAt: 2, checked: 1,
+		},
+		{
+			name:     "edited column",
+			rows:     []auditRow{rows[0], func() auditRow { r := rows[1]; r.Action = "SKIP"; return r }(), rows[2]},
+			brokenAt: 2, checked: 1,
+		},
+		{name: "deleted middle row", rows: []auditRow{rows[0], rows[2], rows[3]}, brokenAt: 3, checked: 1},
+		{name: "reordered rows", rows: []auditRow{rows[0], rows[2], rows[1]}, brokenAt: 3, checked: 1},
+		{
+			name:     "downgraded to unkeyed",
+			rows:     []auditRow{rows[0], rewrite(t, rows[1], func(p map[string]any) { delete(p, auditHashAlgKey) })},
+			brokenAt: 2, checked: 1,
+		},
+		{
+			name:     "unknown hash_alg",
+			rows:     []auditRow{rewrite(t, rows[0], func(p map[string]any) { p[auditHashAlgKey] = "md5" })},
+			brokenAt: 1,
+		},
+		{
+			name: "legacy row forged after keyed rows",
+			rows: append(rows[:2:2], func() auditRow {
+				prev := linkOf(t, rows[1]).Hash
+				payload := map[string]any{auditPrevHashKey: prev}
+				r := auditRow{ID: 3, Entity: "schema_migration", Action: "APPLY"}
+				payload[auditHashKey] = auditHash(nil, prev, r.CreatedAt, r.Entity, r.EntityID, r.Action, payload)
+				r.Payload = mustJSON(t, payload)
+				return r
+			}()),
+			brokenAt: 3, checked: 2,
+		},
+		{name: "anchored at head", rows: rows, anchor: linkOf(t, rows[4]), valid: true, checked: 5},
+		{name: "anchor behind head", rows: rows, anchor: linkOf(t, rows[2]), valid: true, checked: 5},
+		{name: "truncated after anchor", rows: rows[:3], anchor: linkOf(t, rows[4]), brokenAt: 5, checked: 3},
+		{name: "truncated to empty", rows: nil, anchor: linkOf(t, rows[4]), brokenAt: 5},
+		{name: "chain rewritten unkeyed", rows: chainRows(t, 5, 5), anchor: linkOf(t, rows[2]), brokenAt: 3, checked: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := tt.key
+			if key == nil && !tt.noKey {
+				key = testAuditKey
+			}
+			v := newAuditVerifier(key, tt.anchor)
+			for _, row := range tt.rows {
+				v.add(row)
+			}
+			report := v.finish()
+			if report.Valid != tt.valid || report.BrokenAt != tt.brokenAt {
+				t.Fatalf("Valid=%v BrokenAt=%d (%s), want Valid=%v BrokenAt=%d", report.Valid, report.BrokenAt, report.Reason, tt.valid, tt.brokenAt)
+			}
+			if report.Checked != tt.checked || report.Unchained != tt.unchained {
+				t.Errorf("Checked=%d Unchained=%d, want %d, %d", report.Checked, report.Unchained, tt.checked, tt.unchained)
+			}
+			if report.Anchored != (tt.anchor != nil) {
+				t.Errorf("Anchored=%v, want %v", report.Anchored, tt.anchor != nil)
+			}
+		})
+	}
+}
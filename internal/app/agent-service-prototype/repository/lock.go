@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// LockSession is a session from pg_stat_activity holding an advisory lock.
+type LockSession struct {
+	PID             int
+	ApplicationName string
+	ClientAddr      string
+	BackendStart    time.Time
+	Now             time.Time
+}
+
+// TryAdvisoryLock attempts pg_try_advisory_lock(key) on conn without blocking.
+func (r *Repository) TryAdvisoryLock(ctx context.Context, conn *sql.Conn, key int64) (bool, error) {
+	var locked bool
+	err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&locked)
+	return locked, err
+}
+
+// AdvisoryUnlock releases pg_advisory_lock(key) held by conn.
+func (r *Repository) AdvisoryUnlock(ctx context.Context, conn *sql.Conn, key int64) error {
+	_, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, key)
+	return err
+}
+
+// SetApplicationName sets application_name for the session of conn.
+// An empty name resets it to the connection default.
+func (r *Repository) SetApplicationName(ctx context.Context, conn *sql.Conn, name string) error {
+	if name == "" {
+		_, err := conn.ExecContext(ctx, `RESET application_name`)
+		return err
+	}
+	_, err := conn.ExecContext(ctx, `SELECT set_config('application_name', $1, false)`, name)
+	return err
+}
+
+// AdvisoryLockHolder returns the session holding the bigint advisory lock key,
+// or nil if it is not held. A bigint key is stored in pg_locks as classid (high
+// 32 bits) and objid (low 32 bits) with objsubid 1.
+func (r *Repository) AdvisoryLockHolder(ctx context.Context, conn *sql.Conn, key int64) (*LockSession, error) {
+	var s LockSession
+	var app, addr sql.NullString
+	err := conn.QueryRowContext(ctx, `
+		SELECT a.pid, a.application_name, host(a.client_addr), a.backend_start, now()
+		FROM pg_locks l
+		JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.locktype = 'advisory'
+		  AND l.granted
+		  AND l.classid = (($1::bigint >> 32) & 4294967295)::oid
+		  AND l.objid = ($1::bigint & 4294967295)::oid
+		  AND l.objsubid = 1
+		LIMIT 1
+	`, key).Scan(&s.PID, &app, &addr, &s.BackendStart, &s.Now)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.ApplicationName = app.String
+	s.ClientAddr = addr.String
+	return &s, nil
+}
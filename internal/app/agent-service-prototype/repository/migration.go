@@ -5,62 +5,173 @@ import (
 	"database/sql"
 	"fmt"
 	"time"
+
+	"github.com/lib/pq"
 )
 
-// MigrationRecord represents one row in hris_meta.schema_migrations.
+// MigrationRecord represents one row in the migration history table
+// (META_SCHEMA.META_TABLE, hris_meta.schema_migrations by default).
 type MigrationRecord struct {
-	Version    string
-	Name       string
-	Checksum   string
-	AppliedAt  time.Time
-	ExecTimeMs int64
-	Success    bool
-	ErrorMsg   string
+	App               string // manifest app; empty on rows recorded before apps were tracked
+	Version           string
+	Name              string
+	Checksum          string
+	CanonicalChecksum string // setup.CanonicalSHA256Hex of the file; empty on rows recorded before it existed
+	AppliedAt         time.Time
+	ExecTimeMs        int64
+	Success           bool
+	ErrorMsg          string
 }
 
-// IsFreshDB reports whether hris_meta.schema_migrations does not exist.
-// conn must be the same connection that holds the advisory lock.
-func (r *Repository) IsFreshDB(ctx context.Context, conn *sql.Conn) (bool, error) {
+// migrationsTableExists reports whether the migration history table exists.
+func (r *Repository) migrationsTableExists(ctx context.Context, conn *sql.Conn) (bool, error) {
 	var exists bool
 	err := conn.QueryRowContext(ctx, `
 		SELECT EXISTS (
 			SELECT FROM information_schema.tables
-			WHERE table_schema = 'hris_meta' AND table_name = 'schema_migrations'
+			WHERE table_schema = $1 AND table_name = $2
+		)
+	`, r.meta.Schema, r.meta.MigrationsTable).Scan(&exists)
+	return exists, err
+}
+
+// hasAppColumn reports whether the migration history table has the app column. Tables
+// created before apps were tracked lack it until EnsureMigrationsTable runs.
+func (r *Repository) hasAppColumn(ctx context.Context, conn *sql.Conn) (bool, error) {
+	var exists bool
+	err := conn.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT FROM information_schema.columns
+			WHERE table_schema = $1 AND table_name = $2 AND column_name = 'app'
 		)
-	`).Scan(&exists)
+	`, r.meta.Schema, r.meta.MigrationsTable).Scan(&exists)
+	return exists, err
+}
+
+// IsFreshDB reports whether app has not been installed yet: the migration history table does
+// not exist, or it holds rows of other apps only. An empty table, one without the app column,
+// or one with rows recorded before apps were tracked (empty app) belongs to a single-app
+// installation whose baseline has already been applied.
+// conn must be the same connection that holds the advisory lock.
+func (r *Repository) IsFreshDB(ctx context.Context, conn *sql.Conn, app string) (bool, error) {
+	exists, err := r.migrationsTableExists(ctx, conn)
+	if err != nil || !exists {
+		return !exists, err
+	}
+	hasApp, err := r.hasAppColumn(ctx, conn)
+	if err != nil || !hasApp {
+		return false, err
+	}
+
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf(`SELECT DISTINCT app FROM %s`, r.migrationsTable()))
 	if err != nil {
 		return false, err
 	}
-	return !exists, nil
+	defer rows.Close()
+	var apps []string
+	for rows.Next() {
+		var a string
+		if err := rows.Scan(&a); err != nil {
+			return false, err
+		}
+		apps = append(apps, a)
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	return freshForApp(apps, app), nil
+}
+
+// freshForApp decides IsFreshDB from the distinct app values of the history table: app is
+// fresh only when every row belongs to another, named app.
+func freshForApp(apps []string, app string) bool {
+	if len(apps) == 0 {
+		return false
+	}
+	for _, a := range apps {
+		if a == app || a == "" {
+			return false
+		}
+	}
+	return true
 }
 
-// EnsureMigrationsTable creates hris_meta and hris_meta.schema_migrations if not present.
+// EnsureMigrationsTable creates the metadata schema and migration history table if not
+// present, and upgrades tables created earlier (e.g. by a baseline): missing columns are
+// added and the primary key becomes (app, version).
 func (r *Repository) EnsureMigrationsTable(ctx context.Context, conn *sql.Conn) error {
-	_, err := conn.ExecContext(ctx, `
-		CREATE SCHEMA IF NOT EXISTS hris_meta;
-		CREATE TABLE IF NOT EXISTS hris_meta.schema_migrations (
-			version           TEXT PRIMARY KEY,
-			name              TEXT NOT NULL,
-			checksum          TEXT NOT NULL,
-			applied_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-			execution_time_ms BIGINT NOT NULL DEFAULT 0,
-			success           BOOLEAN NOT NULL DEFAULT FALSE,
-			error             TEXT
+	table := r.migrationsTable()
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		CREATE SCHEMA IF NOT EXISTS %[1]s;
+		CREATE TABLE IF NOT EXISTS %[2]s (
+			app                TEXT NOT NULL DEFAULT '',
+			version            TEXT NOT NULL,
+			name               TEXT NOT NULL,
+			checksum           TEXT NOT NULL,
+			canonical_checksum TEXT,
+			applied_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			execution_time_ms  BIGINT NOT NULL DEFAULT 0,
+			success            BOOLEAN NOT NULL DEFAULT FALSE,
+			error              TEXT,
+			PRIMARY KEY (app, version)
 		);
-	`)
+		ALTER TABLE %[2]s ADD COLUMN IF NOT EXISTS canonical_checksum TEXT;
+		ALTER TABLE %[2]s ADD COLUMN IF NOT EXISTS app TEXT NOT NULL DEFAULT '';
+		DO $$
+		DECLARE
+			pk name;
+		BEGIN
+			IF NOT EXISTS (
+				SELECT FROM pg_constraint
+				WHERE conrelid = %[3]s::regclass AND contype = 'p' AND array_length(conkey, 1) = 2
+			) THEN
+				SELECT conname INTO pk FROM pg_constraint WHERE conrelid = %[3]s::regclass AND contype = 'p';
+				IF pk IS NOT NULL THEN
+					EXECUTE format('ALTER TABLE %%s DROP CONSTRAINT %%I', %[3]s, pk);
+				END IF;
+				EXECUTE format('ALTER TABLE %%s ADD PRIMARY KEY (app, version)', %[3]s);
+			END IF;
+		END $$;
+	`, pq.QuoteIdentifier(r.meta.Schema), table, pq.QuoteLiteral(table)))
 	return err
 }
 
-// GetMigrationRecord returns the migration row for version, or nil if not found.
-func (r *Repository) GetMigrationRecord(ctx context.Context, conn *sql.Conn, version string) (*MigrationRecord, error) {
+// ClaimLegacyMigrations assigns rows recorded before apps were tracked (empty app) to app
+// when their version is one of versions and app has no row for it yet. It is a no-op when
+// the migration history table does not exist; otherwise the table is upgraded first.
+func (r *Repository) ClaimLegacyMigrations(ctx context.Context, conn *sql.Conn, app string, versions []string) (int64, error) {
+	if app == "" || len(versions) == 0 {
+		return 0, nil
+	}
+	exists, err := r.migrationsTableExists(ctx, conn)
+	if err != nil || !exists {
+		return 0, err
+	}
+	if err := r.EnsureMigrationsTable(ctx, conn); err != nil {
+		return 0, err
+	}
+	res, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %[1]s AS m SET app = $1
+		WHERE m.app = '' AND m.version = ANY($2)
+		  AND NOT EXISTS (SELECT FROM %[1]s o WHERE o.app = $1 AND o.version = m.version)
+	`, r.migrationsTable()), app, pq.Array(versions))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetMigrationRecord returns app's migration row for version, or nil if not found.
+// The table must have been brought up to date with EnsureMigrationsTable.
+func (r *Repository) GetMigrationRecord(ctx context.Context, conn *sql.Conn, app, version string) (*MigrationRecord, error) {
 	var rec MigrationRecord
-	var errMsg sql.NullString
+	var errMsg, canonical sql.NullString
 	var appliedAt time.Time
-	err := conn.QueryRowContext(ctx, `
-		SELECT version, name, checksum, applied_at, execution_time_ms, success, error
-		FROM hris_meta.schema_migrations
-		WHERE version = $1
-	`, version).Scan(&rec.Version, &rec.Name, &rec.Checksum, &appliedAt, &rec.ExecTimeMs, &rec.Success, &errMsg)
+	err := conn.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT app, version, name, checksum, canonical_checksum, applied_at, execution_time_ms, success, error
+		FROM %s
+		WHERE app = $1 AND version = $2
+	`, r.migrationsTable()), app, version).Scan(&rec.App, &rec.Version, &rec.Name, &rec.Checksum, &canonical, &appliedAt, &rec.ExecTimeMs, &rec.Success, &errMsg)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -68,26 +179,124 @@ func (r *Repository) GetMigrationRecord(ctx context.Context, conn *sql.Conn, ver
 		return nil, err
 	}
 	rec.AppliedAt = appliedAt
+	rec.CanonicalChecksum = canonical.String
 	if errMsg.Valid {
 		rec.ErrorMsg = errMsg.String
 	}
 	return &rec, nil
 }
 
-// RecordMigration inserts or updates a row in hris_meta.schema_migrations.
+// ListMigrationRecords returns app's rows in the migration history table ordered by version,
+// including rows recorded before apps were tracked. It returns an empty slice when the
+// table does not exist yet, and every row when the table has no app column.
+func (r *Repository) ListMigrationRecords(ctx context.Context, conn *sql.Conn, app string) ([]MigrationRecord, error) {
+	exists, err := r.migrationsTableExists(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return []MigrationRecord{}, nil
+	}
+	hasApp, err := r.hasAppColumn(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows *sql.Rows
+	if hasApp {
+		rows, err = conn.QueryContext(ctx, fmt.Sprintf(`
+			SELECT app, version, name, checksum, applied_at, execution_time_ms, success, error
+			FROM %s
+			WHERE app = $1 OR app = ''
+			ORDER BY version, app DESC
+		`, r.migrationsTable()), app)
+	} else {
+		rows, err = conn.QueryContext(ctx, fmt.Sprintf(`
+			SELECT '', version, name, checksum, applied_at, execution_time_ms, success, error
+			FROM %s
+			ORDER BY version
+		`, r.migrationsTable()))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []MigrationRecord{}
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var rec MigrationRecord
+		var execMs sql.NullInt64
+		var errMsg sql.NullString
+		if err := rows.Scan(&rec.App, &rec.Version, &rec.Name, &rec.Checksum, &rec.AppliedAt, &execMs, &rec.Success, &errMsg); err != nil {
+			return nil, err
+		}
+		// app's own row sorts before an unclaimed legacy row of the same version.
+		if seen[rec.Version] {
+			continue
+		}
+		seen[rec.Version] = true
+		rec.ExecTimeMs = execMs.Int64
+		rec.ErrorMsg = errMsg.String
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// ListMigrationApps returns the apps with rows in the migration history table, ordered by name.
+// Rows recorded before apps were tracked are not attributed to any app.
+func (r *Repository) ListMigrationApps(ctx context.Context, conn *sql.Conn) ([]string, error) {
+	exists, err := r.migrationsTableExists(ctx, conn)
+	if err != nil || !exists {
+		return []string{}, err
+	}
+	hasApp, err := r.hasAppColumn(ctx, conn)
+	if err != nil || !hasApp {
+		return []string{}, err
+	}
+
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf(`SELECT DISTINCT app FROM %s WHERE app <> '' ORDER BY app`, r.migrationsTable()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	apps := []string{}
+	for rows.Next() {
+		var app string
+		if err := rows.Scan(&app); err != nil {
+			return nil, err
+		}
+		apps = append(apps, app)
+	}
+	return apps, rows.Err()
+}
+
+// RecordMigration inserts or updates rec in the migration history table.
 func (r *Repository) RecordMigration(ctx context.Context, conn *sql.Conn, rec MigrationRecord) error {
-	_, err := conn.ExecContext(ctx, `
-		INSERT INTO hris_meta.schema_migrations
-			(version, name, checksum, applied_at, execution_time_ms, success, error)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		ON CONFLICT (version) DO UPDATE SET
-			name              = EXCLUDED.name,
-			checksum          = EXCLUDED.checksum,
-			applied_at        = EXCLUDED.applied_at,
-			execution_time_ms = EXCLUDED.execution_time_ms,
-			success           = EXCLUDED.success,
-			error             = EXCLUDED.error
-	`, rec.Version, rec.Name, rec.Checksum, rec.AppliedAt, rec.ExecTimeMs, rec.Success, rec.ErrorMsg)
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO %s
+			(app, version, name, checksum, canonical_checksum, applied_at, execution_time_ms, success, error)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9)
+		ON CONFLICT (app, version) DO UPDATE SET
+			name               = EXCLUDED.name,
+			checksum           = EXCLUDED.checksum,
+			canonical_checksum = EXCLUDED.canonical_checksum,
+			applied_at         = EXCLUDED.applied_at,
+			execution_time_ms  = EXCLUDED.execution_time_ms,
+			success            = EXCLUDED.success,
+			error              = EXCLUDED.error
+	`, r.migrationsTable()), rec.App, rec.Version, rec.Name, rec.Checksum, rec.CanonicalChecksum, rec.AppliedAt, rec.ExecTimeMs, rec.Success, rec.ErrorMsg)
+	return err
+}
+
+// UpdateMigrationChecksum re-records the checksums of one of app's applied migrations.
+func (r *Repository) UpdateMigrationChecksum(ctx context.Context, conn *sql.Conn, app, version, checksum, canonical string) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %s
+		SET checksum = $3, canonical_checksum = $4
+		WHERE app = $1 AND version = $2
+	`, r.migrationsTable()), app, version, checksum, canonical)
 	return err
 }
 
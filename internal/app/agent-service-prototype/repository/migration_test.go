@@ -0,0 +1,28 @@
+package repository
+
+import "testing"
+
+func TestFreshForApp(t *testing.T) {
+	tests := []struct {
+		name string
+		apps []string
+		app  string
+		want bool
+	}{
+		{"empty table", nil, "hris", false},
+		{"own rows", []string{"hris"}, "hris", false},
+		{"legacy rows only", []string{""}, "hris", false},
+		{"legacy rows, unnamed app", []string{""}, "", false},
+		{"legacy and other app rows", []string{"", "payroll"}, "hris", false},
+		{"own and other app rows", []string{"hris", "payroll"}, "hris", false},
+		{"other app rows only", []string{"payroll"}, "hris", true},
+		{"several other apps", []string{"crm", "payroll"}, "hris", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := freshForApp(tt.apps, tt.app); got != tt.want {
+				t.Errorf("freshForApp(%q, %q) = %v, want %v", tt.apps, tt.app, got, tt.want)
+			}
+		})
+	}
+}
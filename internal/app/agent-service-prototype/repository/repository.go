@@ -2,17 +2,52 @@ package repository
 
 import (
 	"database/sql"
+
+	"github.com/lib/pq"
 )
 
 // Repository provides data access (raw DB and, when available, Ent).
 // Migration-related methods are in migration.go.
 type Repository struct {
-	db *sql.DB
+	db   *sql.DB
+	meta MetaNamespace
+}
+
+// MetaNamespace locates the agent's own tables: the migration history table and the
+// schema holding it together with snapshots and the tenant registry.
+type MetaNamespace struct {
+	Schema          string
+	MigrationsTable string
+}
+
+// DefaultMetaNamespace is hris_meta.schema_migrations.
+var DefaultMetaNamespace = MetaNamespace{Schema: "hris_meta", MigrationsTable: "schema_migrations"}
+
+// NewRepository creates a repository with the given database connection and metadata
+// namespace. Empty fields of meta fall back to DefaultMetaNamespace.
+func NewRepository(db *sql.DB, meta MetaNamespace) *Repository {
+	if meta.Schema == "" {
+		meta.Schema = DefaultMetaNamespace.Schema
+	}
+	if meta.MigrationsTable == "" {
+		meta.MigrationsTable = DefaultMetaNamespace.MigrationsTable
+	}
+	return &Repository{db: db, meta: meta}
+}
+
+// Meta returns the metadata namespace the repository reads and writes.
+func (r *Repository) Meta() MetaNamespace {
+	return r.meta
+}
+
+// metaTable returns the quoted, schema-qualified name of table in the metadata schema.
+func (r *Repository) metaTable(table string) string {
+	return pq.QuoteIdentifier(r.meta.Schema) + "." + pq.QuoteIdentifier(table)
 }
 
-// NewRepository creates a repository with the given database connection.
-func NewRepository(db *sql.DB) *Repository {
-	return &Repository{db: db}
+// migrationsTable returns the quoted, schema-qualified migration history table.
+func (r *Repository) migrationsTable() string {
+	return r.metaTable(r.meta.MigrationsTable)
 }
 
 // DB returns the underlying *sql.DB for connection management (e.g. Conn).
@@ -0,0 +1,211 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// User is one admin account in META_SCHEMA.users.
+type User struct {
+	Username     string
+	PasswordHash string
+	Role         string
+	Disabled     bool
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// SessionRecord is one row of META_SCHEMA.sessions. Only the SHA-256 of the session ID is
+// stored, so a leaked table does not leak usable cookies.
+type SessionRecord struct {
+	IDHash     string
+	Username   string
+	IssuedAt   time.Time
+	ExpiresAt  time.Time
+	LastSeenAt time.Time
+	ClientIP   string
+	UserAgent  string
+	// ReplacedBy is the ID hash of the session that replaced this one when it was rotated.
+	ReplacedBy string
+	// UserRole and UserDisabled are read from the users table.
+	UserRole     string
+	UserDisabled bool
+}
+
+// EnsureAuthTables creates the admin users and sessions tables in the metadata schema if not present.
+func (r *Repository) EnsureAuthTables(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		CREATE SCHEMA IF NOT EXISTS %[1]s;
+		CREATE TABLE IF NOT EXISTS %[2]s (
+			username      TEXT PRIMARY KEY,
+			password_hash TEXT NOT NULL,
+			role          TEXT NOT NULL DEFAULT 'viewer',
+			disabled      BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		ALTER TABLE %[2]s ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'viewer';
+		CREATE TABLE IF NOT EXISTS %[3]s (
+			id_hash      TEXT PRIMARY KEY,
+			username     TEXT NOT NULL REFERENCES %[2]s (username) ON DELETE CASCADE,
+			issued_at    TIMESTAMPTZ NOT NULL,
+			expires_at   TIMESTAMPTZ NOT NULL,
+			last_seen_at TIMESTAMPTZ NOT NULL,
+			client_ip    TEXT NOT NULL DEFAULT '',
+			user_agent   TEXT NOT NULL DEFAULT '',
+			replaced_by  TEXT
+		);
+		ALTER TABLE %[3]s ADD COLUMN IF NOT EXISTS replaced_by TEXT;
+		CREATE INDEX IF NOT EXISTS sessions_username_idx ON %[3]s (username);
+		CREATE INDEX IF NOT EXISTS sessions_expires_at_idx ON %[3]s (expires_at);
+	`, pq.QuoteIdentifier(r.meta.Schema), r.metaTable("users"), r.metaTable("sessions")))
+	return err
+}
+
+// GetUser returns the user, or nil when it does not exist.
+func (r *Repository) GetUser(ctx context.Context, conn *sql.Conn, username string) (*User, error) {
+	var u User
+	err := conn.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT username, password_hash, role, disabled, created_at, updated_at
+		FROM %s WHERE username = $1
+	`, r.metaTable("users")), username).Scan(&u.Username, &u.PasswordHash, &u.Role, &u.Disabled, &u.CreatedAt, &u.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// ListUsers returns all users ordered by username.
+func (r *Repository) ListUsers(ctx context.Context, conn *sql.Conn) ([]User, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf(`
+		SELECT username, password_hash, role, disabled, created_at, updated_at
+		FROM %s ORDER BY username
+	`, r.metaTable("users")))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.Username, &u.PasswordHash, &u.Role, &u.Disabled, &u.CreatedAt, &u.UpdatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// UpsertUser creates the user or replaces its password hash, and (re-)enables it. An empty
+// role keeps the role of an existing user; new users then get the viewer default.
+func (r *Repository) UpsertUser(ctx context.Context, conn *sql.Conn, username, passwordHash, role string) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO %s AS u (username, password_hash, role)
+		VALUES ($1, $2, COALESCE(NULLIF($3, ''), 'viewer'))
+		ON CONFLICT (username) DO UPDATE
+		SET password_hash = EXCLUDED.password_hash,
+		    role = COALESCE(NULLIF($3, ''), u.role),
+		    disabled = FALSE,
+		    updated_at = NOW()
+	`, r.metaTable("users")), username, passwordHash, role)
+	return err
+}
+
+// SetUserRole changes the role of a user. It reports whether the user exists.
+func (r *Repository) SetUserRole(ctx context.Context, conn *sql.Conn, username, role string) (bool, error) {
+	res, err := conn.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET role = $2, updated_at = NOW() WHERE username = $1`, r.metaTable("users")), username, role)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteUser removes the user and, by cascade, its sessions. It reports whether the user existed.
+func (r *Repository) DeleteUser(ctx context.Context, conn *sql.Conn, username string) (bool, error) {
+	res, err := conn.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE username = $1`, r.metaTable("users")), username)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// CreateSession inserts a session.
+func (r *Repository) CreateSession(ctx context.Context, conn *sql.Conn, s SessionRecord) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO %s (id_hash, username, issued_at, expires_at, last_seen_at, client_ip, user_agent)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, r.metaTable("sessions")), s.IDHash, s.Username, s.IssuedAt, s.ExpiresAt, s.LastSeenAt, s.ClientIP, s.UserAgent)
+	return err
+}
+
+// GetSession returns the session with the given ID hash, or nil.
+func (r *Repository) GetSession(ctx context.Context, conn *sql.Conn, idHash string) (*SessionRecord, error) {
+	var s SessionRecord
+	err := conn.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT s.id_hash, s.username, s.issued_at, s.expires_at, s.last_seen_at, s.client_ip, s.user_agent,
+		       COALESCE(s.replaced_by, ''), u.role, u.disabled
+		FROM %s s JOIN %s u ON u.username = s.username
+		WHERE s.id_hash = $1
+	`, r.metaTable("sessions"), r.metaTable("users")), idHash).Scan(
+		&s.IDHash, &s.Username, &s.IssuedAt, &s.ExpiresAt, &s.LastSeenAt, &s.ClientIP, &s.UserAgent, &s.ReplacedBy, &s.UserRole, &s.UserDisabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// TouchSession records activity on a session.
+func (r *Repository) TouchSession(ctx context.Context, conn *sql.Conn, idHash string, at time.Time) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET last_seen_at = $2 WHERE id_hash = $1`, r.metaTable("sessions")), idHash, at)
+	return err
+}
+
+// RotateSession marks a session as replaced by successorHash and shortens it to end at t (if
+// it would end later). It reports false when the session was already replaced, so of several
+// requests rotating the same session at once only one issues a successor.
+func (r *Repository) RotateSession(ctx context.Context, conn *sql.Conn, idHash, successorHash string, t time.Time) (bool, error) {
+	res, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %s SET replaced_by = $2, expires_at = LEAST(expires_at, $3)
+		WHERE id_hash = $1 AND replaced_by IS NULL
+	`, r.metaTable("sessions")), idHash, successorHash, t)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteSession removes a session.
+func (r *Repository) DeleteSession(ctx context.Context, conn *sql.Conn, idHash string) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id_hash = $1`, r.metaTable("sessions")), idHash)
+	return err
+}
+
+// DeleteUserSessions removes every session of username.
+func (r *Repository) DeleteUserSessions(ctx context.Context, conn *sql.Conn, username string) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE username = $1`, r.metaTable("sessions")), username)
+	return err
+}
+
+// DeleteExpiredSessions removes sessions that ended before t.
+func (r *Repository) DeleteExpiredSessions(ctx context.Context, conn *sql.Conn, t time.Time) (int64, error) {
+	res, err := conn.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE expires_at < $1`, r.metaTable("sessions")), t)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
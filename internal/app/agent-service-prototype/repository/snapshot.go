@@ -0,0 +1,236 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"agent-service-prototype/pkg/setup"
+
+	"github.com/lib/pq"
+)
+
+// SnapshotRecord represents one row in the snapshot table (META_SCHEMA.schema_snapshots).
+type SnapshotRecord struct {
+	ID            int64
+	App           string
+	BundleID      string
+	SchemaVersion string
+	CreatedAt     time.Time
+	Snapshot      *setup.SchemaSnapshot
+}
+
+// userSchemaFilter restricts catalog queries on alias n (pg_namespace) to user schemas.
+// The metadata schema (metaSchemaPlaceholder, filled in at capture time) is excluded
+// because it changes with every installation.
+const userSchemaFilter = `n.nspname NOT IN ('information_schema', ` + metaSchemaPlaceholder + `) AND n.nspname NOT LIKE 'pg\_%'`
+
+const metaSchemaPlaceholder = "{{meta_schema}}"
+
+// snapshotQueries return (name, definition) rows for each object kind.
+var snapshotQueries = []struct {
+	kind  string
+	query string
+}{
+	{setup.ObjectSchema, `
+		SELECT n.nspname, 'owner ' || pg_get_userbyid(n.nspowner)
+		FROM pg_namespace n
+		WHERE ` + userSchemaFilter},
+	{setup.ObjectTable, `
+		SELECT n.nspname || '.' || c.relname,
+		       CASE c.relkind WHEN 'p' THEN 'partitioned table' ELSE 'table' END ||
+		       CASE c.relpersistence WHEN 'u' THEN ' unlogged' ELSE '' END
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p') AND ` + userSchemaFilter},
+	{setup.ObjectView, `
+		SELECT n.nspname || '.' || c.relname,
+		       CASE c.relkind WHEN 'm' THEN 'materialized ' ELSE '' END || pg_get_viewdef(c.oid, true)
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('v', 'm') AND ` + userSchemaFilter},
+	{setup.ObjectColumn, `
+		SELECT n.nspname || '.' || c.relname || '.' || a.attname,
+		       format_type(a.atttypid, a.atttypmod) ||
+		       CASE WHEN a.attnotnull THEN ' NOT NULL' ELSE '' END ||
+		       COALESCE(' DEFAULT ' || pg_get_expr(d.adbin, d.adrelid), '')
+		FROM pg_attribute a
+		JOIN pg_class c ON c.oid = a.attrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE c.relkind IN ('r', 'p', 'v', 'm') AND a.attnum > 0 AND NOT a.attisdropped AND ` + userSchemaFilter},
+	{setup.ObjectIndex, `
+		SELECT n.nspname || '.' || ic.relname,
+		       pg_get_indexdef(i.indexrelid) || CASE WHEN i.indisvalid THEN '' ELSE ' INVALID' END
+		FROM pg_index i
+		JOIN pg_class ic ON ic.oid = i.indexrelid
+		JOIN pg_namespace n ON n.oid = ic.relnamespace
+		WHERE ` + userSchemaFilter},
+	{setup.ObjectConstraint, `
+		SELECT n.nspname || '.' || c.relname || '.' || con.conname, pg_get_constraintdef(con.oid)
+		FROM pg_constraint con
+		JOIN pg_class c ON c.oid = con.conrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE ` + userSchemaFilter},
+	{setup.ObjectFunction, `
+		SELECT n.nspname || '.' || p.proname || '(' || pg_get_function_identity_arguments(p.oid) || ')',
+		       pg_get_functiondef(p.oid)
+		FROM pg_proc p
+		JOIN pg_namespace n ON n.oid = p.pronamespace
+		WHERE p.prokind IN ('f', 'p') AND ` + userSchemaFilter},
+	{setup.ObjectGrant, `
+		SELECT 'schema ' || n.nspname || ' to ' || COALESCE(r.rolname, 'PUBLIC') || ' ' || a.privilege_type,
+		       CASE WHEN a.is_grantable THEN 'WITH GRANT OPTION' ELSE 'GRANT' END
+		FROM pg_namespace n
+		CROSS JOIN LATERAL aclexplode(n.nspacl) a
+		LEFT JOIN pg_roles r ON r.oid = a.grantee
+		WHERE n.nspacl IS NOT NULL AND ` + userSchemaFilter + `
+		UNION ALL
+		SELECT 'relation ' || n.nspname || '.' || c.relname || ' to ' || COALESCE(r.rolname, 'PUBLIC') || ' ' || a.privilege_type,
+		       CASE WHEN a.is_grantable THEN 'WITH GRANT OPTION' ELSE 'GRANT' END
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		CROSS JOIN LATERAL aclexplode(c.relacl) a
+		LEFT JOIN pg_roles r ON r.oid = a.grantee
+		WHERE c.relacl IS NOT NULL AND c.relkind IN ('r', 'p', 'v', 'm', 'S') AND ` + userSchemaFilter + `
+		UNION ALL
+		SELECT 'function ' || n.nspname || '.' || p.proname || '(' || pg_get_function_identity_arguments(p.oid) || ') to ' ||
+		       COALESCE(r.rolname, 'PUBLIC') || ' ' || a.privilege_type,
+		       CASE WHEN a.is_grantable THEN 'WITH GRANT OPTION' ELSE 'GRANT' END
+		FROM pg_proc p
+		JOIN pg_namespace n ON n.oid = p.pronamespace
+		CROSS JOIN LATERAL aclexplode(p.proacl) a
+		LEFT JOIN pg_roles r ON r.oid = a.grantee
+		WHERE p.proacl IS NOT NULL AND ` + userSchemaFilter},
+}
+
+// CaptureSchemaSnapshot builds a canonical snapshot of the user schemas from pg_catalog:
+// schemas, tables, views, columns, indexes, constraints, functions and grants.
+func (r *Repository) CaptureSchemaSnapshot(ctx context.Context, conn *sql.Conn) (*setup.SchemaSnapshot, error) {
+	snap := &setup.SchemaSnapshot{Objects: []setup.SchemaObject{}}
+	metaSchema := pq.QuoteLiteral(r.meta.Schema)
+	for _, q := range snapshotQueries {
+		rows, err := conn.QueryContext(ctx, strings.ReplaceAll(q.query, metaSchemaPlaceholder, metaSchema))
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %s: %w", q.kind, err)
+		}
+		for rows.Next() {
+			obj := setup.SchemaObject{Kind: q.kind}
+			if err := rows.Scan(&obj.Name, &obj.Definition); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("snapshot %s: %w", q.kind, err)
+			}
+			snap.Objects = append(snap.Objects, obj)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %s: %w", q.kind, err)
+		}
+	}
+	snap.Sort()
+	return snap, nil
+}
+
+// EnsureSnapshotsTable creates the snapshot table in the metadata schema if not present,
+// and adds the app column to a table created before snapshots were kept per app.
+func (r *Repository) EnsureSnapshotsTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		CREATE SCHEMA IF NOT EXISTS %[1]s;
+		CREATE TABLE IF NOT EXISTS %[2]s (
+			id             BIGSERIAL PRIMARY KEY,
+			app            TEXT NOT NULL DEFAULT '',
+			bundle_id      TEXT,
+			schema_version TEXT,
+			snapshot       JSONB NOT NULL,
+			created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		ALTER TABLE %[2]s ADD COLUMN IF NOT EXISTS app TEXT NOT NULL DEFAULT '';
+		CREATE INDEX IF NOT EXISTS schema_snapshots_app_id_idx ON %[2]s (app, id);
+	`, pq.QuoteIdentifier(r.meta.Schema), r.metaTable("schema_snapshots")))
+	return err
+}
+
+// SaveSchemaSnapshot inserts snap into the snapshot table for app.
+func (r *Repository) SaveSchemaSnapshot(ctx context.Context, conn *sql.Conn, app, bundleID, schemaVersion string, snap *setup.SchemaSnapshot) error {
+	if err := r.EnsureSnapshotsTable(ctx, conn); err != nil {
+		return err
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	_, err = conn.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO %s (app, bundle_id, schema_version, snapshot)
+		VALUES ($1, $2, $3, $4)
+	`, r.metaTable("schema_snapshots")), app, bundleID, schemaVersion, string(data))
+	return err
+}
+
+// LatestSchemaSnapshot returns the most recent snapshot saved for app, or nil if none exists.
+// Snapshots saved before they were kept per app (empty app) count for every app.
+func (r *Repository) LatestSchemaSnapshot(ctx context.Context, conn *sql.Conn, app string) (*SnapshotRecord, error) {
+	exists, hasApp, err := r.snapshotsTableState(ctx, conn)
+	if err != nil || !exists {
+		return nil, err
+	}
+	appColumn, appFilter, args := "''", "", []any{}
+	if hasApp {
+		appColumn, appFilter, args = "app", "WHERE app = $1 OR app = ''", []any{app}
+	}
+
+	var rec SnapshotRecord
+	var bundleID, schemaVersion sql.NullString
+	var data []byte
+	err = conn.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT id, %s, bundle_id, schema_version, created_at, snapshot
+		FROM %s
+		%s
+		ORDER BY id DESC
+		LIMIT 1
+	`, appColumn, r.metaTable("schema_snapshots"), appFilter), args...).Scan(&rec.ID, &rec.App, &bundleID, &schemaVersion, &rec.CreatedAt, &data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rec.BundleID = bundleID.String
+	rec.SchemaVersion = schemaVersion.String
+	rec.Snapshot = &setup.SchemaSnapshot{}
+	if err := json.Unmarshal(data, rec.Snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %d: %w", rec.ID, err)
+	}
+	rec.Snapshot.Sort()
+	return &rec, nil
+}
+
+// LatestSnapshotApp returns the app of the most recently saved snapshot, or "" if none exists.
+func (r *Repository) LatestSnapshotApp(ctx context.Context, conn *sql.Conn) (string, error) {
+	exists, hasApp, err := r.snapshotsTableState(ctx, conn)
+	if err != nil || !exists || !hasApp {
+		return "", err
+	}
+	var app string
+	err = conn.QueryRowContext(ctx, fmt.Sprintf(`SELECT app FROM %s ORDER BY id DESC LIMIT 1`, r.metaTable("schema_snapshots"))).Scan(&app)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return app, err
+}
+
+// snapshotsTableState reports whether the snapshot table exists and has the app column;
+// tables created before snapshots were kept per app lack it until EnsureSnapshotsTable runs.
+func (r *Repository) snapshotsTableState(ctx context.Context, conn *sql.Conn) (exists, hasApp bool, err error) {
+	err = conn.QueryRowContext(ctx, `
+		SELECT to_regclass($1) IS NOT NULL,
+		       EXISTS (
+		           SELECT FROM information_schema.columns
+		           WHERE table_schema = $2 AND table_name = 'schema_snapshots' AND column_name = 'app'
+		       )
+	`, r.metaTable("schema_snapshots"), r.meta.Schema).Scan(&exists, &hasApp)
+	return exists, hasApp, err
+}
@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Tenant represents one row in the tenant registry (META_SCHEMA.tenants).
+type Tenant struct {
+	ID            string
+	Schema        string
+	SchemaVersion string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// EnsureTenantTables creates the tenant registry (tenants) and the per-tenant migration
+// history (tenant_migrations, keyed by tenant, app and version) in the metadata schema if not
+// present, and upgrades a history table created before it was keyed by app.
+func (r *Repository) EnsureTenantTables(ctx context.Context, conn *sql.Conn) error {
+	migrations := r.metaTable("tenant_migrations")
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		CREATE SCHEMA IF NOT EXISTS %[1]s;
+		CREATE TABLE IF NOT EXISTS %[2]s (
+			id             TEXT PRIMARY KEY,
+			schema_name    TEXT NOT NULL UNIQUE,
+			schema_version TEXT NOT NULL DEFAULT '',
+			created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE TABLE IF NOT EXISTS %[3]s (
+			tenant_id          TEXT NOT NULL REFERENCES %[2]s (id) ON DELETE CASCADE,
+			app                TEXT NOT NULL DEFAULT '',
+			version            TEXT NOT NULL,
+			name               TEXT NOT NULL,
+			checksum           TEXT NOT NULL,
+			canonical_checksum TEXT,
+			applied_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			execution_time_ms  BIGINT NOT NULL DEFAULT 0,
+			success            BOOLEAN NOT NULL DEFAULT FALSE,
+			error              TEXT,
+			PRIMARY KEY (tenant_id, app, version)
+		);
+		ALTER TABLE %[3]s ADD COLUMN IF NOT EXISTS canonical_checksum TEXT;
+		ALTER TABLE %[3]s ADD COLUMN IF NOT EXISTS app TEXT NOT NULL DEFAULT '';
+		DO $$
+		DECLARE
+			pk name;
+		BEGIN
+			IF NOT EXISTS (
+				SELECT FROM pg_constraint
+				WHERE conrelid = %[4]s::regclass AND contype = 'p' AND array_length(conkey, 1) = 3
+			) THEN
+				SELECT conname INTO pk FROM pg_constraint WHERE conrelid = %[4]s::regclass AND contype = 'p';
+				IF pk IS NOT NULL THEN
+					EXECUTE format('ALTER TABLE %%s DROP CONSTRAINT %%I', %[4]s, pk);
+				END IF;
+				EXECUTE format('ALTER TABLE %%s ADD PRIMARY KEY (tenant_id, app, version)', %[4]s);
+			END IF;
+		END $$;
+	`, pq.QuoteIdentifier(r.meta.Schema), r.metaTable("tenants"), migrations, pq.QuoteLiteral(migrations)))
+	return err
+}
+
+// ListTenants returns the registered tenants ordered by ID.
+// It returns an empty slice when the registry does not exist yet.
+func (r *Repository) ListTenants(ctx context.Context, conn *sql.Conn) ([]Tenant, error) {
+	var exists bool
+	if err := conn.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, r.metaTable("tenants")).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return []Tenant{}, nil
+	}
+
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf(`
+		SELECT id, schema_name, schema_version, created_at, updated_at
+		FROM %s
+		ORDER BY id
+	`, r.metaTable("tenants")))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := []Tenant{}
+	for rows.Next() {
+		var t Tenant
+		if err := rows.Scan(&t.ID, &t.Schema, &t.SchemaVersion, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, rows.Err()
+}
+
+// GetTenant returns the tenant with id, or nil if not registered.
+func (r *Repository) GetTenant(ctx context.Context, conn *sql.Conn, id string) (*Tenant, error) {
+	var t Tenant
+	err := conn.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT id, schema_name, schema_version, created_at, updated_at
+		FROM %s
+		WHERE id = $1
+	`, r.metaTable("tenants")), id).Scan(&t.ID, &t.Schema, &t.SchemaVersion, &t.CreatedAt, &t.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// CreateTenant registers a tenant and its schema.
+func (r *Repository) CreateTenant(ctx context.Context, conn *sql.Conn, id, schema string) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO %s (id, schema_name) VALUES ($1, $2)
+	`, r.metaTable("tenants")), id, schema)
+	return err
+}
+
+// DeleteTenant removes a tenant and its migration history from the registry.
+func (r *Repository) DeleteTenant(ctx context.Context, conn *sql.Conn, id string) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = $1`, r.metaTable("tenants")), id)
+	return err
+}
+
+// SetTenantVersion records the schema version a tenant has been migrated to.
+func (r *Repository) SetTenantVersion(ctx context.Context, conn *sql.Conn, id, version string) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %s SET schema_version = $2, updated_at = NOW() WHERE id = $1
+	`, r.metaTable("tenants")), id, version)
+	return err
+}
+
+// SchemaExists reports whether a schema named name exists.
+func (r *Repository) SchemaExists(ctx context.Context, conn *sql.Conn, name string) (bool, error) {
+	var exists bool
+	err := conn.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT FROM pg_namespace WHERE nspname = $1)
+	`, name).Scan(&exists)
+	return exists, err
+}
+
+// GetTenantMigrationRecord returns the tenant's migration row of app for version, or nil if
+// not found. A row recorded before the history was keyed by app (empty app) is returned when
+// app has none.
+func (r *Repository) GetTenantMigrationRecord(ctx context.Context, conn *sql.Conn, tenantID, app, version string) (*MigrationRecord, error) {
+	var rec MigrationRecord
+	var errMsg, canonical sql.NullString
+	err := conn.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT app, version, name, checksum, canonical_checksum, applied_at, execution_time_ms, success, error
+		FROM %s
+		WHERE tenant_id = $1 AND (app = $2 OR app = '') AND version = $3
+		ORDER BY app DESC
+		LIMIT 1
+	`, r.metaTable("tenant_migrations")), tenantID, app, version).Scan(&rec.App, &rec.Version, &rec.Name, &rec.Checksum, &canonical, &rec.AppliedAt, &rec.ExecTimeMs, &rec.Success, &errMsg)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rec.CanonicalChecksum = canonical.String
+	rec.ErrorMsg = errMsg.String
+	return &rec, nil
+}
+
+// RecordTenantMigration inserts or updates a row in the tenant migration history, keyed by
+// rec.App.
+func (r *Repository) RecordTenantMigration(ctx context.Context, conn *sql.Conn, tenantID string, rec MigrationRecord) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO %s
+			(tenant_id, app, version, name, checksum, canonical_checksum, applied_at, execution_time_ms, success, error)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8, $9, $10)
+		ON CONFLICT (tenant_id, app, version) DO UPDATE SET
+			name               = EXCLUDED.name,
+			checksum           = EXCLUDED.checksum,
+			canonical_checksum = EXCLUDED.canonical_checksum,
+			applied_at         = EXCLUDED.applied_at,
+			execution_time_ms  = EXCLUDED.execution_time_ms,
+			success            = EXCLUDED.success,
+			error              = EXCLUDED.error
+	`, r.metaTable("tenant_migrations")), tenantID, rec.App, rec.Version, rec.Name, rec.Checksum, rec.CanonicalChecksum, rec.AppliedAt, rec.ExecTimeMs, rec.Success, rec.ErrorMsg)
+	return err
+}
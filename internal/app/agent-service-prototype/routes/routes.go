@@ -7,23 +7,58 @@ import (
 	"agent-service-prototype/internal/app/agent-service-prototype/repository"
 	"agent-service-prototype/internal/app/agent-service-prototype/service"
 	"agent-service-prototype/internal/config"
+	"agent-service-prototype/internal/middleware"
 	"agent-service-prototype/pkg/logger"
+	"agent-service-prototype/pkg/rbac"
 
 	"github.com/labstack/echo/v4"
 )
 
-// RegisterSetupRoutes registers POST /setup/installation and GET /setup/status
-// on the root Echo instance (not under /api/v1).
-func RegisterSetupRoutes(e *echo.Echo, cfg *config.Config, db *sql.DB) {
-	repo := repository.NewRepository(db)
+// RegisterSetupRoutes registers the /setup endpoints (installation, status,
+// bundle cache and inspection, drift, backups, tenants, adopt/repair, audit) and the
+// /livez and /readyz probes on the root Echo instance (not under /api/v1), and returns the
+// service behind them. The /setup endpoints require auth; the probes stay public. With
+// AUTH_SESSIONS the /auth login endpoints are registered and a session cookie also
+// authenticates /setup. Each /setup route checks the caller's role.
+func RegisterSetupRoutes(e *echo.Echo, cfg *config.Config, db *sql.DB, auth echo.MiddlewareFunc) *service.Service {
+	repo := repository.NewRepository(db, repository.MetaNamespace{Schema: cfg.DB.MetaSchema, MigrationsTable: cfg.DB.MetaTable})
 	svc := service.NewService(cfg, repo)
-	ctrl := controller.NewController(svc)
+	ctrl := controller.NewController(svc, cfg)
 
-	g := e.Group("/setup")
-	g.POST("/installation", ctrl.Installation)
-	g.GET("/status", ctrl.Status)
+	e.GET("/livez", ctrl.Livez)
+	e.GET("/readyz", ctrl.Readyz)
+
+	protected := []echo.MiddlewareFunc{auth}
+	if cfg.HTTP.AuthSessions {
+		session := middleware.SessionMiddleware(svc, cfg)
+		protected = []echo.MiddlewareFunc{session, auth}
+
+		a := e.Group("/auth")
+		a.POST("/login", ctrl.Login)
+		a.POST("/logout", ctrl.Logout)
+		a.GET("/me", ctrl.Me, protected...)
+	}
+
+	// Each route requires a permission of the caller's role (see pkg/rbac); "force": true on
+	// an installation additionally requires rbac.PermForce.
+	g := e.Group("/setup", protected...)
+	g.POST("/installation", ctrl.Installation, middleware.RequirePermission(rbac.PermInstall))
+	g.GET("/status", ctrl.Status, middleware.RequirePermission(rbac.PermStatus))
+	g.GET("/bundles", ctrl.ListBundles, middleware.RequirePermission(rbac.PermStatus))
+	g.POST("/bundles", ctrl.UploadBundle, middleware.RequirePermission(rbac.PermBundleUpload))
+	g.POST("/bundles/inspect", ctrl.InspectBundle, middleware.RequirePermission(rbac.PermPlan))
+	g.GET("/drift", ctrl.Drift, middleware.RequirePermission(rbac.PermStatus))
+	g.GET("/backups", ctrl.ListBackups, middleware.RequirePermission(rbac.PermStatus))
+	g.POST("/backups/:id/restore", ctrl.RestoreBackup, middleware.RequirePermission(rbac.PermRollback))
+	g.GET("/tenants", ctrl.ListTenants, middleware.RequirePermission(rbac.PermStatus))
+	g.POST("/tenants", ctrl.CreateTenant, middleware.RequirePermission(rbac.PermTenants))
+	g.DELETE("/tenants/:id", ctrl.DeleteTenant, middleware.RequirePermission(rbac.PermTenants))
+	g.POST("/adopt", ctrl.Adopt, middleware.RequirePermission(rbac.PermAdopt))
+	g.POST("/repair", ctrl.Repair, middleware.RequirePermission(rbac.PermAdopt))
+	g.GET("/audit/verify", ctrl.VerifyAudit, middleware.RequirePermission(rbac.PermStatus))
 
 	logger.Info().Msg("setup routes registered")
+	return svc
 }
 
 // RegisterRoutes registers all HTTP routes for the agent-service-prototype module
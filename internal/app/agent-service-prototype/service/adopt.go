@@ -0,0 +1,383 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"agent-service-prototype/internal/app/agent-service-prototype/repository"
+	"agent-service-prototype/pkg/logger"
+	"agent-service-prototype/pkg/setup"
+)
+
+var (
+	ErrSchemaMismatch   = errors.New("database schema does not match the bundle")
+	ErrUnknownVersion   = errors.New("version is not in the bundle")
+	ErrTenantScopedOnly = errors.New("operation is not supported for tenant-scoped bundles")
+	ErrOtherApp         = errors.New("bundle is for a different app")
+)
+
+// maxMismatchDetails caps the objects listed in an ErrSchemaMismatch message.
+const maxMismatchDetails = 20
+
+// AdoptResult is the outcome of Adopt.
+type AdoptResult struct {
+	BundleID       string
+	BundleVersion  string
+	Version        string
+	Adopted        []string
+	AlreadyApplied []string
+}
+
+// RepairItem is one migration considered by Repair.
+type RepairItem struct {
+	Version     string
+	Name        string
+	OldChecksum string
+	NewChecksum string
+	Reason      string
+}
+
+// RepairResult is the outcome of Repair.
+type RepairResult struct {
+	BundleID string
+	Repaired []RepairItem
+	Skipped  []RepairItem
+}
+
+// Adopt brings a database that was set up without the agent under its management: the
+// baseline and every migration up to version are recorded as applied without running them.
+// The live schema is checked first; every table, column, index and constraint created by
+// those scripts must exist, and when adopting the whole bundle the bundle's schema snapshot
+// (if any) must match apart from extra objects and grants.
+func (s *Service) Adopt(ctx context.Context, opts InstallOptions, version string) (*AdoptResult, error) {
+	b, err := s.resolveBundle(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	manifest := b.Manifest
+	if manifest.IsTenantScoped() {
+		return nil, ErrTenantScopedOnly
+	}
+
+	found := version != "" && version == manifest.Baseline.Version
+	var migrations []setup.Migration
+	for _, mig := range manifest.Migrations {
+		if mig.Version <= version {
+			migrations = append(migrations, mig)
+		}
+		if mig.Version == version {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownVersion, version)
+	}
+
+	conn, err := s.repo.DB().Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	unlock, err := s.lockDB(ctx, conn, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	actual, err := s.repo.CaptureSchemaSnapshot(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture schema snapshot: %w", err)
+	}
+	if err := checkAdoptable(b, migrations, actual, s.repo.Meta().Schema); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.repo.ClaimLegacyMigrations(ctx, conn, manifest.App, bundleVersions(manifest)); err != nil {
+		return nil, fmt.Errorf("failed to upgrade migrations table: %w", err)
+	}
+	if err := s.repo.EnsureMigrationsTable(ctx, conn); err != nil {
+		return nil, fmt.Errorf("failed to ensure migrations table: %w", err)
+	}
+	if baseline, err := s.repo.GetMigrationRecord(ctx, conn, manifest.App, baselineRecordVersion); err != nil {
+		return nil, fmt.Errorf("failed to check baseline: %w", err)
+	} else if baseline == nil {
+		if err := s.recordBaseline(ctx, conn, b); err != nil {
+			return nil, fmt.Errorf("failed to record baseline: %w", err)
+		}
+	}
+	result := &AdoptResult{BundleID: b.ID, BundleVersion: manifest.BundleVersion, Version: version, Adopted: []string{}, AlreadyApplied: []string{}}
+	for _, mig := range migrations {
+		applied, err := s.repo.GetMigrationRecord(ctx, conn, manifest.App, mig.Version)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check migration %s: %w", mig.Version, err)
+		}
+		if applied != nil && applied.Success {
+			result.AlreadyApplied = append(result.AlreadyApplied, mig.Version)
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(b.BaseDir, mig.File))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", mig.Version, err)
+		}
+		rec := repository.MigrationRecord{
+			App:               manifest.App,
+			Version:           mig.Version,
+			Name:              mig.Name,
+			Checksum:          setup.SHA256Hex(data),
+			CanonicalChecksum: setup.CanonicalSHA256Hex(data),
+			AppliedAt:         time.Now(),
+			Success:           true,
+		}
+		if err := s.repo.RecordMigration(ctx, conn, rec); err != nil {
+			return nil, fmt.Errorf("failed to record migration %s: %w", mig.Version, err)
+		}
+		result.Adopted = append(result.Adopted, mig.Version)
+	}
+
+	if err := s.repo.SaveSchemaSnapshot(ctx, conn, manifest.App, b.ID, version, actual); err != nil {
+		logger.Error().Ctx(ctx).Err(err).Msg("Failed to save schema snapshot")
+	}
+	sc := auditScope{Actor: opts.Actor, App: manifest.App, BundleID: b.ID, BundleVersion: manifest.BundleVersion}
+	s.audit(ctx, conn, sc, AuditEntityMigration, version, AuditActionAdopt, map[string]any{
+		"version":         version,
+		"adopted":         result.Adopted,
+		"already_applied": result.AlreadyApplied,
+	})
+	logger.Info().Ctx(ctx).Str("bundle_id", b.ID).Str("version", version).Int("adopted", len(result.Adopted)).Msg("Database adopted")
+	return result, nil
+}
+
+// checkAdoptable verifies that actual contains what the baseline and migrations create.
+// Objects in metaSchema are ignored.
+func checkAdoptable(b *preparedBundle, migrations []setup.Migration, actual *setup.SchemaSnapshot, metaSchema string) error {
+	schema := b.Manifest.DB.DefaultSchema
+	files := []string{b.Manifest.Baseline.File}
+	for _, mig := range migrations {
+		files = append(files, mig.File)
+	}
+
+	var expected []setup.SchemaObject
+	for _, f := range files {
+		data, err := os.ReadFile(filepath.Join(b.BaseDir, f))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", f, err)
+		}
+		for _, o := range setup.ExpectedObjects(setup.RenderSQL(string(data), schema), schema) {
+			// The metadata schema is managed by the agent and not part of schema snapshots.
+			if o.Name == metaSchema || strings.HasPrefix(o.Name, metaSchema+".") {
+				continue
+			}
+			expected = append(expected, o)
+		}
+	}
+
+	var problems []string
+	for _, o := range setup.MissingObjects(expected, actual) {
+		problems = append(problems, fmt.Sprintf("missing %s %s", o.Kind, o.Name))
+	}
+	if b.Manifest.Snapshot != "" && len(migrations) == len(b.Manifest.Migrations) {
+		snap, err := setup.LoadSnapshot(b.BaseDir, b.Manifest.Snapshot)
+		if err != nil {
+			return err
+		}
+		for _, item := range setup.DiffSnapshots(snap, actual) {
+			if item.Change == setup.DriftAdded || item.Kind == setup.ObjectGrant {
+				continue
+			}
+			problems = append(problems, fmt.Sprintf("%s %s %s", item.Change, item.Kind, item.Name))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+
+	total := len(problems)
+	if total > maxMismatchDetails {
+		problems = append(problems[:maxMismatchDetails], fmt.Sprintf("and %d more", total-maxMismatchDetails))
+	}
+	return fmt.Errorf("%w: %s", ErrSchemaMismatch, strings.Join(problems, "; "))
+}
+
+// Repair re-records the checksums of applied migrations whose files changed only in
+// whitespace since they were applied, so they no longer fail with "checksum mismatch".
+// A change is accepted when the recorded canonical checksum matches the new file, or when
+// the applied file (found by its recorded checksum in the bundle previousBundleID, or in any
+// cached bundle of the app when that is empty) has the same canonical form as the new one.
+// Rows whose content really changed, or whose applied file cannot be found, are skipped.
+// With versions, only those are considered.
+func (s *Service) Repair(ctx context.Context, opts InstallOptions, versions []string, previousBundleID string) (*RepairResult, error) {
+	b, err := s.resolveBundle(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if b.Manifest.IsTenantScoped() {
+		return nil, ErrTenantScopedOnly
+	}
+
+	known := make(map[string]bool, len(b.Manifest.Migrations))
+	for _, mig := range b.Manifest.Migrations {
+		known[mig.Version] = true
+	}
+	selected := make(map[string]bool, len(versions))
+	for _, v := range versions {
+		if !known[v] {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownVersion, v)
+		}
+		selected[v] = true
+	}
+
+	conn, err := s.repo.DB().Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	unlock, err := s.lockDB(ctx, conn, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if _, err := s.repo.ClaimLegacyMigrations(ctx, conn, b.Manifest.App, bundleVersions(b.Manifest)); err != nil {
+		return nil, fmt.Errorf("failed to upgrade migrations table: %w", err)
+	}
+	if err := s.repo.EnsureMigrationsTable(ctx, conn); err != nil {
+		return nil, fmt.Errorf("failed to ensure migrations table: %w", err)
+	}
+
+	var previous []*preparedBundle
+	if previousBundleID != "" {
+		pb, err := s.previousBundle(previousBundleID, b)
+		if err != nil {
+			return nil, err
+		}
+		previous = []*preparedBundle{pb}
+	}
+	previousLoaded := previousBundleID != ""
+
+	sc := auditScope{Actor: opts.Actor, App: b.Manifest.App, BundleID: b.ID, BundleVersion: b.Manifest.BundleVersion}
+	result := &RepairResult{BundleID: b.ID, Repaired: []RepairItem{}, Skipped: []RepairItem{}}
+	for _, mig := range b.Manifest.Migrations {
+		if len(selected) > 0 && !selected[mig.Version] {
+			continue
+		}
+		applied, err := s.repo.GetMigrationRecord(ctx, conn, b.Manifest.App, mig.Version)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check migration %s: %w", mig.Version, err)
+		}
+		data, err := os.ReadFile(filepath.Join(b.BaseDir, mig.File))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", mig.Version, err)
+		}
+		item := RepairItem{Version: mig.Version, Name: mig.Name, NewChecksum: setup.SHA256Hex(data)}
+		canonical := setup.CanonicalSHA256Hex(data)
+
+		switch {
+		case applied == nil:
+			if selected[mig.Version] {
+				item.Reason = "not applied"
+				result.Skipped = append(result.Skipped, item)
+			}
+			continue
+		case applied.Checksum == item.NewChecksum:
+			if selected[mig.Version] {
+				item.Reason = "checksum already matches"
+				result.Skipped = append(result.Skipped, item)
+			}
+			continue
+		}
+
+		item.OldChecksum = applied.Checksum
+		verifiedBy := "canonical_checksum"
+		if applied.CanonicalChecksum != canonical {
+			// Rows recorded before canonical checksums existed, or with an older canonical
+			// form, are verified against the applied file itself.
+			if !previousLoaded {
+				previous = s.cachedPreviousBundles(ctx, b)
+				previousLoaded = true
+			}
+			oldData, oldBundle := findAppliedFile(previous, mig.Version, applied.Checksum)
+			switch {
+			case oldData == nil && applied.CanonicalChecksum == "":
+				item.Reason = "no canonical checksum recorded and the applied file is not in a cached bundle; pass previous_bundle_id"
+				result.Skipped = append(result.Skipped, item)
+				continue
+			case oldData == nil || setup.CanonicalSHA256Hex(oldData) != canonical:
+				item.Reason = "content changed beyond whitespace"
+				result.Skipped = append(result.Skipped, item)
+				continue
+			}
+			verifiedBy = "bundle:" + oldBundle
+		}
+
+		if err := s.repo.UpdateMigrationChecksum(ctx, conn, b.Manifest.App, mig.Version, item.NewChecksum, canonical); err != nil {
+			return nil, fmt.Errorf("failed to update checksum of %s: %w", mig.Version, err)
+		}
+		result.Repaired = append(result.Repaired, item)
+		s.audit(ctx, conn, sc, AuditEntityMigration, mig.Version, AuditActionRepair, map[string]any{
+			"old_checksum": item.OldChecksum,
+			"new_checksum": item.NewChecksum,
+			"verified_by":  verifiedBy,
+		})
+		logger.Info().Ctx(ctx).Str("version", mig.Version).Str("old", item.OldChecksum).Str("new", item.NewChecksum).Msg("Migration checksum repaired")
+	}
+	return result, nil
+}
+
+// previousBundle loads the cached bundle id for Repair; it must be for the same app as b.
+func (s *Service) previousBundle(id string, b *preparedBundle) (*preparedBundle, error) {
+	baseDir, manifest, err := s.loadCachedBundle(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load previous bundle %s: %w", id, err)
+	}
+	if manifest.App != b.Manifest.App {
+		return nil, fmt.Errorf("%w: previous bundle %s is for app %q, not %q", ErrOtherApp, id, manifest.App, b.Manifest.App)
+	}
+	return &preparedBundle{ID: id, BaseDir: baseDir, Manifest: manifest}, nil
+}
+
+// cachedPreviousBundles returns the other cached bundles of b's app, most recently used
+// first. Bundles that fail to load are logged and left out.
+func (s *Service) cachedPreviousBundles(ctx context.Context, b *preparedBundle) []*preparedBundle {
+	metas, err := s.store.List()
+	if err != nil {
+		logger.Warn().Ctx(ctx).Err(err).Msg("Failed to list cached bundles")
+		return nil
+	}
+	var bundles []*preparedBundle
+	for _, meta := range metas {
+		if meta.ID == b.ID || (meta.App != "" && meta.App != b.Manifest.App) {
+			continue
+		}
+		pb, err := s.previousBundle(meta.ID, b)
+		if err != nil {
+			logger.Debug().Ctx(ctx).Err(err).Str("bundle_id", meta.ID).Msg("Skipping cached bundle")
+			continue
+		}
+		bundles = append(bundles, pb)
+	}
+	return bundles
+}
+
+// findAppliedFile returns the content of migration version whose SHA-256 is checksum, and
+// the ID of the bundle it was found in, or nil.
+func findAppliedFile(bundles []*preparedBundle, version, checksum string) ([]byte, string) {
+	for _, pb := range bundles {
+		for _, mig := range pb.Manifest.Migrations {
+			if mig.Version != version {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(pb.BaseDir, mig.File))
+			if err == nil && setup.SHA256Hex(data) == checksum {
+				return data, pb.ID
+			}
+		}
+	}
+	return nil, ""
+}
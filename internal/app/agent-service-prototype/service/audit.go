@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"agent-service-prototype/internal/app/agent-service-prototype/repository"
+	"agent-service-prototype/pkg/logger"
+)
+
+// Audit entities and actions written to AUDIT_SCHEMA.audit_logs.
+const (
+	AuditEntityInstallation = "installation"
+	AuditEntityMigration    = "schema_migration"
+	AuditEntityBackup       = "backup"
+	AuditEntityTenant       = "tenant"
+
+	AuditActionInstall       = "INSTALL"
+	AuditActionInstallFailed = "INSTALL_FAILED"
+	AuditActionApply         = "APPLY"
+	AuditActionSkip          = "SKIP"
+	AuditActionForceRerun    = "FORCE_RERUN"
+	AuditActionFail          = "FAIL"
+	AuditActionRollback      = "ROLLBACK"
+	AuditActionAdopt         = "ADOPT"
+	AuditActionRepair        = "REPAIR"
+	AuditActionDelete        = "DELETE"
+)
+
+// ErrAuditUnavailable is returned by VerifyAudit when the database has no audit_logs table.
+var ErrAuditUnavailable = repository.ErrAuditTableMissing
+
+// Actor identifies who triggered an operation, for the audit trail.
+type Actor struct {
+	User string
+	// Role is the caller's role; it is empty for the agent's own runs and CLI commands.
+	Role      string
+	RequestID string
+}
+
+// auditScope carries the fields every audit row of one installation on one target shares.
+type auditScope struct {
+	Actor
+	RunID         string
+	Target        string
+	App           string
+	BundleID      string
+	BundleVersion string
+	Tenant        string
+}
+
+// targetLabel names the scope's target in metrics; the primary database has no name.
+func (sc auditScope) targetLabel() string {
+	return installTarget{Name: sc.Target}.label()
+}
+
+// entry builds an audit row with the scope's fields merged into payload.
+func (sc auditScope) entry(entity, entityID, action string, payload map[string]any) repository.AuditEntry {
+	p := map[string]any{
+		"run_id":         sc.RunID,
+		"bundle_id":      sc.BundleID,
+		"bundle_version": sc.BundleVersion,
+		"user":           sc.User,
+		"request_id":     sc.RequestID,
+	}
+	for k, v := range map[string]string{"role": sc.Role, "target": sc.Target, "app": sc.App, "tenant": sc.Tenant} {
+		if v != "" {
+			p[k] = v
+		}
+	}
+	for k, v := range payload {
+		p[k] = v
+	}
+	return repository.AuditEntry{Entity: entity, EntityID: entityID, Action: action, Payload: p}
+}
+
+// auditChain is the configured audit table and HMAC key (none when AUDIT_HMAC_KEY is unset).
+func (s *Service) auditChain() repository.AuditChain {
+	chain := repository.AuditChain{Schema: s.cfg.DB.AuditSchema}
+	if s.cfg.HTTP.AuditHMACKey != "" {
+		chain.Key = []byte(s.cfg.HTTP.AuditHMACKey)
+	}
+	return chain
+}
+
+// audit writes an audit row for sc on conn and advances the target's anchor. Failures are
+// logged, not returned: the audited operation has already happened by the time it is recorded.
+func (s *Service) audit(ctx context.Context, conn *sql.Conn, sc auditScope, entity, entityID, action string, payload map[string]any) {
+	err := s.writeAudit(ctx, conn, sc, entity, entityID, action, payload)
+	switch {
+	case err == nil:
+	case errors.Is(err, repository.ErrAuditTableMissing):
+		logger.Debug().Ctx(ctx).Str("entity", entity).Str("action", action).Msg("No audit table, audit log skipped")
+	default:
+		logger.Error().Ctx(ctx).Err(err).Str("entity", entity).Str("entity_id", entityID).Str("action", action).Msg("Failed to write audit log")
+	}
+}
+
+// writeAudit writes an audit row for sc on conn and advances the target's anchor. Unlike audit
+// it returns the error, for operations that must not run unrecorded.
+func (s *Service) writeAudit(ctx context.Context, conn *sql.Conn, sc auditScope, entity, entityID, action string, payload map[string]any) error {
+	link, err := s.repo.WriteAuditLog(ctx, conn, s.auditChain(), sc.entry(entity, entityID, action, payload))
+	if err != nil {
+		return err
+	}
+	if err := s.saveAuditAnchor(sc.targetLabel(), link); err != nil {
+		logger.Error().Ctx(ctx).Err(err).Str("target", sc.targetLabel()).Msg("Failed to save audit anchor")
+	}
+	return nil
+}
+
+// auditAnchorPath is the file under WORK_DIR/audit holding the latest audit link of a target.
+func (s *Service) auditAnchorPath(label string) string {
+	return filepath.Join(s.cfg.HTTP.WorkDir, "audit", "anchor-"+url.PathEscape(label)+".json")
+}
+
+// loadAuditAnchor returns the anchored link of a target, or nil when none was saved yet.
+func (s *Service) loadAuditAnchor(label string) (*repository.AuditLink, error) {
+	data, err := os.ReadFile(s.auditAnchorPath(label))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit anchor: %w", err)
+	}
+	var link repository.AuditLink
+	if err := json.Unmarshal(data, &link); err != nil {
+		return nil, fmt.Errorf("failed to parse audit anchor: %w", err)
+	}
+	return &link, nil
+}
+
+// saveAuditAnchor records link as the target's anchor unless a later link is already anchored
+// (another installation may have written after it).
+func (s *Service) saveAuditAnchor(label string, link repository.AuditLink) error {
+	s.anchorMu.Lock()
+	defer s.anchorMu.Unlock()
+
+	prev, err := s.loadAuditAnchor(label)
+	if err != nil {
+		return err
+	}
+	if prev != nil && prev.ID >= link.ID {
+		return nil
+	}
+	path := s.auditAnchorPath(label)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to write audit anchor: %w", err)
+	}
+	data, err := json.Marshal(link)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit anchor: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write audit anchor: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write audit anchor: %w", err)
+	}
+	return nil
+}
+
+// VerifyAudit checks the hash chain of the primary database's audit trail against anchor, or
+// against the anchor saved in WORK_DIR when anchor is nil.
+func (s *Service) VerifyAudit(ctx context.Context, anchor *repository.AuditLink) (*repository.AuditChainReport, error) {
+	if anchor == nil {
+		var err error
+		if anchor, err = s.loadAuditAnchor(installTarget{}.label()); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := s.repo.DB().Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Close()
+	return s.repo.VerifyAuditChain(ctx, conn, s.auditChain(), anchor)
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"agent-service-prototype/pkg/logger"
+)
+
+// AutoInstallActor is the audit actor of the AUTO_INSTALL startup installation.
+const AutoInstallActor = "auto-install"
+
+// dbWaitPollInterval is how often WaitForDatabase checks whether the database is reachable
+// when the server started in degraded mode.
+const dbWaitPollInterval = 5 * time.Second
+
+// WaitForDatabase blocks until the primary database answers a ping or ctx is done, for
+// installations that start while the server is in degraded mode.
+func (s *Service) WaitForDatabase(ctx context.Context) error {
+	for {
+		pingCtx, cancel := context.WithTimeout(ctx, readinessTimeout)
+		err := s.repo.DB().PingContext(pingCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		logger.Warn().Err(err).Msg("Database not reachable yet, waiting to install")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(dbWaitPollInterval):
+		}
+	}
+}
+
+// autoInstallRetryDelay is how long AutoInstall waits before retrying after another replica
+// held the advisory lock for longer than LOCK_TIMEOUT.
+const autoInstallRetryDelay = 10 * time.Second
+
+// AutoInstall runs the AUTO_INSTALL startup installation of BUNDLE_URL on the primary database.
+// Replicas starting together serialise on the advisory lock; the installation is skipped, even
+// with FORCE, once another replica has applied every migration of the bundle. A lock timeout
+// is retried until ctx is done; any other failure is left in the status and keeps the agent not
+// ready until an installation succeeds. The installation itself runs under installCtx, so
+// shutdown gives it the grace period.
+func (s *Service) AutoInstall(ctx, installCtx context.Context) {
+	actor := Actor{User: AutoInstallActor}
+	for {
+		if !s.TryStart(actor) {
+			logger.Warn().Msg("AUTO_INSTALL: an installation is already running, skipping startup installation")
+			return
+		}
+		logger.Info().Str("bundle_url", s.cfg.HTTP.BundleURL).Msg("🔄 AUTO_INSTALL: running startup installation")
+		result := s.RunInstallation(installCtx, InstallOptions{Actor: actor, SkipIfCurrent: true})
+		switch {
+		case result.Success && result.UpToDate:
+			logger.Info().Str("run_id", result.RunID).Str("bundle_id", result.BundleID).Str("schema_version", result.SchemaVersion).Msg("✅ AUTO_INSTALL: schema already at the bundle version")
+			return
+		case result.Success:
+			logger.Info().Str("run_id", result.RunID).Str("bundle_id", result.BundleID).Str("schema_version", result.SchemaVersion).Msg("✅ AUTO_INSTALL: startup installation finished")
+			return
+		case !result.LockTimeout:
+			logger.Error().Str("run_id", result.RunID).Str("step", result.Step).Str("error", result.Error).Msg("❌ AUTO_INSTALL: startup installation failed, agent stays not ready")
+			return
+		}
+
+		logger.Warn().Str("run_id", result.RunID).Str("error", result.Error).Dur("retry_in", autoInstallRetryDelay).Msg("AUTO_INSTALL: advisory lock held by another installation, retrying")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(autoInstallRetryDelay):
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"agent-service-prototype/pkg/backup"
+	"agent-service-prototype/pkg/logger"
+	"agent-service-prototype/pkg/setup"
+)
+
+// backupsDir is WORK_DIR/backups; each run gets a <run-id> subdirectory.
+func (s *Service) backupsDir() string {
+	return filepath.Join(s.cfg.HTTP.WorkDir, "backups")
+}
+
+// backupPendingTables backs up the tables touched by migrations that are about to run.
+// Tables come from each migration's backup_tables, or are inferred from its ALTER TABLE targets.
+func (s *Service) backupPendingTables(ctx context.Context, conn *sql.Conn, t installTarget, runID, bundleID, baseDir string, manifest *setup.Manifest, force bool) error {
+	defaultSchema := manifest.DB.DefaultSchema
+	if defaultSchema == "" {
+		defaultSchema = "public"
+	}
+
+	var tables, versions []string
+	seen := make(map[string]bool)
+	for _, mig := range manifest.Migrations {
+		applied, err := s.repo.GetMigrationRecord(ctx, conn, manifest.App, mig.Version)
+		if err != nil {
+			return fmt.Errorf("failed to check migration %s: %w", mig.Version, err)
+		}
+		if applied != nil && applied.Success && !force {
+			continue
+		}
+		migTables := mig.BackupTables
+		if len(migTables) == 0 {
+			data, err := os.ReadFile(filepath.Join(baseDir, mig.File))
+			if err != nil {
+				return fmt.Errorf("failed to read migration %s: %w", mig.Version, err)
+			}
+			migTables = setup.InferAlteredTables(setup.RenderSQL(string(data), defaultSchema), defaultSchema)
+		}
+		versions = append(versions, mig.Version)
+		for _, t := range migTables {
+			if !seen[t] {
+				seen[t] = true
+				tables = append(tables, t)
+			}
+		}
+	}
+	if len(tables) == 0 {
+		logger.Info().Ctx(ctx).Msg("No tables to back up")
+		return nil
+	}
+
+	// Targets of a multi-target run share the run ID, so each gets its own backup ID.
+	id := runID
+	if t.Name != "" {
+		id = runID + "-" + t.Name
+	}
+	dir := filepath.Join(s.backupsDir(), id)
+	m := &backup.Manifest{RunID: id, BundleID: bundleID, Target: t.Name, Migrations: versions}
+	if err := backup.Create(ctx, t.DSN, dir, m, tables); err != nil {
+		return err
+	}
+	logger.Info().Ctx(ctx).Int("tables", len(m.Tables)).Str("dir", dir).Msg("Pre-migration backup created")
+	return nil
+}
+
+// ListBackups returns the backups in WORK_DIR/backups, newest first.
+func (s *Service) ListBackups() ([]backup.Manifest, error) {
+	return backup.List(s.backupsDir())
+}
+
+// RestoreBackup loads backup runID back into the database it was taken from while holding
+// the installer's advisory lock, so it cannot interleave with an installation. When tables
+// is non-empty only those tables are restored. Backups of a named target can only be
+// restored while that target is listed in DB_TARGETS. The restore is audited as a ROLLBACK.
+func (s *Service) RestoreBackup(ctx context.Context, runID string, tables []string, actor Actor) (*backup.Manifest, error) {
+	dir := filepath.Join(s.backupsDir(), filepath.Base(runID))
+	bm, err := backup.Load(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	db, dsn := s.repo.DB(), s.cfg.DatabaseURL()
+	if bm.Target != "" {
+		t, err := s.configuredTarget(bm.Target)
+		if err != nil {
+			return nil, err
+		}
+		if db, err = openTargetDB(t.DSN); err != nil {
+			return nil, err
+		}
+		defer db.Close()
+		dsn = t.DSN
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	unlock, err := s.lockDB(ctx, conn, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	start := time.Now()
+	m, err := backup.Restore(ctx, dsn, dir, tables)
+	sc := auditScope{Actor: actor, RunID: bm.RunID, Target: bm.Target, BundleID: bm.BundleID}
+	payload := map[string]any{
+		"migrations":  bm.Migrations,
+		"tables":      tables,
+		"duration_ms": time.Since(start).Milliseconds(),
+		"success":     err == nil,
+	}
+	if err != nil {
+		payload["error"] = err.Error()
+	}
+	s.audit(ctx, conn, sc, AuditEntityBackup, bm.RunID, AuditActionRollback, payload)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info().Ctx(ctx).Str("run_id", runID).Msg("Backup restored")
+	return m, nil
+}
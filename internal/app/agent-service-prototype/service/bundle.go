@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"agent-service-prototype/pkg/logger"
+	"agent-service-prototype/pkg/setup"
+)
+
+// UploadBundle stores r in the bundle store after checking that it is a readable
+// bundle whose checksums match. Invalid bundles are not kept.
+func (s *Service) UploadBundle(r io.Reader) (*setup.BundleMeta, error) {
+	id, err := s.store.Put(r, "upload")
+	if err != nil {
+		return nil, err
+	}
+	zipPath, err := s.store.Path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := verifyBundleZip(zipPath)
+	if err != nil {
+		if delErr := s.store.Delete(id); delErr != nil {
+			logger.Error().Err(delErr).Str("bundle_id", id).Msg("Failed to remove invalid bundle")
+		}
+		return nil, err
+	}
+
+	if err := s.store.UpdateManifest(id, manifest); err != nil {
+		return nil, err
+	}
+	logger.Info().Str("bundle_id", id).Str("bundle_version", manifest.BundleVersion).Msg("Bundle stored")
+	return s.store.Meta(id)
+}
+
+// ListBundles returns the cached bundles, most recently used first.
+func (s *Service) ListBundles() ([]setup.BundleMeta, error) {
+	return s.store.List()
+}
+
+// pruneBundles applies BUNDLE_RETENTION_COUNT / BUNDLE_RETENTION_DAYS to the cache.
+// The bundle just installed is always kept.
+func (s *Service) pruneBundles(keepID string) {
+	days := time.Duration(s.cfg.HTTP.BundleRetentionDays) * 24 * time.Hour
+	removed, err := s.store.Prune(s.cfg.HTTP.BundleRetentionCount, days, keepID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to prune bundle cache")
+	}
+	for _, id := range removed {
+		logger.Info().Str("bundle_id", id).Msg("Bundle removed by retention policy")
+	}
+}
+
+// resolveBundle returns the verified bundle selected by opts (a cached bundle_id, a
+// bundle_url, or BUNDLE_URL), fetching it into the store when needed. Unlike prepareBundle
+// it does not touch the installation status, so it is used outside installation runs.
+func (s *Service) resolveBundle(ctx context.Context, opts InstallOptions) (*preparedBundle, error) {
+	id := opts.BundleID
+	if id == "" {
+		url := opts.BundleURL
+		if url == "" {
+			url = s.cfg.HTTP.BundleURL
+		}
+		if url == "" {
+			return nil, fmt.Errorf("no bundle selected and BUNDLE_URL is not configured")
+		}
+		fetched, err := s.store.Fetch(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		id = fetched
+	}
+	baseDir, manifest, err := s.loadCachedBundle(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.UpdateManifest(id, manifest); err != nil {
+		logger.Error().Ctx(ctx).Err(err).Str("bundle_id", id).Msg("Failed to update bundle metadata")
+	}
+	return &preparedBundle{ID: id, BaseDir: baseDir, Manifest: manifest}, nil
+}
+
+// HasBundle reports whether id is in the bundle store.
+func (s *Service) HasBundle(id string) bool {
+	_, err := s.store.Path(id)
+	return !errors.Is(err, setup.ErrBundleNotFound)
+}
+
+// verifyBundleZip extracts zipPath into a temporary directory, verifies its
+// checksums and returns its manifest.
+func verifyBundleZip(zipPath string) (*setup.Manifest, error) {
+	tmpDir, err := os.MkdirTemp(filepath.Dir(zipPath), "verify-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := setup.ExtractZip(zipPath, tmpDir); err != nil {
+		return nil, err
+	}
+	baseDir, err := setup.ResolveBaseDir(tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	checksums, err := setup.LoadChecksums(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := setup.VerifyChecksums(baseDir, checksums); err != nil {
+		return nil, err
+	}
+	return setup.LoadManifest(baseDir)
+}
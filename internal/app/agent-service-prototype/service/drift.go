@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"agent-service-prototype/pkg/setup"
+)
+
+const (
+	DriftAgainstInstalled = "installed"
+	DriftAgainstBundle    = "bundle"
+)
+
+// ErrSnapshotNotFound is returned when there is no expected snapshot to compare with.
+var ErrSnapshotNotFound = errors.New("schema snapshot not found")
+
+// DriftReport compares the live schema with an expected snapshot.
+type DriftReport struct {
+	Against       string
+	BundleID      string
+	SchemaVersion string
+	ExpectedAt    time.Time
+	Items         []setup.DriftItem
+}
+
+// DetectDrift captures the live schema and diffs it against the snapshot saved for app after
+// its last successful installation (against = "installed") or the snapshot shipped in a
+// cached bundle (against = "bundle"; bundleID defaults to the bundle of that saved snapshot).
+// An empty app defaults to the app installed last.
+func (s *Service) DetectDrift(ctx context.Context, against, bundleID, app string) (*DriftReport, error) {
+	if against != DriftAgainstInstalled && against != DriftAgainstBundle {
+		return nil, fmt.Errorf("unknown drift source %q", against)
+	}
+	conn, err := s.repo.DB().Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	report := &DriftReport{Against: against}
+	var expected *setup.SchemaSnapshot
+
+	if against == DriftAgainstInstalled || bundleID == "" {
+		if app == "" {
+			if app, err = s.repo.LatestSnapshotApp(ctx, conn); err != nil {
+				return nil, fmt.Errorf("failed to load saved snapshot: %w", err)
+			}
+		}
+		rec, err := s.repo.LatestSchemaSnapshot(ctx, conn, app)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load saved snapshot: %w", err)
+		}
+		if rec == nil {
+			return nil, fmt.Errorf("%w: no installation of app %q has saved a snapshot yet", ErrSnapshotNotFound, app)
+		}
+		if against == DriftAgainstInstalled {
+			expected = rec.Snapshot
+			report.BundleID = rec.BundleID
+			report.SchemaVersion = rec.SchemaVersion
+			report.ExpectedAt = rec.CreatedAt
+		} else if bundleID = rec.BundleID; bundleID == "" {
+			return nil, fmt.Errorf("%w: the saved snapshot of app %q names no bundle", ErrSnapshotNotFound, app)
+		}
+	}
+
+	if against == DriftAgainstBundle {
+		baseDir, manifest, err := s.loadCachedBundle(bundleID)
+		if err != nil {
+			return nil, err
+		}
+		if manifest.Snapshot == "" {
+			return nil, fmt.Errorf("%w: bundle %s does not ship a snapshot", ErrSnapshotNotFound, bundleID)
+		}
+		expected, err = setup.LoadSnapshot(baseDir, manifest.Snapshot)
+		if err != nil {
+			return nil, err
+		}
+		report.BundleID = bundleID
+		report.SchemaVersion = manifest.TargetSchemaVersion
+	}
+
+	actual, err := s.repo.CaptureSchemaSnapshot(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture schema snapshot: %w", err)
+	}
+	report.Items = setup.DiffSnapshots(expected, actual)
+	return report, nil
+}
+
+// loadCachedBundle returns the extracted base dir and manifest of a cached bundle,
+// extracting it first when needed. Checksums are verified on every call.
+func (s *Service) loadCachedBundle(id string) (string, *setup.Manifest, error) {
+	zipPath, err := s.store.Path(id)
+	if err != nil {
+		return "", nil, err
+	}
+	extractDir := s.store.ExtractDir(id)
+	baseDir, err := setup.ResolveBaseDir(extractDir)
+	if err != nil {
+		if err := os.RemoveAll(extractDir); err != nil {
+			return "", nil, fmt.Errorf("failed to clean extract dir: %w", err)
+		}
+		if err := setup.ExtractZip(zipPath, extractDir); err != nil {
+			return "", nil, err
+		}
+		if baseDir, err = setup.ResolveBaseDir(extractDir); err != nil {
+			return "", nil, err
+		}
+	}
+	checksums, err := setup.LoadChecksums(baseDir)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := setup.VerifyChecksums(baseDir, checksums); err != nil {
+		return "", nil, err
+	}
+	manifest, err := setup.LoadManifest(baseDir)
+	if err != nil {
+		return "", nil, err
+	}
+	return baseDir, manifest, nil
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"agent-service-prototype/internal/app/agent-service-prototype/repository"
+	"agent-service-prototype/pkg/setup"
+)
+
+const (
+	DiffPending          = "pending"
+	DiffApplied          = "applied"
+	DiffFailed           = "failed"
+	DiffChecksumMismatch = "checksum_mismatch"
+	DiffNotInBundle      = "not_in_bundle"
+)
+
+// BundleSource is where a bundle comes from: an uploaded zip or a URL.
+type BundleSource struct {
+	Upload io.Reader
+	URL    string
+}
+
+// MigrationDiff compares one migration in the bundle with the migration history.
+type MigrationDiff struct {
+	Version          string
+	Name             string
+	State            string
+	BundleChecksum   string
+	RecordedChecksum string
+	AppliedAt        time.Time
+}
+
+// BundleInspection is the result of InspectBundle.
+type BundleInspection struct {
+	SHA256      string
+	Manifest    *setup.Manifest
+	Checksums   []setup.ChecksumResult
+	ChecksumsOK bool
+	LintErrors  []string
+	FreshDB     bool
+	Diff        []MigrationDiff
+	DiffError   string
+}
+
+// InspectBundle fetches and analyses a bundle without taking the advisory lock
+// or executing any bundle SQL. Only the migration history is read.
+func (s *Service) InspectBundle(ctx context.Context, src BundleSource) (*BundleInspection, error) {
+	workDir := s.cfg.HTTP.WorkDir
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create work dir: %w", err)
+	}
+	tmpDir, err := os.MkdirTemp(workDir, "inspect-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	zipPath := filepath.Join(tmpDir, "db-bundle.zip")
+	switch {
+	case src.Upload != nil:
+		if err := writeFile(zipPath, src.Upload); err != nil {
+			return nil, err
+		}
+	case src.URL != "":
+		if err := setup.DownloadBundle(ctx, src.URL, zipPath); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("bundle file or url is required")
+	}
+
+	sum, err := fileSHA256(zipPath)
+	if err != nil {
+		return nil, err
+	}
+
+	extractDir := filepath.Join(tmpDir, "bundle")
+	if err := setup.ExtractZip(zipPath, extractDir); err != nil {
+		return nil, err
+	}
+	baseDir, err := setup.ResolveBaseDir(extractDir)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := setup.LoadManifest(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	ins := &BundleInspection{SHA256: sum, Manifest: manifest, LintErrors: []string{}}
+
+	checksums, err := setup.LoadChecksums(baseDir)
+	if err != nil {
+		ins.LintErrors = append(ins.LintErrors, err.Error())
+		checksums = map[string]string{}
+	}
+	ins.Checksums = setup.CheckChecksums(baseDir, checksums)
+	ins.ChecksumsOK = err == nil
+	for _, c := range ins.Checksums {
+		if !c.OK {
+			ins.ChecksumsOK = false
+		}
+	}
+	for _, lintErr := range setup.LintBundle(baseDir, manifest, checksums) {
+		ins.LintErrors = append(ins.LintErrors, lintErr.Error())
+	}
+
+	if err := s.diffAgainstDB(ctx, baseDir, manifest, ins); err != nil {
+		ins.DiffError = err.Error()
+	}
+	return ins, nil
+}
+
+// diffAgainstDB fills ins.FreshDB and ins.Diff from the manifest app's migration history.
+func (s *Service) diffAgainstDB(ctx context.Context, baseDir string, manifest *setup.Manifest, ins *BundleInspection) error {
+	conn, err := s.repo.DB().Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	fresh, err := s.repo.IsFreshDB(ctx, conn, manifest.App)
+	if err != nil {
+		return fmt.Errorf("failed to detect DB state: %w", err)
+	}
+	records, err := s.repo.ListMigrationRecords(ctx, conn, manifest.App)
+	if err != nil {
+		return fmt.Errorf("failed to list migrations: %w", err)
+	}
+	ins.FreshDB = fresh
+	ins.Diff = diffMigrations(baseDir, manifest, records)
+	return nil
+}
+
+// diffMigrations classifies every bundle migration against the recorded rows and
+// appends recorded versions that the bundle does not contain.
+func diffMigrations(baseDir string, manifest *setup.Manifest, records []repository.MigrationRecord) []MigrationDiff {
+	byVersion := make(map[string]repository.MigrationRecord, len(records))
+	for _, rec := range records {
+		byVersion[rec.Version] = rec
+	}
+
+	diff := make([]MigrationDiff, 0, len(manifest.Migrations))
+	inBundle := make(map[string]bool, len(manifest.Migrations))
+	for _, mig := range manifest.Migrations {
+		inBundle[mig.Version] = true
+		d := MigrationDiff{Version: mig.Version, Name: mig.Name, State: DiffPending}
+		if setup.ValidateBundlePath(mig.File) == nil {
+			if data, err := os.ReadFile(filepath.Join(baseDir, mig.File)); err == nil {
+				d.BundleChecksum = setup.SHA256Hex(data)
+			}
+		}
+		if rec, ok := byVersion[mig.Version]; ok {
+			d.RecordedChecksum = rec.Checksum
+			d.AppliedAt = rec.AppliedAt
+			switch {
+			case !rec.Success:
+				d.State = DiffFailed
+			case rec.Checksum != d.BundleChecksum:
+				d.State = DiffChecksumMismatch
+			default:
+				d.State = DiffApplied
+			}
+		}
+		diff = append(diff, d)
+	}
+
+	for _, rec := range records {
+		if inBundle[rec.Version] || rec.Version == baselineRecordVersion {
+			continue
+		}
+		diff = append(diff, MigrationDiff{
+			Version:          rec.Version,
+			Name:             rec.Name,
+			State:            DiffNotInBundle,
+			RecordedChecksum: rec.Checksum,
+			AppliedAt:        rec.AppliedAt,
+		})
+	}
+	return diff
+}
+
+// writeFile copies r into a new file at dest.
+func writeFile(dest string, r io.Reader) error {
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", dest, err)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to write %s: %w", dest, err)
+	}
+	return out.Close()
+}
+
+// fileSHA256 returns the hex SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"agent-service-prototype/internal/app/agent-service-prototype/repository"
+	"agent-service-prototype/pkg/logger"
+	"agent-service-prototype/pkg/setup"
+)
+
+// ErrLockTimeout is returned when the advisory lock is not acquired within LOCK_TIMEOUT.
+var ErrLockTimeout = errors.New("timed out waiting for advisory lock")
+
+const lockPollInterval = time.Second
+
+// unlockTimeout bounds releasing the lock; it runs on a fresh context so a cancelled
+// installation still releases it.
+const unlockTimeout = 10 * time.Second
+
+// lockDB takes the installer's advisory lock on conn, polling pg_try_advisory_lock until
+// LOCK_TIMEOUT expires. The session is tagged with application_name so other agents can
+// identify the holder. While waiting, onWait (if set) receives the current holder; it is
+// called with nil once the lock is acquired. The returned function releases the lock; if
+// that fails the session is discarded from the pool, which releases the lock server-side.
+func (s *Service) lockDB(ctx context.Context, conn *sql.Conn, onWait func(*setup.LockHolder)) (func(), error) {
+	key := s.advisoryKey()
+	timeout := s.cfg.HTTP.LockTimeout
+	tag := s.lockTag()
+	if err := s.repo.SetApplicationName(ctx, conn, tag); err != nil {
+		logger.Warn().Ctx(ctx).Err(err).Msg("Failed to set application_name")
+	}
+
+	deadline := time.Now().Add(timeout)
+	var holder *setup.LockHolder
+	for {
+		locked, err := s.repo.TryAdvisoryLock(ctx, conn, key)
+		if err != nil {
+			s.resetApplicationName(ctx, conn)
+			return nil, fmt.Errorf("failed to acquire advisory lock: %w", err)
+		}
+		if locked {
+			break
+		}
+
+		if sess, err := s.repo.AdvisoryLockHolder(ctx, conn, key); err != nil {
+			logger.Warn().Ctx(ctx).Err(err).Msg("Failed to look up advisory lock holder")
+		} else if sess != nil {
+			if holder == nil || holder.PID != sess.PID {
+				logger.Warn().Ctx(ctx).Int64("key", key).Int("pid", sess.PID).Str("application", sess.ApplicationName).Str("client_addr", sess.ClientAddr).Msg("Advisory lock is held, waiting")
+			}
+			holder = lockHolder(sess)
+			if onWait != nil {
+				onWait(holder)
+			}
+		}
+
+		if !time.Now().Before(deadline) {
+			s.resetApplicationName(ctx, conn)
+			if holder != nil {
+				return nil, fmt.Errorf("%w %d after %s: held by pid %d (%s, %s) for %.0fs",
+					ErrLockTimeout, key, timeout, holder.PID, holder.Application, holder.ClientAddr, holder.HeldSeconds)
+			}
+			return nil, fmt.Errorf("%w %d after %s", ErrLockTimeout, key, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			s.resetApplicationName(ctx, conn)
+			return nil, fmt.Errorf("failed to acquire advisory lock: %w", ctx.Err())
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	if onWait != nil {
+		onWait(nil)
+	}
+	if err := s.repo.SetApplicationName(ctx, conn, fmt.Sprintf("%s@%d", tag, time.Now().Unix())); err != nil {
+		logger.Warn().Ctx(ctx).Err(err).Msg("Failed to set application_name")
+	}
+	logger.Info().Ctx(ctx).Int64("key", key).Str("application_name", tag).Msg("Advisory lock acquired")
+
+	return func() {
+		uctx, cancel := context.WithTimeout(context.Background(), unlockTimeout)
+		defer cancel()
+		if err := s.repo.AdvisoryUnlock(uctx, conn, key); err != nil {
+			logger.Error().Ctx(ctx).Err(err).Msg("Failed to release advisory lock, discarding the session")
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+			return
+		}
+		logger.Info().Ctx(ctx).Msg("Advisory lock released")
+		s.resetApplicationName(ctx, conn)
+	}, nil
+}
+
+// resetApplicationName removes the installer tag from the session before it goes back to the
+// pool. If that fails the session is discarded, so an idle connection is never mistaken for
+// the lock holder.
+func (s *Service) resetApplicationName(ctx context.Context, conn *sql.Conn) {
+	rctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), unlockTimeout)
+	defer cancel()
+	if err := s.repo.SetApplicationName(rctx, conn, ""); err != nil {
+		logger.Warn().Ctx(ctx).Err(fmt.Errorf("failed to reset application_name: %w", err)).Msg("Discarding the session")
+		conn.Raw(func(any) error { return driver.ErrBadConn })
+	}
+}
+
+// lockTag returns the application_name for installer sessions: APPLICATION_NAME:<hostname>,
+// kept short enough that the "@<unix time>" suffix fits in Postgres' 63-byte limit.
+func (s *Service) lockTag() string {
+	host, _ := os.Hostname()
+	tag := s.cfg.HTTP.ApplicationName
+	if host != "" {
+		tag += ":" + host
+	}
+	if len(tag) > 51 {
+		tag = tag[:51]
+	}
+	return tag
+}
+
+// lockHolder converts a pg_stat_activity session into a LockHolder. Sessions tagged by an
+// agent carry the acquisition time after "@"; for others the connection start is used.
+func lockHolder(sess *repository.LockSession) *setup.LockHolder {
+	h := &setup.LockHolder{
+		PID:         sess.PID,
+		Application: sess.ApplicationName,
+		ClientAddr:  sess.ClientAddr,
+		HeldSince:   sess.BackendStart,
+	}
+	if i := strings.LastIndex(sess.ApplicationName, "@"); i >= 0 {
+		if ts, err := strconv.ParseInt(sess.ApplicationName[i+1:], 10, 64); err == nil {
+			h.HeldSince = time.Unix(ts, 0)
+		}
+	}
+	h.HeldSeconds = sess.Now.Sub(h.HeldSince).Seconds()
+	return h
+}
@@ -0,0 +1,356 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"agent-service-prototype/pkg/logger"
+	"agent-service-prototype/pkg/setup"
+)
+
+// AutoUpgradeActor is the audit actor of installations started by bundle polling.
+const AutoUpgradeActor = "auto-upgrade"
+
+// UpdateCheck is the state of BUNDLE_POLL_INTERVAL polling.
+type UpdateCheck struct {
+	LastCheckAt time.Time
+	// LastError is the error of the last check, if it failed.
+	LastError string
+	// AvailableBundleID and AvailableVersion describe the bundle currently at BUNDLE_URL;
+	// AvailableSchemaVersion is the version of its last migration.
+	AvailableBundleID      string
+	AvailableVersion       string
+	AvailableSchemaVersion string
+	// InstalledSchemaVersion is the latest migration version the bundle's app has applied on
+	// the primary database, read from the migration history.
+	InstalledSchemaVersion string
+	// InstalledVersion is the bundle_version of the bundle last installed for the app, or ""
+	// when it is not known (see installedBundleVersion).
+	InstalledVersion string
+	// UpgradePending is set while the available bundle is newer than the installed one (see
+	// upgradePending).
+	UpgradePending bool
+	// availableApp is the manifest app of the available bundle.
+	availableApp string
+	// NextWindowStart and NextWindowEnd bound the current or next maintenance window.
+	NextWindowStart time.Time
+	NextWindowEnd   time.Time
+}
+
+// GetUpdateCheck returns a copy of the polling state, or nil when polling is disabled.
+func (s *Service) GetUpdateCheck() *UpdateCheck {
+	if s.cfg.HTTP.BundlePollInterval <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := s.updates
+	return &cp
+}
+
+// PollBundles checks BUNDLE_URL every BUNDLE_POLL_INTERVAL until ctx is done. Unchanged
+// bundles are detected by ETag (If-None-Match) or, without one, by their SHA-256. When the
+// bundle at BUNDLE_URL ends at a newer migration than the database has applied, or has the same
+// migrations under a newer bundle_version, it is installed inside MAINTENANCE_WINDOW; outside the window the poller wakes up again when it opens. An
+// upgrade whose baseline or migrations failed is not retried until a different bundle appears.
+// Installations run under installCtx so shutdown can give a running upgrade its grace period.
+func (s *Service) PollBundles(ctx, installCtx context.Context) {
+	window, err := s.cfg.Maintenance()
+	if err != nil {
+		logger.Error().Err(err).Msg("Invalid maintenance window, bundle polling disabled")
+		return
+	}
+	interval := s.cfg.HTTP.BundlePollInterval
+	logger.Info().Dur("interval", interval).Str("window", window.String()).Msg("🔄 Bundle polling started")
+
+	var etag, failedID string
+	for {
+		u := s.checkForUpdate(ctx, &etag)
+		now := time.Now()
+		start, end := window.Next(now)
+		s.mu.Lock()
+		s.updates.NextWindowStart, s.updates.NextWindowEnd = start, end
+		s.mu.Unlock()
+
+		wait := interval
+		if u.UpgradePending && u.AvailableBundleID != failedID {
+			if window.Contains(now) {
+				if !s.upgrade(ctx, installCtx, u) {
+					failedID = u.AvailableBundleID
+				}
+			} else {
+				logger.Info().Ctx(ctx).Str("bundle_version", u.AvailableVersion).Time("window_start", start).Msg("Newer bundle available, waiting for maintenance window")
+				if d := time.Until(start); d > 0 && d < wait {
+					wait = d
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Info().Msg("Bundle polling stopped")
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// checkForUpdate fetches BUNDLE_URL if it changed, reads the version the database has applied
+// and returns the updated polling state.
+func (s *Service) checkForUpdate(ctx context.Context, etag *string) UpdateCheck {
+	id, newETag, err := s.store.FetchIfModified(ctx, s.cfg.HTTP.BundleURL, *etag)
+	var manifest *setup.Manifest
+	if err == nil {
+		*etag = newETag
+		if id != "" {
+			manifest, err = s.verifiedManifest(id)
+		}
+	}
+
+	s.mu.Lock()
+	if manifest != nil {
+		s.updates.AvailableBundleID, s.updates.AvailableVersion = id, manifest.BundleVersion
+		s.updates.AvailableSchemaVersion, s.updates.availableApp = lastMigrationVersion(manifest), manifest.App
+	}
+	app, available, availableVersion := s.updates.availableApp, s.updates.AvailableSchemaVersion, s.updates.AvailableVersion
+	s.mu.Unlock()
+
+	var installed, installedVersion string
+	if err == nil && app != "" {
+		if installed, err = s.latestAppliedVersion(ctx, app); err != nil {
+			err = fmt.Errorf("failed to read the applied schema version: %w", err)
+		} else if installedVersion, err = s.installedBundleVersion(ctx, app); err != nil {
+			err = fmt.Errorf("failed to read the installed bundle version: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := &s.updates
+	u.LastCheckAt = time.Now()
+	u.LastError = ""
+	if err != nil {
+		u.LastError = err.Error()
+		logger.Warn().Ctx(ctx).Err(err).Str("url", s.cfg.HTTP.BundleURL).Msg("Bundle check failed")
+		return *u
+	}
+	u.InstalledSchemaVersion, u.InstalledVersion = installed, installedVersion
+	u.UpgradePending = upgradePending(available, installed, availableVersion, installedVersion)
+	return *u
+}
+
+// upgradePending reports whether a bundle is newer than what the database has: it ends at a
+// later migration, or it ends at the same one (or has none) and has a newer bundle_version, so
+// bundles that only change seeds, checks or expectations are installed too. An unknown installed
+// bundle version counts as current, and a bundle behind the applied schema is never pending.
+func upgradePending(availableSchema, installedSchema, availableVersion, installedVersion string) bool {
+	if c := compareVersions(availableSchema, installedSchema); availableSchema != "" && c != 0 {
+		return c > 0
+	}
+	return installedVersion != "" && compareVersions(availableVersion, installedVersion) > 0
+}
+
+// installedBundleVersion returns the bundle_version of the bundle last installed for app: the
+// bundle of the app's latest schema snapshot, which every agent sharing the database sees, or,
+// when that bundle is not in this agent's cache, the last bundle of app installed by this agent.
+func (s *Service) installedBundleVersion(ctx context.Context, app string) (string, error) {
+	conn, err := s.repo.DB().Conn(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Close()
+	rec, err := s.repo.LatestSchemaSnapshot(ctx, conn, app)
+	if err != nil {
+		return "", err
+	}
+	if rec != nil && rec.BundleID != "" {
+		if meta, err := s.store.Meta(rec.BundleID); err == nil && meta.BundleVersion != "" {
+			return meta.BundleVersion, nil
+		}
+	}
+	meta, err := s.store.LastInstalled()
+	if err != nil || meta == nil || meta.App != app {
+		return "", err
+	}
+	return meta.BundleVersion, nil
+}
+
+// verifiedManifest verifies cached bundle id and returns its manifest.
+func (s *Service) verifiedManifest(id string) (*setup.Manifest, error) {
+	_, manifest, err := s.loadCachedBundle(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.UpdateManifest(id, manifest); err != nil {
+		logger.Error().Err(err).Str("bundle_id", id).Msg("Failed to update bundle metadata")
+	}
+	return manifest, nil
+}
+
+// lastMigrationVersion is the version of the manifest's last migration, or "" when it has none.
+func lastMigrationVersion(m *setup.Manifest) string {
+	if n := len(m.Migrations); n > 0 {
+		return m.Migrations[n-1].Version
+	}
+	return ""
+}
+
+// upgrade installs the available bundle. It returns false only when the bundle's baseline or
+// migrations failed; any other failure (the database is unreachable, another installation is
+// running or holds the lock past LOCK_TIMEOUT, the download fails) postpones the upgrade to
+// the next check.
+func (s *Service) upgrade(ctx, installCtx context.Context, u UpdateCheck) bool {
+	pingCtx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	err := s.repo.DB().PingContext(pingCtx)
+	cancel()
+	if err != nil {
+		logger.Warn().Err(err).Msg("Database not reachable, postponing upgrade")
+		return true
+	}
+	if !s.TryStart(Actor{User: AutoUpgradeActor}) {
+		logger.Info().Msg("An installation is running, postponing upgrade")
+		return true
+	}
+
+	logger.Info().Str("bundle_id", u.AvailableBundleID).Str("from", u.InstalledVersion).Str("to", u.AvailableVersion).
+		Str("from_schema", u.InstalledSchemaVersion).Str("to_schema", u.AvailableSchemaVersion).Msg("🔄 Upgrading to newer bundle")
+	result := s.RunInstallation(installCtx, InstallOptions{BundleID: u.AvailableBundleID, Actor: Actor{User: AutoUpgradeActor}})
+	if !result.Success {
+		if result.Step == StepApplyBaseline || result.Step == StepApplyMigrations {
+			logger.Error().Str("run_id", result.RunID).Str("step", result.Step).Str("error", result.Error).Msg("❌ Upgrade failed, not retrying this bundle")
+			return false
+		}
+		logger.Warn().Str("run_id", result.RunID).Str("step", result.Step).Str("error", result.Error).Msg("Upgrade did not run, retrying at the next check")
+		return true
+	}
+	s.mu.Lock()
+	s.updates.InstalledSchemaVersion, s.updates.InstalledVersion, s.updates.UpgradePending = result.SchemaVersion, result.BundleVersion, false
+	s.mu.Unlock()
+	logger.Info().Str("run_id", result.RunID).Str("bundle_version", u.AvailableVersion).Msg("✅ Upgrade finished")
+	return true
+}
+
+// compareVersions compares versions segment by segment, numeric runs by value (so
+// 1.10 > 1.9 and 20240110 > 20240109). As in semver, a pre-release (after the first "-")
+// sorts before its release (1.0.0-rc1 < 1.0.0) and build metadata (after "+") is ignored.
+// It returns -1, 0 or 1.
+func compareVersions(a, b string) int {
+	aCore, aPre := splitPrerelease(a)
+	bCore, bPre := splitPrerelease(b)
+	if c := compareSegments(versionSegments(aCore), versionSegments(bCore)); c != 0 {
+		return c
+	}
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		return 1
+	case bPre == "":
+		return -1
+	}
+	return comparePrerelease(aPre, bPre)
+}
+
+// splitPrerelease drops build metadata from v and splits it into its core and pre-release.
+func splitPrerelease(v string) (core, pre string) {
+	v, _, _ = strings.Cut(v, "+")
+	core, pre, _ = strings.Cut(v, "-")
+	return core, pre
+}
+
+func compareSegments(as, bs []string) int {
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, y := as[i], bs[i]
+		xn, xerr := strconv.ParseUint(x, 10, 64)
+		yn, yerr := strconv.ParseUint(y, 10, 64)
+		switch {
+		case xerr == nil && yerr == nil:
+			if xn != yn {
+				return cmpOrder(xn < yn)
+			}
+		case x != y:
+			return cmpOrder(x < y)
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// comparePrerelease orders pre-releases by semver precedence: dot-separated identifiers are
+// compared in turn, numeric ones by value and before alphanumeric ones, which compare in ASCII
+// order; a pre-release that is a prefix of the other sorts first.
+func comparePrerelease(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, y := as[i], bs[i]
+		if x == y {
+			continue
+		}
+		xn, xerr := strconv.ParseUint(x, 10, 64)
+		yn, yerr := strconv.ParseUint(y, 10, 64)
+		switch {
+		case xerr == nil && yerr == nil:
+			return cmpOrder(xn < yn)
+		case xerr == nil:
+			return -1
+		case yerr == nil:
+			return 1
+		default:
+			return cmpOrder(x < y)
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// cmpOrder returns -1 when less is set and 1 otherwise.
+func cmpOrder(less bool) int {
+	if less {
+		return -1
+	}
+	return 1
+}
+
+// versionSegments splits v into runs of digits and runs of letters; other characters separate.
+func versionSegments(v string) []string {
+	var segs []string
+	cur := []rune{}
+	flush := func() {
+		if len(cur) > 0 {
+			segs = append(segs, string(cur))
+			cur = cur[:0]
+		}
+	}
+	for _, r := range v {
+		switch {
+		case unicode.IsDigit(r):
+			if len(cur) > 0 && !unicode.IsDigit(cur[0]) {
+				flush()
+			}
+			cur = append(cur, r)
+		case unicode.IsLetter(r):
+			if len(cur) > 0 && unicode.IsDigit(cur[0]) {
+				flush()
+			}
+			cur = append(cur, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return segs
+}
@@ -0,0 +1,71 @@
+package service
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0.1", "1.0.0", 1},
+		{"1.9", "1.10", -1},
+		{"1.10.0", "1.9.9", 1},
+		{"20240110", "20240109", 1},
+		{"0002", "0010", -1},
+		{"1.0", "1.0.0", -1},
+		{"v2", "v10", -1},
+		{"1.0.0-rc1", "1.0.0", -1},
+		{"1.0.0", "1.0.0-rc1", 1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-beta.11", "1.0.0-rc.1", -1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-rc1", "0.9.0", 1},
+		{"1.0.0+build.5", "1.0.0+build.7", 0},
+		{"1.0.0-rc1+build", "1.0.0", -1},
+		{"", "0001", -1},
+		{"", "", 0},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestUpgradePending(t *testing.T) {
+	tests := []struct {
+		name                               string
+		availableSchema, installedSchema   string
+		availableVersion, installedVersion string
+		want                               bool
+	}{
+		{"fresh database", "0003", "", "1.0.0", "", true},
+		{"newer migration", "0004", "0003", "1.1.0", "1.0.0", true},
+		{"newer migration, unknown installed bundle", "0004", "0003", "1.1.0", "", true},
+		{"newer migration, multi-digit", "0010", "9", "1.1.0", "1.0.0", true},
+		{"current", "0003", "0003", "1.0.0", "1.0.0", false},
+		{"only bundle version changed", "0003", "0003", "1.0.1", "1.0.0", true},
+		{"only bundle version changed, multi-digit", "0003", "0003", "1.10.0", "1.9.0", true},
+		{"release after its pre-release", "0003", "0003", "1.0.0", "1.0.0-rc1", true},
+		{"pre-release of the installed release", "0003", "0003", "1.0.0-rc1", "1.0.0", false},
+		{"older bundle version", "0003", "0003", "0.9.0", "1.0.0", false},
+		{"same migrations, unknown installed bundle", "0003", "0003", "1.0.1", "", false},
+		{"bundle without migrations, newer version", "", "", "1.0.1", "1.0.0", true},
+		{"schema behind, newer bundle version", "0002", "0003", "2.0.0", "1.0.0", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := upgradePending(tt.availableSchema, tt.installedSchema, tt.availableVersion, tt.installedVersion)
+			if got != tt.want {
+				t.Errorf("upgradePending = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
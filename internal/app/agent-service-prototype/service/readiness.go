@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"agent-service-prototype/internal/app/agent-service-prototype/repository"
+	"agent-service-prototype/pkg/metrics"
+)
+
+// readinessTimeout bounds the database checks of one readiness probe.
+const readinessTimeout = 5 * time.Second
+
+// Readiness check names and outcomes.
+const (
+	CheckDatabase     = "database"
+	CheckSchema       = "schema"
+	CheckInstallation = "installation"
+
+	CheckOK   = "ok"
+	CheckFail = "fail"
+)
+
+// ReadinessCheck is the outcome of one readiness check.
+type ReadinessCheck struct {
+	Name   string
+	Status string
+	Detail string
+	// Version and Required are set by the schema check.
+	Version  string
+	Required string
+	// RunID is set by the installation check while an installation is running.
+	RunID string
+}
+
+// Readiness reports whether the agent should receive traffic: every check must pass.
+type Readiness struct {
+	Ready  bool
+	Checks []ReadinessCheck
+}
+
+// Readiness checks that the primary database is reachable, that REQUIRED_SCHEMA_APP has
+// applied at least REQUIRED_SCHEMA_VERSION (when set), and that no installation is running.
+// With AUTO_INSTALL the last installation must also have succeeded.
+func (s *Service) Readiness(ctx context.Context) *Readiness {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	r := &Readiness{Ready: true}
+	add := func(c ReadinessCheck) {
+		if c.Status != CheckOK {
+			r.Ready = false
+		}
+		r.Checks = append(r.Checks, c)
+	}
+
+	dbCheck := ReadinessCheck{Name: CheckDatabase, Status: CheckOK}
+	if err := s.repo.DB().PingContext(ctx); err != nil {
+		dbCheck.Status, dbCheck.Detail = CheckFail, err.Error()
+	}
+	add(dbCheck)
+
+	if required := s.cfg.HTTP.RequiredSchemaVersion; required != "" {
+		c := ReadinessCheck{Name: CheckSchema, Status: CheckFail, Required: required}
+		if dbCheck.Status != CheckOK {
+			c.Detail = "database unreachable"
+		} else if version, err := s.latestAppliedVersion(ctx, s.cfg.HTTP.RequiredSchemaApp); err != nil {
+			c.Detail = err.Error()
+		} else {
+			if version != "" {
+				metrics.SetSchemaVersion("primary", s.cfg.HTTP.RequiredSchemaApp, version)
+			}
+			c = schemaCheck(s.cfg.HTTP.RequiredSchemaApp, version, required)
+		}
+		add(c)
+	}
+
+	c := ReadinessCheck{Name: CheckInstallation, Status: CheckOK}
+	cur := s.GetStatus()
+	switch {
+	case cur != nil && cur.Status == StatusRunning:
+		c.Status, c.RunID = CheckFail, cur.RunID
+		c.Detail = fmt.Sprintf("installation running (step %s)", cur.Step)
+	case !s.cfg.HTTP.AutoInstall:
+	case cur == nil:
+		c.Status, c.Detail = CheckFail, "waiting for the AUTO_INSTALL startup installation"
+	case cur.Status == StatusFailed:
+		c.Status, c.RunID = CheckFail, cur.RunID
+		c.Detail = fmt.Sprintf("installation failed at %s: %s", cur.Step, cur.Error)
+	}
+	add(c)
+	return r
+}
+
+// schemaCheck is the schema check for app at version (its latest applied migration, or "")
+// against the required version.
+func schemaCheck(app, version, required string) ReadinessCheck {
+	c := ReadinessCheck{Name: CheckSchema, Status: CheckFail, Version: version, Required: required}
+	switch {
+	case version == "":
+		c.Detail = fmt.Sprintf("no migration of %s applied", app)
+	case compareVersions(version, required) < 0:
+		c.Detail = fmt.Sprintf("schema at %s, behind required %s", version, required)
+	default:
+		c.Status = CheckOK
+	}
+	return c
+}
+
+// latestAppliedVersion returns the highest migration version app has applied successfully,
+// or "" when it has none. The baseline row is not a migration version.
+func (s *Service) latestAppliedVersion(ctx context.Context, app string) (string, error) {
+	conn, err := s.repo.DB().Conn(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Close()
+	return s.latestAppliedVersionOn(ctx, conn, app)
+}
+
+// latestAppliedVersionOn is latestAppliedVersion on conn.
+func (s *Service) latestAppliedVersionOn(ctx context.Context, conn *sql.Conn, app string) (string, error) {
+	records, err := s.repo.ListMigrationRecords(ctx, conn, app)
+	if err != nil {
+		return "", fmt.Errorf("failed to read migration history: %w", err)
+	}
+	return latestSuccessfulVersion(records), nil
+}
+
+// latestSuccessfulVersion returns the highest version, in compareVersions order, of the
+// successful migration records other than the baseline row.
+func latestSuccessfulVersion(records []repository.MigrationRecord) string {
+	var latest string
+	for _, rec := range records {
+		if !rec.Success || rec.Version == baselineRecordVersion {
+			continue
+		}
+		if latest == "" || compareVersions(rec.Version, latest) > 0 {
+			latest = rec.Version
+		}
+	}
+	return latest
+}
+
+// RefreshSchemaMetrics sets the schema_version_info metric of every app recorded in the primary
+// database's migration history, so it is reported from startup rather than from the first
+// installation.
+func (s *Service) RefreshSchemaMetrics(ctx context.Context) error {
+	conn, err := s.repo.DB().Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	apps, err := s.repo.ListMigrationApps(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("failed to read migration history: %w", err)
+	}
+	for _, app := range apps {
+		version, err := s.latestAppliedVersionOn(ctx, conn, app)
+		if err != nil {
+			return err
+		}
+		if version != "" {
+			metrics.SetSchemaVersion(installTarget{}.label(), app, version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"testing"
+
+	"agent-service-prototype/internal/app/agent-service-prototype/repository"
+)
+
+func TestSchemaCheck(t *testing.T) {
+	tests := []struct {
+		version, required string
+		want              string
+	}{
+		{"", "0001", CheckFail},
+		{"0001", "0001", CheckOK},
+		{"0010", "9", CheckOK},
+		{"9", "0010", CheckFail},
+		{"1.10.0", "1.9.0", CheckOK},
+		{"1.9.0", "1.10.0", CheckFail},
+		{"2026.02.20.010", "2026.02.20.009", CheckOK},
+		{"1.0.0-rc1", "1.0.0", CheckFail},
+		{"1.0.0", "1.0.0-rc1", CheckOK},
+		{"1.0.0-rc.2", "1.0.0-rc.10", CheckFail},
+		{"1.0.0+build.1", "1.0.0", CheckOK},
+	}
+	for _, tt := range tests {
+		c := schemaCheck("hris", tt.version, tt.required)
+		if c.Status != tt.want {
+			t.Errorf("schemaCheck(%q, required %q) = %s (%s), want %s", tt.version, tt.required, c.Status, c.Detail, tt.want)
+		}
+		if c.Version != tt.version || c.Required != tt.required || c.Name != CheckSchema {
+			t.Errorf("schemaCheck(%q, required %q) = %+v", tt.version, tt.required, c)
+		}
+	}
+}
+
+func TestLatestSuccessfulVersion(t *testing.T) {
+	rec := func(version string, success bool) repository.MigrationRecord {
+		return repository.MigrationRecord{Version: version, Success: success}
+	}
+	tests := []struct {
+		name    string
+		records []repository.MigrationRecord
+		want    string
+	}{
+		{"none", nil, ""},
+		{"baseline only", []repository.MigrationRecord{rec(baselineRecordVersion, true)}, ""},
+		{"multi-digit", []repository.MigrationRecord{rec("9", true), rec("0010", true), rec("0002", true)}, "0010"},
+		{"dotted", []repository.MigrationRecord{rec("1.9.0", true), rec("1.10.0", true)}, "1.10.0"},
+		{"pre-release before release", []repository.MigrationRecord{rec("1.0.0", true), rec("1.0.0-rc1", true)}, "1.0.0"},
+		{"failed rows ignored", []repository.MigrationRecord{rec("0001", true), rec("0002", false)}, "0001"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := latestSuccessfulVersion(tt.records); got != tt.want {
+				t.Errorf("latestSuccessfulVersion = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,17 +2,24 @@ package service
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"agent-service-prototype/internal/app/agent-service-prototype/repository"
 	"agent-service-prototype/internal/config"
 	"agent-service-prototype/pkg/logger"
+	"agent-service-prototype/pkg/metrics"
 	"agent-service-prototype/pkg/setup"
+	"agent-service-prototype/pkg/tracing"
+	"agent-service-prototype/pkg/utils"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
@@ -23,8 +30,10 @@ const (
 	StepConnectDB       = "CONNECT_DB"
 	StepLockDB          = "LOCK_DB"
 	StepApplyBaseline   = "APPLY_BASELINE"
+	StepBackupTables    = "BACKUP_TABLES"
 	StepApplyMigrations = "APPLY_MIGRATIONS"
 	StepPostCheck       = "POST_CHECK"
+	StepApplyTargets    = "APPLY_TARGETS"
 )
 
 const (
@@ -34,49 +43,104 @@ const (
 )
 
 type InstallationResult struct {
+	RunID         string
 	Success       bool
 	Step          string
 	Error         string
 	SchemaVersion string
+	BundleID      string
+	BundleVersion string
 	Duration      time.Duration
+	// TraceID is the OpenTelemetry trace the installation was recorded under.
+	TraceID string
+	// LockTimeout is set when the advisory lock was not acquired within LOCK_TIMEOUT.
+	LockTimeout bool
+	// UpToDate is set when SkipIfCurrent found every migration of the bundle applied.
+	UpToDate bool
 }
 
 type RunStatus struct {
-	Status     string
-	Step       string
-	Error      string
+	RunID         string
+	Status        string
+	Step          string
+	Error         string
+	BundleID      string
+	BundleVersion string
+	// LockHolder is set while the installation waits for the advisory lock.
+	LockHolder *setup.LockHolder
+	// Targets maps each target of a multi-target run to its current step,
+	// or to success/failed once it has finished.
+	Targets map[string]string
+	// Actor started the run.
+	Actor      Actor
 	StartedAt  time.Time
 	FinishedAt time.Time
 }
 
+// InstallOptions selects the bundle to install. At most one of BundleID and BundleURL
+// is set; when both are empty the configured BUNDLE_URL is used.
+type InstallOptions struct {
+	BundleID  string
+	BundleURL string
+	// Backup copies the tables touched by pending migrations to WORK_DIR/backups/<run-id>
+	// before they run. BACKUP_BEFORE_MIGRATE=true enables it for every installation.
+	Backup bool
+	// Force re-applies migrations that already succeeded, like FORCE=true does for every
+	// installation.
+	Force bool
+	// Actor is recorded in the audit trail.
+	Actor Actor
+	// SkipIfCurrent succeeds without applying anything, even with Force, when the app has
+	// already applied every migration of the bundle; checked once the advisory lock is held.
+	SkipIfCurrent bool
+}
+
 type Service struct {
 	cfg    *config.Config
 	repo   *repository.Repository
+	store  *setup.BundleStore
 	mu     sync.Mutex
 	status *RunStatus
+	// updates is the bundle polling state (see PollBundles), guarded by mu.
+	updates UpdateCheck
+	// authTablesReady is set once the users and sessions tables exist (see authConn).
+	authTablesReady atomic.Bool
+	// anchorMu serialises updates of the audit anchors in WORK_DIR/audit.
+	anchorMu sync.Mutex
+	// installed is set once an installation has succeeded (see Installed).
+	installed atomic.Bool
 }
 
 func NewService(cfg *config.Config, repo *repository.Repository) *Service {
 	return &Service{
-		cfg:  cfg,
-		repo: repo,
+		cfg:   cfg,
+		repo:  repo,
+		store: setup.NewBundleStore(filepath.Join(cfg.HTTP.WorkDir, "bundles")),
 	}
 }
 
-func (s *Service) TryStart() bool {
+// TryStart marks an installation by actor as running, unless one already is.
+func (s *Service) TryStart(actor Actor) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.status != nil && s.status.Status == StatusRunning {
 		return false
 	}
 	s.status = &RunStatus{
+		RunID:     newRunID(),
 		Status:    StatusRunning,
 		Step:      "INITIALIZING",
+		Actor:     actor,
 		StartedAt: time.Now(),
 	}
 	return true
 }
 
+// newRunID returns a sortable, unique ID for one installation run.
+func newRunID() string {
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + utils.GenerateRandomID(4)
+}
+
 func (s *Service) GetStatus() *RunStatus {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -84,29 +148,70 @@ func (s *Service) GetStatus() *RunStatus {
 		return nil
 	}
 	cp := *s.status
+	if s.status.Targets != nil {
+		cp.Targets = make(map[string]string, len(s.status.Targets))
+		for k, v := range s.status.Targets {
+			cp.Targets[k] = v
+		}
+	}
 	return &cp
 }
 
-func (s *Service) RunInstallation(ctx context.Context) *InstallationResult {
+func (s *Service) RunInstallation(ctx context.Context, opts InstallOptions) *InstallationResult {
 	start := time.Now()
-	result := s.doInstallation(ctx)
+	runID := newRunID()
+	if cur := s.GetStatus(); cur != nil && cur.Status == StatusRunning && cur.RunID != "" {
+		runID = cur.RunID
+	}
+	ctx = logger.WithFields(ctx, "run_id", runID)
+	ctx, span := tracing.Start(ctx, "installation", attribute.String("run_id", runID))
+	result := s.doInstallation(ctx, runID, opts)
+	if !result.Success {
+		tracing.Fail(span, result.Error)
+	}
+	span.End()
+	result.TraceID = tracing.TraceID(ctx)
+	metrics.InstallationFinished("single", result.Success)
 	now := time.Now()
+	result.RunID = runID
 	result.Duration = now.Sub(start)
 
+	if result.BundleID == "" {
+		if cur := s.GetStatus(); cur != nil {
+			result.BundleID = cur.BundleID
+			result.BundleVersion = cur.BundleVersion
+		}
+	}
+	if result.Success {
+		s.installed.Store(true)
+		if err := s.store.MarkInstalled(result.BundleID, now); err != nil {
+			logger.Error().Ctx(ctx).Err(err).Str("bundle_id", result.BundleID).Msg("Failed to record bundle installation")
+		}
+		s.pruneBundles(result.BundleID)
+	}
+
 	s.mu.Lock()
 	if result.Success {
 		s.status = &RunStatus{
-			Status:     StatusSuccess,
-			StartedAt:  start,
-			FinishedAt: now,
+			RunID:         runID,
+			Status:        StatusSuccess,
+			BundleID:      result.BundleID,
+			BundleVersion: result.BundleVersion,
+			Actor:         opts.Actor,
+			StartedAt:     start,
+			FinishedAt:    now,
 		}
 	} else {
 		s.status = &RunStatus{
-			Status:     StatusFailed,
-			Step:       result.Step,
-			Error:      result.Error,
-			StartedAt:  start,
-			FinishedAt: now,
+			RunID:         runID,
+			Status:        StatusFailed,
+			Step:          result.Step,
+			Error:         result.Error,
+			BundleID:      result.BundleID,
+			BundleVersion: result.BundleVersion,
+			Actor:         opts.Actor,
+			StartedAt:     start,
+			FinishedAt:    now,
 		}
 	}
 	s.mu.Unlock()
@@ -114,63 +219,133 @@ func (s *Service) RunInstallation(ctx context.Context) *InstallationResult {
 	return result
 }
 
-func (s *Service) doInstallation(ctx context.Context) *InstallationResult {
-	db := s.repo.DB()
-	dbURL := s.cfg.DatabaseURL()
-	bundleURL := s.cfg.HTTP.BundleURL
-	if dbURL == "" || bundleURL == "" {
-		return &InstallationResult{Step: StepConnectDB, Error: "DB_URL or BUNDLE_URL is not configured"}
+func (s *Service) doInstallation(ctx context.Context, runID string, opts InstallOptions) *InstallationResult {
+	if s.cfg.DatabaseURL() == "" {
+		return &InstallationResult{Step: StepConnectDB, Error: "DB_URL is not configured"}
+	}
+	b, res := s.prepareBundle(ctx, opts, s.updateStep)
+	if res != nil {
+		return res
 	}
+	return s.installBundle(ctx, runID, b, opts, installTarget{
+		DSN:        s.cfg.DatabaseURL(),
+		DB:         s.repo.DB(),
+		onStep:     s.updateStep,
+		onLockWait: s.updateLockHolder,
+	})
+}
+
+// preparedBundle is a cached, extracted and checksum-verified bundle ready to be applied.
+type preparedBundle struct {
+	ID       string
+	BaseDir  string
+	Manifest *setup.Manifest
+}
+
+// installTarget is one database a prepared bundle is applied to.
+// Name is empty for the primary database from DBConfig.
+type installTarget struct {
+	Name   string
+	DSN    string
+	DB     *sql.DB
+	onStep func(step string)
+	// onLockWait reports the advisory lock holder while waiting; may be nil.
+	onLockWait func(holder *setup.LockHolder)
+}
 
-	workDir := s.cfg.HTTP.WorkDir
-	force, _ := strconv.ParseBool(s.cfg.HTTP.Force)
-	skipSmoke, _ := strconv.ParseBool(s.cfg.HTTP.SkipSmoke)
-	advisoryKey, _ := strconv.ParseInt(s.cfg.HTTP.AdvisoryLockKey, 10, 64)
-	if advisoryKey == 0 {
-		advisoryKey = 987654321
+// label names t in metrics; the primary database has no name.
+func (t installTarget) label() string {
+	if t.Name == "" {
+		return "primary"
 	}
+	return t.Name
+}
 
-	if err := os.MkdirAll(workDir, 0755); err != nil {
-		return &InstallationResult{Step: StepDownloadBundle, Error: fmt.Sprintf("failed to create work dir: %v", err)}
+// prepareBundle fetches (or reuses) the bundle selected by opts, extracts it and verifies
+// its checksums. On failure the returned result describes the failed step.
+func (s *Service) prepareBundle(ctx context.Context, opts InstallOptions, onStep func(string)) (b *preparedBundle, res *InstallationResult) {
+	steps := newStepTracker(ctx, onStep)
+	defer func() { steps.end(res) }()
+
+	bundleURL := opts.BundleURL
+	if bundleURL == "" {
+		bundleURL = s.cfg.HTTP.BundleURL
+	}
+	if bundleURL == "" && opts.BundleID == "" {
+		return nil, &InstallationResult{Step: StepDownloadBundle, Error: "BUNDLE_URL is not configured"}
 	}
 
-	s.updateStep(StepDownloadBundle)
-	bundlePath := filepath.Join(workDir, "db-bundle.zip")
-	if err := setup.DownloadBundle(ctx, bundleURL, bundlePath); err != nil {
-		return &InstallationResult{Step: StepDownloadBundle, Error: err.Error()}
+	if err := os.MkdirAll(s.cfg.HTTP.WorkDir, 0755); err != nil {
+		return nil, &InstallationResult{Step: StepDownloadBundle, Error: fmt.Sprintf("failed to create work dir: %v", err)}
 	}
 
-	s.updateStep(StepExtractBundle)
-	extractDir := filepath.Join(workDir, "bundle")
+	ctx = steps.begin(StepDownloadBundle)
+	bundleID := opts.BundleID
+	if bundleID != "" {
+		if _, err := s.store.Path(bundleID); err != nil {
+			return nil, &InstallationResult{Step: StepDownloadBundle, Error: err.Error()}
+		}
+		logger.Info().Ctx(ctx).Str("bundle_id", bundleID).Msg("Using cached bundle")
+	} else {
+		id, err := s.store.Fetch(ctx, bundleURL)
+		if err != nil {
+			return nil, &InstallationResult{Step: StepDownloadBundle, Error: err.Error()}
+		}
+		bundleID = id
+		logger.Info().Ctx(ctx).Str("bundle_id", bundleID).Msg("Bundle cached")
+	}
+	bundlePath, err := s.store.Path(bundleID)
+	if err != nil {
+		return nil, &InstallationResult{Step: StepDownloadBundle, Error: err.Error()}
+	}
+
+	ctx = steps.begin(StepExtractBundle)
+	extractDir := s.store.ExtractDir(bundleID)
 	if err := os.RemoveAll(extractDir); err != nil {
-		return &InstallationResult{Step: StepExtractBundle, Error: fmt.Sprintf("failed to clean extract dir: %v", err)}
+		return nil, &InstallationResult{Step: StepExtractBundle, Error: fmt.Sprintf("failed to clean extract dir: %v", err)}
 	}
 	if err := setup.ExtractZip(bundlePath, extractDir); err != nil {
-		return &InstallationResult{Step: StepExtractBundle, Error: err.Error()}
+		return nil, &InstallationResult{Step: StepExtractBundle, Error: err.Error()}
 	}
 
 	baseDir, err := setup.ResolveBaseDir(extractDir)
 	if err != nil {
-		return &InstallationResult{Step: StepParseManifest, Error: err.Error()}
+		return nil, &InstallationResult{Step: StepParseManifest, Error: err.Error()}
 	}
 
-	s.updateStep(StepVerifyChecksum)
+	ctx = steps.begin(StepVerifyChecksum)
 	checksums, err := setup.LoadChecksums(baseDir)
 	if err != nil {
-		return &InstallationResult{Step: StepVerifyChecksum, Error: err.Error()}
+		return nil, &InstallationResult{Step: StepVerifyChecksum, Error: err.Error()}
 	}
 	if err := setup.VerifyChecksums(baseDir, checksums); err != nil {
-		return &InstallationResult{Step: StepVerifyChecksum, Error: err.Error()}
+		return nil, &InstallationResult{Step: StepVerifyChecksum, Error: err.Error()}
 	}
 
-	s.updateStep(StepParseManifest)
+	ctx = steps.begin(StepParseManifest)
 	manifest, err := setup.LoadManifest(baseDir)
 	if err != nil {
-		return &InstallationResult{Step: StepParseManifest, Error: err.Error()}
+		return nil, &InstallationResult{Step: StepParseManifest, Error: err.Error()}
 	}
+	if err := s.store.UpdateManifest(bundleID, manifest); err != nil {
+		logger.Error().Ctx(ctx).Err(err).Str("bundle_id", bundleID).Msg("Failed to update bundle metadata")
+	}
+	s.updateBundle(bundleID, manifest.BundleVersion)
+
+	return &preparedBundle{ID: bundleID, BaseDir: baseDir, Manifest: manifest}, nil
+}
 
-	s.updateStep(StepConnectDB)
-	conn, err := db.Conn(ctx)
+// installBundle applies a prepared bundle to one target while holding that target's advisory lock.
+func (s *Service) installBundle(ctx context.Context, runID string, b *preparedBundle, opts InstallOptions, t installTarget) (result *InstallationResult) {
+	force := s.cfg.HTTP.Force || opts.Force
+	skipSmoke := s.cfg.HTTP.SkipSmoke
+	backupBeforeMigrate := s.cfg.HTTP.BackupBeforeMigrate
+	bundleID, baseDir, manifest := b.ID, b.BaseDir, b.Manifest
+	steps := newStepTracker(ctx, t.onStep)
+	defer func() { steps.end(result) }()
+
+	ctx = steps.begin(StepConnectDB)
+	conn, err := t.DB.Conn(ctx)
 	if err != nil {
 		return &InstallationResult{Step: StepConnectDB, Error: fmt.Sprintf("failed to acquire connection: %v", err)}
 	}
@@ -180,115 +355,362 @@ func (s *Service) doInstallation(ctx context.Context) *InstallationResult {
 		return &InstallationResult{Step: StepConnectDB, Error: fmt.Sprintf("failed to ping database: %v", err)}
 	}
 
-	s.updateStep(StepLockDB)
-	if _, err := conn.ExecContext(ctx, fmt.Sprintf("SELECT pg_advisory_lock(%d)", advisoryKey)); err != nil {
-		return &InstallationResult{Step: StepLockDB, Error: fmt.Sprintf("failed to acquire advisory lock: %v", err)}
+	sc := auditScope{
+		Actor:         opts.Actor,
+		RunID:         runID,
+		Target:        t.Name,
+		App:           manifest.App,
+		BundleID:      bundleID,
+		BundleVersion: manifest.BundleVersion,
 	}
+	start := time.Now()
 	defer func() {
-		if _, err := conn.ExecContext(context.Background(), fmt.Sprintf("SELECT pg_advisory_unlock(%d)", advisoryKey)); err != nil {
-			logger.Error().Err(err).Msg("Failed to release advisory lock")
+		payload := map[string]any{"duration_ms": time.Since(start).Milliseconds(), "force": force}
+		action := AuditActionInstall
+		if result.Success {
+			payload["schema_version"] = result.SchemaVersion
+			if result.UpToDate {
+				payload["up_to_date"] = true
+			}
 		} else {
-			logger.Info().Msg("Advisory lock released")
+			action = AuditActionInstallFailed
+			payload["step"] = result.Step
+			payload["error"] = result.Error
 		}
+		s.audit(context.WithoutCancel(ctx), conn, sc, AuditEntityInstallation, runID, action, payload)
 	}()
-	logger.Info().Int64("key", advisoryKey).Msg("Advisory lock acquired")
 
-	s.updateStep(StepApplyBaseline)
-	fresh, err := s.repo.IsFreshDB(ctx, conn)
+	ctx = steps.begin(StepLockDB)
+	unlock, err := s.lockDB(ctx, conn, t.onLockWait)
 	if err != nil {
-		return &InstallationResult{Step: StepApplyBaseline, Error: fmt.Sprintf("failed to detect DB state: %v", err)}
+		return &InstallationResult{Step: StepLockDB, Error: err.Error(), LockTimeout: errors.Is(err, ErrLockTimeout)}
 	}
+	defer unlock()
 
-	if fresh {
-		logger.Info().Msg("Fresh database detected, applying baseline...")
-		baselineSQL, err := os.ReadFile(filepath.Join(baseDir, string(manifest.Baseline)))
+	if opts.SkipIfCurrent && !manifest.IsTenantScoped() {
+		current, err := s.bundleApplied(ctx, conn, manifest)
 		if err != nil {
-			return &InstallationResult{Step: StepApplyBaseline, Error: fmt.Sprintf("failed to read baseline: %v", err)}
+			return &InstallationResult{Step: StepLockDB, Error: fmt.Sprintf("failed to read migration history: %v", err)}
+		}
+		if current {
+			logger.Info().Ctx(ctx).Str("app", manifest.App).Str("bundle_version", manifest.BundleVersion).Msg("Bundle already applied, nothing to install")
+			return &InstallationResult{Success: true, UpToDate: true, SchemaVersion: lastMigrationVersion(manifest), BundleID: bundleID, BundleVersion: manifest.BundleVersion}
 		}
-		if _, err := conn.ExecContext(ctx, string(baselineSQL)); err != nil {
-			return &InstallationResult{Step: StepApplyBaseline, Error: fmt.Sprintf("failed to apply baseline: %v", err)}
+	}
+
+	var lastVersion string
+	if manifest.IsTenantScoped() {
+		if opts.Backup || backupBeforeMigrate {
+			logger.Warn().Ctx(ctx).Msg("Pre-migration backup is not supported for tenant-scoped bundles, skipping")
+		}
+		version, res := s.installTenants(ctx, conn, b, sc, force, skipSmoke, steps)
+		if res != nil {
+			return res
+		}
+		lastVersion = version
+	} else {
+		schema := manifest.DB.DefaultSchema
+
+		ctx = steps.begin(StepApplyBaseline)
+		if n, err := s.repo.ClaimLegacyMigrations(ctx, conn, manifest.App, bundleVersions(manifest)); err != nil {
+			return &InstallationResult{Step: StepApplyBaseline, Error: fmt.Sprintf("failed to upgrade migrations table: %v", err)}
+		} else if n > 0 {
+			logger.Info().Ctx(ctx).Str("app", manifest.App).Int64("rows", n).Msg("Assigned existing migration records to app")
+		}
+		fresh, err := s.repo.IsFreshDB(ctx, conn, manifest.App)
+		if err != nil {
+			return &InstallationResult{Step: StepApplyBaseline, Error: fmt.Sprintf("failed to detect DB state: %v", err)}
+		}
+
+		if fresh {
+			logger.Info().Ctx(ctx).Str("app", manifest.App).Msg("Fresh database detected, applying baseline...")
+			if res := s.applyBaseline(ctx, conn, b, schema); res != nil {
+				return res
+			}
+			logger.Info().Ctx(ctx).Msg("Baseline applied successfully")
 		}
 		if err := s.repo.EnsureMigrationsTable(ctx, conn); err != nil {
 			return &InstallationResult{Step: StepApplyBaseline, Error: fmt.Sprintf("failed to ensure migrations table: %v", err)}
 		}
-		logger.Info().Msg("Baseline applied successfully")
+		if fresh {
+			if err := s.recordBaseline(ctx, conn, b); err != nil {
+				return &InstallationResult{Step: StepApplyBaseline, Error: fmt.Sprintf("failed to record baseline: %v", err)}
+			}
+		}
+
+		if (opts.Backup || backupBeforeMigrate) && !fresh {
+			ctx = steps.begin(StepBackupTables)
+			if err := s.backupPendingTables(ctx, conn, t, runID, bundleID, baseDir, manifest, force); err != nil {
+				return &InstallationResult{Step: StepBackupTables, Error: fmt.Sprintf("backup failed: %v", err)}
+			}
+		}
+
+		ctx = steps.begin(StepApplyMigrations)
+		version, res := s.applyMigrations(ctx, conn, b, sc, schema, force)
+		if res != nil {
+			return res
+		}
+		lastVersion = version
+
+		if !skipSmoke && manifest.Checks.Smoke != "" {
+			ctx = steps.begin(StepPostCheck)
+			if res := s.runSmoke(ctx, conn, b, schema); res != nil {
+				return res
+			}
+		}
 	}
 
-	s.updateStep(StepApplyMigrations)
-	var lastVersion string
+	snap, err := s.repo.CaptureSchemaSnapshot(ctx, conn)
+	if err != nil {
+		logger.Error().Ctx(ctx).Err(err).Msg("Failed to capture schema snapshot")
+	} else if err := s.repo.SaveSchemaSnapshot(ctx, conn, manifest.App, bundleID, lastVersion, snap); err != nil {
+		logger.Error().Ctx(ctx).Err(err).Msg("Failed to save schema snapshot")
+	} else {
+		logger.Info().Ctx(ctx).Int("objects", len(snap.Objects)).Msg("Schema snapshot saved")
+	}
+
+	if lastVersion != "" {
+		metrics.SetSchemaVersion(t.label(), manifest.App, lastVersion)
+	}
+	return &InstallationResult{Success: true, SchemaVersion: lastVersion, BundleID: bundleID, BundleVersion: manifest.BundleVersion}
+}
+
+// bundleApplied reports whether the manifest's app has successfully applied the baseline and
+// every migration of the bundle.
+func (s *Service) bundleApplied(ctx context.Context, conn *sql.Conn, manifest *setup.Manifest) (bool, error) {
+	records, err := s.repo.ListMigrationRecords(ctx, conn, manifest.App)
+	if err != nil {
+		return false, err
+	}
+	applied := make(map[string]bool, len(records))
+	for _, rec := range records {
+		applied[rec.Version] = rec.Success
+	}
+	if len(manifest.Migrations) == 0 {
+		return applied[baselineRecordVersion], nil
+	}
 	for _, mig := range manifest.Migrations {
-		applied, err := s.repo.GetMigrationRecord(ctx, conn, mig.Version)
+		if !applied[mig.Version] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Installed reports whether an installation has succeeded since the agent started.
+func (s *Service) Installed() bool {
+	return s.installed.Load()
+}
+
+// applyBaseline runs the bundle baseline with {{schema}} rendered as schema.
+func (s *Service) applyBaseline(ctx context.Context, conn *sql.Conn, b *preparedBundle, schema string) *InstallationResult {
+	baselineSQL, err := os.ReadFile(filepath.Join(b.BaseDir, b.Manifest.Baseline.File))
+	if err != nil {
+		return &InstallationResult{Step: StepApplyBaseline, Error: fmt.Sprintf("failed to read baseline: %v", err)}
+	}
+	if _, err := conn.ExecContext(ctx, setup.RenderSQL(string(baselineSQL), schema)); err != nil {
+		return &InstallationResult{Step: StepApplyBaseline, Error: fmt.Sprintf("failed to apply baseline: %v", err)}
+	}
+	return nil
+}
+
+// baselineRecordVersion is the migration history version under which an applied baseline
+// is recorded, so an app whose bundle has no migrations yet is not taken for fresh again.
+const baselineRecordVersion = "baseline"
+
+// recordBaseline records the bundle baseline as applied for the manifest's app.
+func (s *Service) recordBaseline(ctx context.Context, conn *sql.Conn, b *preparedBundle) error {
+	data, err := os.ReadFile(filepath.Join(b.BaseDir, b.Manifest.Baseline.File))
+	if err != nil {
+		return err
+	}
+	name := b.Manifest.Baseline.Name
+	if name == "" {
+		name = b.Manifest.Baseline.File
+	}
+	return s.repo.RecordMigration(ctx, conn, repository.MigrationRecord{
+		App:               b.Manifest.App,
+		Version:           baselineRecordVersion,
+		Name:              name,
+		Checksum:          setup.SHA256Hex(data),
+		CanonicalChecksum: setup.CanonicalSHA256Hex(data),
+		AppliedAt:         time.Now(),
+		Success:           true,
+	})
+}
+
+// bundleVersions returns the history versions a bundle can own: its migrations and the baseline.
+func bundleVersions(manifest *setup.Manifest) []string {
+	versions := []string{baselineRecordVersion}
+	for _, mig := range manifest.Migrations {
+		versions = append(versions, mig.Version)
+	}
+	return versions
+}
+
+// applyMigrations applies the pending bundle migrations and returns the last applied version.
+// History is keyed by the manifest's app; with sc.Tenant it is read from and written to that
+// tenant's records instead. Checksums are taken over the file as shipped, before rendering.
+// Every apply, skip, forced re-run and failure is written to the audit trail.
+func (s *Service) applyMigrations(ctx context.Context, conn *sql.Conn, b *preparedBundle, sc auditScope, schema string, force bool) (string, *InstallationResult) {
+	app, tenantID := b.Manifest.App, sc.Tenant
+	var lastVersion string
+	for _, mig := range b.Manifest.Migrations {
+		var applied *repository.MigrationRecord
+		var err error
+		if tenantID != "" {
+			applied, err = s.repo.GetTenantMigrationRecord(ctx, conn, tenantID, app, mig.Version)
+		} else {
+			applied, err = s.repo.GetMigrationRecord(ctx, conn, app, mig.Version)
+		}
 		if err != nil {
-			return &InstallationResult{Step: StepApplyMigrations, Error: fmt.Sprintf("failed to check migration %s: %v", mig.Version, err)}
+			return "", &InstallationResult{Step: StepApplyMigrations, Error: fmt.Sprintf("failed to check migration %s: %v", mig.Version, err)}
 		}
 
-		migrationSQL, err := os.ReadFile(filepath.Join(baseDir, mig.File))
+		migrationSQL, err := os.ReadFile(filepath.Join(b.BaseDir, mig.File))
 		if err != nil {
-			return &InstallationResult{Step: StepApplyMigrations, Error: fmt.Sprintf("failed to read migration %s: %v", mig.Version, err)}
+			return "", &InstallationResult{Step: StepApplyMigrations, Error: fmt.Sprintf("failed to read migration %s: %v", mig.Version, err)}
 		}
 		fileChecksum := setup.SHA256Hex(migrationSQL)
+		canonicalChecksum := setup.CanonicalSHA256Hex(migrationSQL)
 
 		if applied != nil {
 			if applied.Success && !force {
-				logger.Info().Str("version", mig.Version).Msg("Migration already applied, skipping")
+				logger.Info().Ctx(ctx).Str("tenant", tenantID).Str("version", mig.Version).Msg("Migration already applied, skipping")
+				// Rows recorded before canonical checksums existed get one while the file
+				// still matches, so later whitespace-only edits can be repaired.
+				if tenantID == "" && applied.CanonicalChecksum == "" && applied.Checksum == fileChecksum {
+					if err := s.repo.UpdateMigrationChecksum(ctx, conn, app, mig.Version, fileChecksum, canonicalChecksum); err != nil {
+						logger.Error().Ctx(ctx).Err(err).Str("version", mig.Version).Msg("Failed to record canonical checksum")
+					}
+				}
+				s.audit(ctx, conn, sc, AuditEntityMigration, mig.Version, AuditActionSkip, map[string]any{
+					"name":     mig.Name,
+					"checksum": fileChecksum,
+				})
 				lastVersion = mig.Version
 				continue
 			}
 			if applied.Checksum != fileChecksum {
-				return &InstallationResult{
-					Step:  StepApplyMigrations,
-					Error: fmt.Sprintf("checksum mismatch for migration %s: recorded=%s, file=%s", mig.Version, applied.Checksum, fileChecksum),
+				msg := fmt.Sprintf("checksum mismatch for migration %s: recorded=%s, file=%s", mig.Version, applied.Checksum, fileChecksum)
+				if applied.CanonicalChecksum == canonicalChecksum {
+					msg += " (whitespace-only change, accept it with POST /setup/repair)"
 				}
+				s.audit(ctx, conn, sc, AuditEntityMigration, mig.Version, AuditActionFail, map[string]any{
+					"name":              mig.Name,
+					"checksum":          fileChecksum,
+					"recorded_checksum": applied.Checksum,
+					"error":             msg,
+				})
+				return "", &InstallationResult{Step: StepApplyMigrations, Error: msg}
 			}
 		}
 
-		logger.Info().Str("version", mig.Version).Str("name", mig.Name).Bool("tx", mig.Transaction).Msg("Applying migration")
+		logger.Info().Ctx(ctx).Str("tenant", tenantID).Str("version", mig.Version).Str("name", mig.Name).Bool("tx", mig.Transaction).Msg("Applying migration")
 
+		query := setup.RenderSQL(string(migrationSQL), schema)
+		migCtx, span := tracing.Start(ctx, "migration "+mig.Version,
+			attribute.String("migration.version", mig.Version),
+			attribute.String("migration.name", mig.Name),
+			attribute.String("tenant", tenantID),
+		)
 		migStart := time.Now()
 		var migErr error
 
 		if mig.Transaction {
-			migErr = s.repo.ExecInTransaction(ctx, conn, string(migrationSQL))
+			migErr = s.repo.ExecInTransaction(migCtx, conn, query)
 		} else {
-			_, migErr = conn.ExecContext(ctx, string(migrationSQL))
+			_, migErr = conn.ExecContext(migCtx, query)
 		}
 		migDuration := time.Since(migStart)
+		tracing.End(span, migErr)
+		metrics.MigrationExecuted(sc.targetLabel(), app, migDuration)
+		// Record the outcome even when ctx was cancelled mid-migration (e.g. on shutdown).
+		recCtx := context.WithoutCancel(ctx)
 
 		rec := repository.MigrationRecord{
-			Version:    mig.Version,
-			Name:       mig.Name,
-			Checksum:   fileChecksum,
-			AppliedAt:  time.Now(),
-			ExecTimeMs: migDuration.Milliseconds(),
-			Success:    migErr == nil,
+			App:               app,
+			Version:           mig.Version,
+			Name:              mig.Name,
+			Checksum:          fileChecksum,
+			CanonicalChecksum: canonicalChecksum,
+			AppliedAt:         time.Now(),
+			ExecTimeMs:        migDuration.Milliseconds(),
+			Success:           migErr == nil,
 		}
 		if migErr != nil {
 			rec.ErrorMsg = migErr.Error()
 		}
-		if rErr := s.repo.RecordMigration(ctx, conn, rec); rErr != nil {
-			logger.Error().Err(rErr).Str("version", mig.Version).Msg("Failed to record migration")
+		var rErr error
+		if tenantID != "" {
+			rErr = s.repo.RecordTenantMigration(recCtx, conn, tenantID, rec)
+		} else {
+			rErr = s.repo.RecordMigration(recCtx, conn, rec)
+		}
+		if rErr != nil {
+			logger.Error().Ctx(ctx).Err(rErr).Str("version", mig.Version).Msg("Failed to record migration")
+		}
+
+		action := AuditActionApply
+		switch {
+		case migErr != nil:
+			action = AuditActionFail
+		case applied != nil && applied.Success:
+			action = AuditActionForceRerun
+		}
+		payload := map[string]any{
+			"name":        mig.Name,
+			"checksum":    fileChecksum,
+			"duration_ms": migDuration.Milliseconds(),
+			"transaction": mig.Transaction,
+		}
+		if migErr != nil {
+			payload["error"] = migErr.Error()
 		}
+		s.audit(recCtx, conn, sc, AuditEntityMigration, mig.Version, action, payload)
 		if migErr != nil {
-			return &InstallationResult{Step: StepApplyMigrations, Error: fmt.Sprintf("migration %s failed: %v", mig.Version, migErr)}
+			return "", &InstallationResult{Step: StepApplyMigrations, Error: fmt.Sprintf("migration %s failed: %v", mig.Version, migErr)}
 		}
 
 		lastVersion = mig.Version
-		logger.Info().Str("version", mig.Version).Int64("ms", migDuration.Milliseconds()).Msg("Migration applied")
+		logger.Info().Ctx(ctx).Str("tenant", tenantID).Str("version", mig.Version).Int64("ms", migDuration.Milliseconds()).Msg("Migration applied")
 	}
+	return lastVersion, nil
+}
 
-	if !skipSmoke && manifest.Checks.Smoke != "" {
-		s.updateStep(StepPostCheck)
-		smokeSQL, err := os.ReadFile(filepath.Join(baseDir, manifest.Checks.Smoke))
-		if err != nil {
-			return &InstallationResult{Step: StepPostCheck, Error: fmt.Sprintf("failed to read smoke check: %v", err)}
-		}
-		if _, err := conn.ExecContext(ctx, string(smokeSQL)); err != nil {
-			return &InstallationResult{Step: StepPostCheck, Error: fmt.Sprintf("smoke check failed: %v", err)}
-		}
-		logger.Info().Msg("Smoke check passed")
+// runSmoke runs the bundle smoke check with {{schema}} rendered as schema.
+func (s *Service) runSmoke(ctx context.Context, conn *sql.Conn, b *preparedBundle, schema string) *InstallationResult {
+	smokeSQL, err := os.ReadFile(filepath.Join(b.BaseDir, b.Manifest.Checks.Smoke))
+	if err != nil {
+		return &InstallationResult{Step: StepPostCheck, Error: fmt.Sprintf("failed to read smoke check: %v", err)}
+	}
+	if _, err := conn.ExecContext(ctx, setup.RenderSQL(string(smokeSQL), schema)); err != nil {
+		return &InstallationResult{Step: StepPostCheck, Error: fmt.Sprintf("smoke check failed: %v", err)}
 	}
+	logger.Info().Ctx(ctx).Msg("Smoke check passed")
+	return nil
+}
+
+// advisoryKey returns ADVISORY_LOCK_KEY.
+func (s *Service) advisoryKey() int64 {
+	return s.cfg.HTTP.AdvisoryLockKey
+}
 
-	return &InstallationResult{Success: true, SchemaVersion: lastVersion}
+func (s *Service) updateBundle(id, version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status != nil {
+		s.status.BundleID = id
+		s.status.BundleVersion = version
+	}
+}
+
+func (s *Service) updateLockHolder(h *setup.LockHolder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status != nil {
+		s.status.LockHolder = h
+	}
 }
 
 func (s *Service) updateStep(step string) {
@@ -0,0 +1,323 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"agent-service-prototype/internal/app/agent-service-prototype/repository"
+	"agent-service-prototype/pkg/logger"
+	"agent-service-prototype/pkg/rbac"
+	"agent-service-prototype/pkg/utils"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrSessionNotFound    = errors.New("session not found or expired")
+	ErrInvalidUsername    = errors.New("username must match ^[A-Za-z0-9][A-Za-z0-9._@-]{0,63}$")
+	ErrWeakPassword       = errors.New("password must be at least 12 characters")
+	ErrUserNotFound       = errors.New("user not found")
+)
+
+// sessionRotationGrace keeps a session ID valid for a short while after it was rotated, so
+// requests already in flight with the old cookie do not fail.
+const sessionRotationGrace = 30 * time.Second
+
+// minPasswordLength is the shortest password SetUserPassword accepts.
+const minPasswordLength = 12
+
+var usernameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,63}$`)
+
+// Session is an authenticated admin session. ID is the cookie value; it is only known to the
+// service right after it was issued (Login, or ResumeSession when Rotated is set).
+type Session struct {
+	ID        string
+	Username  string
+	Role      rbac.Role
+	ExpiresAt time.Time
+	// Rotated is set when ResumeSession replaced the session ID; the new ID must be sent
+	// to the client.
+	Rotated bool
+}
+
+// SessionClient describes the client a session is issued to.
+type SessionClient struct {
+	IP        string
+	UserAgent string
+}
+
+// dummyHash is compared against when the user does not exist, so a login takes as long
+// for unknown users as for wrong passwords.
+var dummyHash = sync.OnceValue(func() []byte {
+	h, _ := bcrypt.GenerateFromPassword([]byte(utils.GenerateRandomID(16)), bcrypt.DefaultCost)
+	return h
+})
+
+// sessionStore is the part of the repository sessions are kept in; tests replace it.
+type sessionStore interface {
+	CreateSession(ctx context.Context, conn *sql.Conn, s repository.SessionRecord) error
+	GetSession(ctx context.Context, conn *sql.Conn, idHash string) (*repository.SessionRecord, error)
+	TouchSession(ctx context.Context, conn *sql.Conn, idHash string, at time.Time) error
+	RotateSession(ctx context.Context, conn *sql.Conn, idHash, successorHash string, t time.Time) (bool, error)
+	DeleteSession(ctx context.Context, conn *sql.Conn, idHash string) error
+}
+
+// hashSessionID is the key a session is stored under.
+func hashSessionID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// authConn acquires a connection and creates the users and sessions tables on first use.
+func (s *Service) authConn(ctx context.Context) (*sql.Conn, error) {
+	conn, err := s.repo.DB().Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	if !s.authTablesReady.Load() {
+		if err := s.repo.EnsureAuthTables(ctx, conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to ensure auth tables: %w", err)
+		}
+		s.authTablesReady.Store(true)
+	}
+	return conn, nil
+}
+
+// Login checks the password of username and starts a new session. Expired sessions are
+// cleaned up on the way.
+func (s *Service) Login(ctx context.Context, username, password string, client SessionClient) (*Session, error) {
+	conn, err := s.authConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	u, err := s.repo.GetUser(ctx, conn, username)
+	if err != nil {
+		return nil, err
+	}
+	hash := dummyHash()
+	if u != nil {
+		hash = []byte(u.PasswordHash)
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || u == nil || u.Disabled {
+		logger.Warn().Ctx(ctx).Str("username", username).Str("remote_ip", client.IP).Msg("Login failed")
+		return nil, ErrInvalidCredentials
+	}
+
+	if n, err := s.repo.DeleteExpiredSessions(ctx, conn, time.Now()); err != nil {
+		logger.Error().Ctx(ctx).Err(err).Msg("Failed to delete expired sessions")
+	} else if n > 0 {
+		logger.Debug().Ctx(ctx).Int64("sessions", n).Msg("Expired sessions deleted")
+	}
+
+	now := time.Now()
+	sess, err := issueSession(ctx, s.repo, conn, utils.GenerateRandomID(32), u.Username, rbac.Role(u.Role), now.Add(s.cfg.HTTP.SessionTTL), client, now)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info().Ctx(ctx).Str("username", u.Username).Str("remote_ip", client.IP).Msg("Login succeeded")
+	return sess, nil
+}
+
+// issueSession stores a new session with the given ID.
+func issueSession(ctx context.Context, store sessionStore, conn *sql.Conn, id, username string, role rbac.Role, expiresAt time.Time, client SessionClient, now time.Time) (*Session, error) {
+	err := store.CreateSession(ctx, conn, repository.SessionRecord{
+		IDHash:     hashSessionID(id),
+		Username:   username,
+		IssuedAt:   now,
+		ExpiresAt:  expiresAt,
+		LastSeenAt: now,
+		ClientIP:   client.IP,
+		UserAgent:  client.UserAgent,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	return &Session{ID: id, Username: username, Role: role, ExpiresAt: expiresAt}, nil
+}
+
+// sessionState is what ResumeSession does with a stored session.
+type sessionState int
+
+const (
+	sessionValid sessionState = iota
+	sessionInvalid
+	sessionRotate
+)
+
+// checkSession decides at now whether rec is still valid, must be rotated, or has ended: it
+// is missing, its user is disabled, it is past its expiry or it was idle for idle or longer.
+// A zero idle or rotate disables the idle timeout or rotation. A session that was already
+// rotated is valid until its shortened expiry and is not rotated again.
+func checkSession(rec *repository.SessionRecord, now time.Time, idle, rotate time.Duration) sessionState {
+	switch {
+	case rec == nil || rec.UserDisabled || !now.Before(rec.ExpiresAt):
+		return sessionInvalid
+	case idle > 0 && now.Sub(rec.LastSeenAt) >= idle:
+		return sessionInvalid
+	case rec.ReplacedBy != "":
+		return sessionValid
+	case rotate > 0 && now.Sub(rec.IssuedAt) >= rotate:
+		return sessionRotate
+	}
+	return sessionValid
+}
+
+// ResumeSession looks up the session with the given ID and records the activity. Sessions past
+// SESSION_TTL, idle longer than SESSION_IDLE_TIMEOUT or of a disabled user are rejected with
+// ErrSessionNotFound. A session older than SESSION_ROTATE_INTERVAL is replaced by a new ID
+// (Rotated is set) once; the old ID stays valid, without being rotated again, for
+// sessionRotationGrace so requests sent in parallel with it do not each start a new session.
+func (s *Service) ResumeSession(ctx context.Context, id string, client SessionClient) (*Session, error) {
+	conn, err := s.authConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return s.resumeSession(ctx, s.repo, conn, id, client, time.Now())
+}
+
+func (s *Service) resumeSession(ctx context.Context, store sessionStore, conn *sql.Conn, id string, client SessionClient, now time.Time) (*Session, error) {
+	rec, err := store.GetSession(ctx, conn, hashSessionID(id))
+	if err != nil {
+		return nil, err
+	}
+	switch checkSession(rec, now, s.cfg.HTTP.SessionIdleTimeout, s.cfg.HTTP.SessionRotateInterval) {
+	case sessionInvalid:
+		if rec != nil {
+			if err := store.DeleteSession(ctx, conn, rec.IDHash); err != nil {
+				logger.Error().Ctx(ctx).Err(err).Msg("Failed to delete expired session")
+			}
+		}
+		return nil, ErrSessionNotFound
+
+	case sessionRotate:
+		newID := utils.GenerateRandomID(32)
+		graceEnd := now.Add(sessionRotationGrace)
+		ok, err := store.RotateSession(ctx, conn, rec.IDHash, hashSessionID(newID), graceEnd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to rotate session: %w", err)
+		}
+		if ok {
+			sess, err := issueSession(ctx, store, conn, newID, rec.Username, rbac.Role(rec.UserRole), rec.ExpiresAt, client, now)
+			if err != nil {
+				return nil, err
+			}
+			sess.Rotated = true
+			logger.Debug().Ctx(ctx).Str("username", rec.Username).Msg("Session rotated")
+			return sess, nil
+		}
+		// A parallel request rotated the session first; this one continues on the old ID
+		// until the grace period that request set.
+		if rec, err = store.GetSession(ctx, conn, rec.IDHash); err != nil {
+			return nil, err
+		}
+		if rec == nil {
+			return nil, ErrSessionNotFound
+		}
+	}
+
+	if err := store.TouchSession(ctx, conn, rec.IDHash, now); err != nil {
+		return nil, fmt.Errorf("failed to update session: %w", err)
+	}
+	return &Session{ID: id, Username: rec.Username, Role: rbac.Role(rec.UserRole), ExpiresAt: rec.ExpiresAt}, nil
+}
+
+// Logout ends the session with the given ID. Unknown IDs are ignored.
+func (s *Service) Logout(ctx context.Context, id string) error {
+	conn, err := s.authConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return s.repo.DeleteSession(ctx, conn, hashSessionID(id))
+}
+
+// SetUserPassword creates the admin user or replaces its password. An empty role keeps the
+// role of an existing user (new users become viewers). Existing sessions of the user are ended.
+func (s *Service) SetUserPassword(ctx context.Context, username, password, role string) error {
+	if !usernameRe.MatchString(username) {
+		return ErrInvalidUsername
+	}
+	if len(password) < minPasswordLength {
+		return ErrWeakPassword
+	}
+	if role != "" {
+		r, err := rbac.Parse(role)
+		if err != nil {
+			return err
+		}
+		role = string(r)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	conn, err := s.authConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := s.repo.UpsertUser(ctx, conn, username, string(hash), role); err != nil {
+		return err
+	}
+	return s.repo.DeleteUserSessions(ctx, conn, username)
+}
+
+// SetUserRole changes the role of an admin user; its sessions get the new role on their next request.
+func (s *Service) SetUserRole(ctx context.Context, username, role string) error {
+	r, err := rbac.Parse(role)
+	if err != nil {
+		return err
+	}
+	conn, err := s.authConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ok, err := s.repo.SetUserRole(ctx, conn, username, string(r))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// DeleteUser removes the admin user and its sessions.
+func (s *Service) DeleteUser(ctx context.Context, username string) error {
+	conn, err := s.authConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ok, err := s.repo.DeleteUser(ctx, conn, username)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// ListUsers returns the admin users.
+func (s *Service) ListUsers(ctx context.Context) ([]repository.User, error) {
+	conn, err := s.authConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return s.repo.ListUsers(ctx, conn)
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"agent-service-prototype/internal/app/agent-service-prototype/repository"
+	"agent-service-prototype/internal/config"
+)
+
+func TestCheckSession(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	session := func(issued, lastSeen, expires time.Duration, disabled bool) *repository.SessionRecord {
+		return &repository.SessionRecord{
+			IssuedAt:     now.Add(-issued),
+			LastSeenAt:   now.Add(-lastSeen),
+			ExpiresAt:    now.Add(expires),
+			UserDisabled: disabled,
+		}
+	}
+	replaced := func(rec *repository.SessionRecord) *repository.SessionRecord {
+		rec.ReplacedBy = "successor"
+		return rec
+	}
+	const (
+		idle   = 30 * time.Minute
+		rotate = time.Hour
+	)
+
+	tests := []struct {
+		name         string
+		rec          *repository.SessionRecord
+		idle, rotate time.Duration
+		want         sessionState
+	}{
+		{"missing", nil, idle, rotate, sessionInvalid},
+		{"fresh", session(time.Minute, time.Minute, time.Hour, false), idle, rotate, sessionValid},
+		{"user disabled", session(time.Minute, time.Minute, time.Hour, true), idle, rotate, sessionInvalid},
+
+		{"before expiry", session(time.Minute, 0, time.Nanosecond, false), idle, rotate, sessionValid},
+		{"at expiry", session(time.Minute, 0, 0, false), idle, rotate, sessionInvalid},
+		{"past expiry", session(time.Minute, 0, -time.Second, false), idle, rotate, sessionInvalid},
+		{"past expiry without idle timeout", session(time.Minute, 0, -time.Second, false), 0, 0, sessionInvalid},
+
+		{"idle just under timeout", session(time.Minute, idle-time.Nanosecond, time.Hour, false), idle, rotate, sessionValid},
+		{"idle at timeout", session(time.Minute, idle, time.Hour, false), idle, rotate, sessionInvalid},
+		{"idle past timeout", session(time.Minute, 2*idle, time.Hour, false), idle, rotate, sessionInvalid},
+		{"idle timeout disabled", session(time.Minute, 24*time.Hour, time.Hour, false), 0, rotate, sessionValid},
+
+		{"just under rotation", session(rotate-time.Nanosecond, 0, time.Hour, false), idle, rotate, sessionValid},
+		{"at rotation", session(rotate, 0, time.Hour, false), idle, rotate, sessionRotate},
+		{"past rotation", session(3*rotate, 0, time.Hour, false), idle, rotate, sessionRotate},
+		{"rotation disabled", session(24*time.Hour, 0, time.Hour, false), idle, 0, sessionValid},
+		{"idle wins over rotation", session(3*rotate, idle, time.Hour, false), idle, rotate, sessionInvalid},
+		{"expiry wins over rotation", session(3*rotate, 0, 0, false), idle, rotate, sessionInvalid},
+
+		{"already rotated", replaced(session(3*rotate, 0, time.Second, false)), idle, rotate, sessionValid},
+		{"already rotated past grace", replaced(session(3*rotate, 0, 0, false)), idle, rotate, sessionInvalid},
+		{"already rotated and idle", replaced(session(3*rotate, idle, time.Second, false)), idle, rotate, sessionInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkSession(tt.rec, now, tt.idle, tt.rotate); got != tt.want {
+				t.Errorf("checkSession = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// memSessions is an in-memory sessionStore; role stands in for the users table.
+type memSessions struct {
+	rows map[string]repository.SessionRecord
+	role string
+}
+
+func (m *memSessions) CreateSession(_ context.Context, _ *sql.Conn, s repository.SessionRecord) error {
+	m.rows[s.IDHash] = s
+	return nil
+}
+
+func (m *memSessions) GetSession(_ context.Context, _ *sql.Conn, idHash string) (*repository.SessionRecord, error) {
+	rec, ok := m.rows[idHash]
+	if !ok {
+		return nil, nil
+	}
+	rec.UserRole = m.role
+	return &rec, nil
+}
+
+func (m *memSessions) TouchSession(_ context.Context, _ *sql.Conn, idHash string, at time.Time) error {
+	if rec, ok := m.rows[idHash]; ok {
+		rec.LastSeenAt = at
+		m.rows[idHash] = rec
+	}
+	return nil
+}
+
+func (m *memSessions) RotateSession(_ context.Context, _ *sql.Conn, idHash, successorHash string, t time.Time) (bool, error) {
+	rec, ok := m.rows[idHash]
+	if !ok || rec.ReplacedBy != "" {
+		return false, nil
+	}
+	rec.ReplacedBy = successorHash
+	if t.Before(rec.ExpiresAt) {
+		rec.ExpiresAt = t
+	}
+	m.rows[idHash] = rec
+	return true, nil
+}
+
+func (m *memSessions) DeleteSession(_ context.Context, _ *sql.Conn, idHash string) error {
+	delete(m.rows, idHash)
+	return nil
+}
+
+// staleSessions serves the first GetSession from a copy read earlier, as a request that
+// loaded the session before a parallel request rotated it.
+type staleSessions struct {
+	*memSessions
+	rec *repository.SessionRecord
+}
+
+func (s *staleSessions) GetSession(ctx context.Context, conn *sql.Conn, idHash string) (*repository.SessionRecord, error) {
+	if rec := s.rec; rec != nil {
+		s.rec = nil
+		return rec, nil
+	}
+	return s.memSessions.GetSession(ctx, conn, idHash)
+}
+
+func TestResumeSessionRotation(t *testing.T) {
+	ctx := context.Background()
+	s := &Service{cfg: &config.Config{HTTP: &config.HTTPConfig{
+		SessionTTL: 8 * time.Hour, SessionIdleTimeout: 45 * time.Minute, SessionRotateInterval: time.Hour,
+	}}}
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	client := SessionClient{IP: "10.0.0.1"}
+
+	store := &memSessions{rows: map[string]repository.SessionRecord{}, role: "operator"}
+	oldID := "old-session-id"
+	expiresAt := start.Add(8 * time.Hour)
+	if _, err := issueSession(ctx, store, nil, oldID, "alice", "operator", expiresAt, client, start); err != nil {
+		t.Fatal(err)
+	}
+
+	// Before the rotation interval the session is only touched.
+	now := start.Add(40 * time.Minute)
+	sess, err := s.resumeSession(ctx, store, nil, oldID, client, now)
+	if err != nil || sess.Rotated || sess.ID != oldID {
+		t.Fatalf("resume before rotation = %+v, %v; want the old ID, not rotated", sess, err)
+	}
+	if got := store.rows[hashSessionID(oldID)].LastSeenAt; !got.Equal(now) {
+		t.Errorf("LastSeenAt = %v, want %v", got, now)
+	}
+
+	// Past the interval the session is rotated: the successor keeps the original expiry and
+	// the old ID ends after the grace period.
+	now = start.Add(time.Hour + time.Minute)
+	stale, _ := store.GetSession(ctx, nil, hashSessionID(oldID))
+	rotated, err := s.resumeSession(ctx, store, nil, oldID, client, now)
+	if err != nil || !rotated.Rotated || rotated.ID == oldID {
+		t.Fatalf("resume past rotation = %+v, %v; want a new ID", rotated, err)
+	}
+	if !rotated.ExpiresAt.Equal(expiresAt) || rotated.Username != "alice" || rotated.Role != "operator" {
+		t.Errorf("rotated session = %+v, want alice/operator until %v", rotated, expiresAt)
+	}
+	old := store.rows[hashSessionID(oldID)]
+	if old.ReplacedBy != hashSessionID(rotated.ID) || !old.ExpiresAt.Equal(now.Add(sessionRotationGrace)) {
+		t.Errorf("old session = %+v, want replaced by the new one and ending after the grace period", old)
+	}
+	if len(store.rows) != 2 {
+		t.Fatalf("%d sessions stored, want 2", len(store.rows))
+	}
+
+	// Requests with the old cookie inside the grace period, including ones that read the
+	// session before it was rotated, neither fail nor start another session.
+	for name, st := range map[string]sessionStore{"after rotation": store, "read before rotation": &staleSessions{store, stale}} {
+		sess, err := s.resumeSession(ctx, st, nil, oldID, client, now.Add(sessionRotationGrace/2))
+		if err != nil || sess.Rotated || sess.ID != oldID {
+			t.Errorf("%s: resume with the old ID = %+v, %v; want the old ID, not rotated", name, sess, err)
+		}
+		if sess != nil && sess.ExpiresAt.After(now.Add(sessionRotationGrace)) {
+			t.Errorf("%s: old session expires at %v, after the grace period", name, sess.ExpiresAt)
+		}
+		if len(store.rows) != 2 {
+			t.Errorf("%s: %d sessions stored, want 2", name, len(store.rows))
+		}
+	}
+
+	// After the grace period the old ID is gone; the new one is valid and not rotated again.
+	later := now.Add(sessionRotationGrace)
+	if _, err := s.resumeSession(ctx, store, nil, oldID, client, later); err != ErrSessionNotFound {
+		t.Errorf("resume with the old ID after the grace period = %v, want ErrSessionNotFound", err)
+	}
+	if _, ok := store.rows[hashSessionID(oldID)]; ok {
+		t.Error("the old session was not deleted after the grace period")
+	}
+	sess, err = s.resumeSession(ctx, store, nil, rotated.ID, client, later)
+	if err != nil || sess.Rotated || sess.ID != rotated.ID {
+		t.Errorf("resume with the new ID = %+v, %v; want the new ID, not rotated", sess, err)
+	}
+
+	// An idle session is rejected and deleted.
+	if _, err := s.resumeSession(ctx, store, nil, rotated.ID, client, later.Add(46*time.Minute)); err != ErrSessionNotFound {
+		t.Errorf("resume after the idle timeout = %v, want ErrSessionNotFound", err)
+	}
+	if len(store.rows) != 0 {
+		t.Errorf("%d sessions left, want 0", len(store.rows))
+	}
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+
+	"agent-service-prototype/pkg/metrics"
+	"agent-service-prototype/pkg/tracing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// stepTracker reports each installation step to onStep, observes its duration for metrics
+// and traces it as a span under the span of the context it was created with.
+type stepTracker struct {
+	parent context.Context
+	onStep func(string)
+	timer  metrics.StepTimer
+	span   trace.Span
+}
+
+func newStepTracker(ctx context.Context, onStep func(string)) *stepTracker {
+	return &stepTracker{parent: ctx, onStep: onStep}
+}
+
+// begin ends the current step and starts step. SQL run with the returned context is traced
+// under the step's span.
+func (st *stepTracker) begin(step string) context.Context {
+	st.end(nil)
+	st.timer.Step(step)
+	st.onStep(step)
+	ctx, span := tracing.Start(st.parent, step)
+	st.span = span
+	return ctx
+}
+
+// end ends the current step, marking its span failed when res is a failed result.
+func (st *stepTracker) end(res *InstallationResult) {
+	st.timer.Stop()
+	if st.span == nil {
+		return
+	}
+	if res != nil && !res.Success {
+		tracing.Fail(st.span, res.Error)
+	}
+	st.span.End()
+	st.span = nil
+}
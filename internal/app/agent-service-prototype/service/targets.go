@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"agent-service-prototype/internal/config"
+	"agent-service-prototype/pkg/logger"
+	"agent-service-prototype/pkg/metrics"
+	"agent-service-prototype/pkg/tracing"
+
+	_ "github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrNoTargets is returned when a multi-target installation has no databases to run on.
+var ErrNoTargets = errors.New("no installation targets: set DB_TARGETS or pass targets in the request")
+
+// TargetResult is the outcome of one target in a multi-target installation.
+type TargetResult struct {
+	Target string
+	*InstallationResult
+}
+
+// MultiInstallationResult summarizes a multi-target installation. Step and Error are set
+// when the bundle itself could not be prepared, in which case no target was attempted.
+type MultiInstallationResult struct {
+	RunID         string
+	Success       bool
+	Step          string
+	Error         string
+	BundleID      string
+	BundleVersion string
+	Succeeded     int
+	Failed        int
+	Targets       []TargetResult
+	Duration      time.Duration
+	TraceID       string
+}
+
+// ResolveTargets returns the databases for a multi-target installation. Requested names
+// are looked up in DB_TARGETS; with no requested names every configured target is used.
+func (s *Service) ResolveTargets(requested []string) ([]config.DBTarget, error) {
+	configured, err := s.cfg.DBTargets()
+	if err != nil {
+		return nil, err
+	}
+	if len(requested) == 0 {
+		if len(configured) == 0 {
+			return nil, ErrNoTargets
+		}
+		return configured, nil
+	}
+
+	targets := make([]config.DBTarget, 0, len(requested))
+	seen := make(map[string]bool, len(requested))
+	for _, name := range requested {
+		if name == "" {
+			return nil, fmt.Errorf("target name is required")
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate target %q", name)
+		}
+		seen[name] = true
+		t, err := s.configuredTarget(name)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// configuredTarget looks up a DB_TARGETS entry by name.
+func (s *Service) configuredTarget(name string) (config.DBTarget, error) {
+	configured, err := s.cfg.DBTargets()
+	if err != nil {
+		return config.DBTarget{}, err
+	}
+	for _, t := range configured {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return config.DBTarget{}, fmt.Errorf("target %q is not configured in DB_TARGETS", name)
+}
+
+// RunMultiInstallation installs one verified bundle on every target, running at most
+// concurrency targets at a time (INSTALL_CONCURRENCY when concurrency <= 0). Each target
+// is locked and recorded independently; a failing target does not stop the others.
+func (s *Service) RunMultiInstallation(ctx context.Context, opts InstallOptions, targets []config.DBTarget, concurrency int) *MultiInstallationResult {
+	start := time.Now()
+	runID := newRunID()
+	if cur := s.GetStatus(); cur != nil && cur.Status == StatusRunning && cur.RunID != "" {
+		runID = cur.RunID
+	}
+	if concurrency <= 0 {
+		concurrency = s.cfg.HTTP.InstallConcurrency
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	ctx = logger.WithFields(ctx, "run_id", runID)
+	ctx, span := tracing.Start(ctx, "installation", attribute.String("run_id", runID), attribute.Int("targets", len(targets)))
+	result := &MultiInstallationResult{RunID: runID, TraceID: tracing.TraceID(ctx), Targets: make([]TargetResult, len(targets))}
+	b, res := s.prepareBundle(ctx, opts, s.updateStep)
+	if res != nil {
+		result.Step = res.Step
+		result.Error = res.Error
+	} else {
+		result.BundleID = b.ID
+		result.BundleVersion = b.Manifest.BundleVersion
+		s.updateStep(StepApplyTargets)
+		logger.Info().Ctx(ctx).Int("targets", len(targets)).Int("concurrency", concurrency).Msg("Installing bundle on targets")
+
+		sem := make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
+		for i, t := range targets {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, t config.DBTarget) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				result.Targets[i] = TargetResult{Target: t.Name, InstallationResult: s.installOnTarget(ctx, runID, b, opts, t)}
+			}(i, t)
+		}
+		wg.Wait()
+
+		for _, tr := range result.Targets {
+			if tr.Success {
+				result.Succeeded++
+			} else {
+				result.Failed++
+			}
+		}
+		result.Success = result.Failed == 0
+		if !result.Success {
+			result.Step = StepApplyTargets
+			result.Error = fmt.Sprintf("%d of %d targets failed", result.Failed, len(targets))
+		}
+	}
+
+	now := time.Now()
+	result.Duration = now.Sub(start)
+	if !result.Success {
+		tracing.Fail(span, result.Error)
+	}
+	span.End()
+	metrics.InstallationFinished("multi", result.Success)
+	if result.Success {
+		if err := s.store.MarkInstalled(result.BundleID, now); err != nil {
+			logger.Error().Ctx(ctx).Err(err).Str("bundle_id", result.BundleID).Msg("Failed to record bundle installation")
+		}
+		s.pruneBundles(result.BundleID)
+	}
+
+	s.mu.Lock()
+	targetSteps := map[string]string{}
+	if s.status != nil && s.status.Targets != nil {
+		targetSteps = s.status.Targets
+	}
+	status := StatusSuccess
+	if !result.Success {
+		status = StatusFailed
+	}
+	s.status = &RunStatus{
+		RunID:         runID,
+		Status:        status,
+		Step:          result.Step,
+		Error:         result.Error,
+		BundleID:      result.BundleID,
+		BundleVersion: result.BundleVersion,
+		Targets:       targetSteps,
+		Actor:         opts.Actor,
+		StartedAt:     start,
+		FinishedAt:    now,
+	}
+	s.mu.Unlock()
+
+	return result
+}
+
+// installOnTarget opens a dedicated pool for t and applies the bundle to it.
+func (s *Service) installOnTarget(ctx context.Context, runID string, b *preparedBundle, opts InstallOptions, t config.DBTarget) *InstallationResult {
+	start := time.Now()
+	onStep := func(step string) { s.updateTargetStep(t.Name, step) }
+	ctx = logger.WithFields(ctx, "target", t.Name)
+	ctx, span := tracing.Start(ctx, "target "+t.Name, attribute.String("target", t.Name))
+	defer span.End()
+
+	var res *InstallationResult
+	db, err := openTargetDB(t.DSN)
+	if err != nil {
+		res = &InstallationResult{Step: StepConnectDB, Error: err.Error()}
+	} else {
+		res = s.installBundle(ctx, runID, b, opts, installTarget{Name: t.Name, DSN: t.DSN, DB: db, onStep: onStep})
+		db.Close()
+	}
+
+	res.RunID = runID
+	res.BundleID = b.ID
+	res.BundleVersion = b.Manifest.BundleVersion
+	res.Duration = time.Since(start)
+	if res.Success {
+		onStep(StatusSuccess)
+		logger.Info().Ctx(ctx).Str("schema_version", res.SchemaVersion).Msg("Target installed")
+	} else {
+		tracing.Fail(span, res.Error)
+		onStep(StatusFailed)
+		logger.Error().Ctx(ctx).Str("step", res.Step).Str("error", res.Error).Msg("Target installation failed")
+	}
+	return res
+}
+
+// openTargetDB opens a small pool for one target: a connection for the advisory lock and
+// migrations, plus one spare.
+func openTargetDB(dsn string) (*sql.DB, error) {
+	db, err := tracing.OpenDB("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db.SetMaxOpenConns(2)
+	db.SetMaxIdleConns(1)
+	return db, nil
+}
+
+func (s *Service) updateTargetStep(name, step string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status != nil {
+		if s.status.Targets == nil {
+			s.status.Targets = make(map[string]string)
+		}
+		s.status.Targets[name] = step
+	}
+}
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"agent-service-prototype/internal/app/agent-service-prototype/repository"
+	"agent-service-prototype/pkg/logger"
+	"agent-service-prototype/pkg/setup"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrTenantExists        = errors.New("tenant already exists")
+	ErrTenantNotFound      = errors.New("tenant not found")
+	ErrInvalidTenantID     = errors.New("tenant id must match ^[a-z][a-z0-9_]{0,39}$")
+	ErrNoInstalledBundle   = errors.New("no bundle has been installed yet")
+	ErrTenantsNotSupported = errors.New("installed bundle is not tenant-scoped: db.default_schema has no " + setup.TenantPlaceholder)
+	ErrNoDefaultSchema     = errors.New("installed bundle has no db.default_schema")
+)
+
+// tenantIDRe keeps tenant IDs safe to embed in schema names.
+var tenantIDRe = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+
+// installTenants applies a tenant-scoped bundle to every registered tenant schema, in ID order,
+// and returns the last migration version. It stops at the first tenant that fails.
+func (s *Service) installTenants(ctx context.Context, conn *sql.Conn, b *preparedBundle, sc auditScope, force, skipSmoke bool, steps *stepTracker) (string, *InstallationResult) {
+	if err := s.repo.EnsureTenantTables(ctx, conn); err != nil {
+		return "", &InstallationResult{Step: StepApplyBaseline, Error: fmt.Sprintf("failed to ensure tenant tables: %v", err)}
+	}
+	tenants, err := s.repo.ListTenants(ctx, conn)
+	if err != nil {
+		return "", &InstallationResult{Step: StepApplyBaseline, Error: fmt.Sprintf("failed to list tenants: %v", err)}
+	}
+	if len(tenants) == 0 {
+		logger.Warn().Ctx(ctx).Msg("Tenant-scoped bundle but no tenants are registered")
+	}
+
+	var lastVersion string
+	for _, tn := range tenants {
+		version, res := s.installTenant(ctx, conn, b, tn, sc, force, skipSmoke, steps)
+		if res != nil {
+			res.Error = fmt.Sprintf("tenant %s: %s", tn.ID, res.Error)
+			return "", res
+		}
+		lastVersion = version
+	}
+	return lastVersion, nil
+}
+
+// installTenant brings one tenant schema up to the bundle version: the baseline is applied
+// when the schema does not exist yet, then pending migrations run against the tenant's history.
+func (s *Service) installTenant(ctx context.Context, conn *sql.Conn, b *preparedBundle, tn repository.Tenant, sc auditScope, force, skipSmoke bool, steps *stepTracker) (string, *InstallationResult) {
+	ctx = steps.begin(StepApplyBaseline)
+	exists, err := s.repo.SchemaExists(ctx, conn, tn.Schema)
+	if err != nil {
+		return "", &InstallationResult{Step: StepApplyBaseline, Error: fmt.Sprintf("failed to detect schema state: %v", err)}
+	}
+	if !exists {
+		logger.Info().Ctx(ctx).Str("tenant", tn.ID).Str("schema", tn.Schema).Msg("Provisioning tenant schema, applying baseline...")
+		if _, err := conn.ExecContext(ctx, "CREATE SCHEMA IF NOT EXISTS "+pq.QuoteIdentifier(tn.Schema)); err != nil {
+			return "", &InstallationResult{Step: StepApplyBaseline, Error: fmt.Sprintf("failed to create schema: %v", err)}
+		}
+		if res := s.applyBaseline(ctx, conn, b, tn.Schema); res != nil {
+			return "", res
+		}
+	}
+
+	ctx = steps.begin(StepApplyMigrations)
+	sc.Tenant = tn.ID
+	version, res := s.applyMigrations(ctx, conn, b, sc, tn.Schema, force)
+	if res != nil {
+		return "", res
+	}
+	if err := s.repo.SetTenantVersion(ctx, conn, tn.ID, version); err != nil {
+		return "", &InstallationResult{Step: StepApplyMigrations, Error: fmt.Sprintf("failed to record tenant version: %v", err)}
+	}
+
+	if !skipSmoke && b.Manifest.Checks.Smoke != "" {
+		ctx = steps.begin(StepPostCheck)
+		if res := s.runSmoke(ctx, conn, b, tn.Schema); res != nil {
+			return "", res
+		}
+	}
+	logger.Info().Ctx(ctx).Str("tenant", tn.ID).Str("schema_version", version).Msg("Tenant schema up to date")
+	return version, nil
+}
+
+// ListTenants returns the registered tenants of the primary database.
+func (s *Service) ListTenants(ctx context.Context) ([]repository.Tenant, error) {
+	conn, err := s.repo.DB().Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Close()
+	return s.repo.ListTenants(ctx, conn)
+}
+
+// CreateTenant registers tenant id and provisions its schema at the schema version the
+// registered tenants are at (see tenantBundle). If provisioning fails the schema and
+// registry entry are removed again.
+func (s *Service) CreateTenant(ctx context.Context, id string, actor Actor) (*repository.Tenant, error) {
+	if !tenantIDRe.MatchString(id) {
+		return nil, ErrInvalidTenantID
+	}
+
+	conn, err := s.repo.DB().Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	unlock, err := s.lockDB(ctx, conn, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if err := s.repo.EnsureTenantTables(ctx, conn); err != nil {
+		return nil, fmt.Errorf("failed to ensure tenant tables: %w", err)
+	}
+	b, err := s.tenantBundle(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	manifest := b.Manifest
+	if existing, err := s.repo.GetTenant(ctx, conn, id); err != nil {
+		return nil, err
+	} else if existing != nil {
+		return nil, fmt.Errorf("%w: %s", ErrTenantExists, id)
+	}
+	schema := manifest.TenantSchema(id)
+	if exists, err := s.repo.SchemaExists(ctx, conn, schema); err != nil {
+		return nil, err
+	} else if exists {
+		return nil, fmt.Errorf("%w: schema %s is already in use", ErrTenantExists, schema)
+	}
+
+	if err := s.repo.CreateTenant(ctx, conn, id, schema); err != nil {
+		return nil, fmt.Errorf("failed to register tenant: %w", err)
+	}
+	skipSmoke := s.cfg.HTTP.SkipSmoke
+	tn := repository.Tenant{ID: id, Schema: schema}
+	sc := auditScope{Actor: actor, RunID: newRunID(), App: manifest.App, BundleID: b.ID, BundleVersion: manifest.BundleVersion}
+	steps := newStepTracker(ctx, func(string) {})
+	_, res := s.installTenant(ctx, conn, b, tn, sc, false, skipSmoke, steps)
+	steps.end(res)
+	if res != nil {
+		if err := s.dropTenant(ctx, conn, tn); err != nil {
+			logger.Error().Ctx(ctx).Err(err).Str("tenant", id).Msg("Failed to clean up tenant after failed provisioning")
+		}
+		return nil, fmt.Errorf("failed to provision tenant %s at %s: %s", id, res.Step, res.Error)
+	}
+	logger.Info().Ctx(ctx).Str("tenant", id).Str("schema", schema).Str("bundle_id", b.ID).Msg("Tenant created")
+	return s.repo.GetTenant(ctx, conn, id)
+}
+
+// tenantBundle returns the cached bundle new tenants are provisioned from. The version is
+// taken from the database: the highest schema_version of the registered tenants, so a new
+// tenant matches the existing ones even when this agent's cache saw a different bundle last.
+// Only when no tenant has been migrated yet is the last installed bundle used.
+func (s *Service) tenantBundle(ctx context.Context, conn *sql.Conn) (*preparedBundle, error) {
+	tenants, err := s.repo.ListTenants(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenants: %w", err)
+	}
+	var version string
+	for _, tn := range tenants {
+		if tn.SchemaVersion > version {
+			version = tn.SchemaVersion
+		}
+	}
+
+	var candidates []setup.BundleMeta
+	if version == "" {
+		meta, err := s.store.LastInstalled()
+		if err != nil {
+			return nil, err
+		}
+		if meta == nil {
+			return nil, ErrNoInstalledBundle
+		}
+		candidates = []setup.BundleMeta{*meta}
+	} else if candidates, err = s.store.List(); err != nil {
+		return nil, err
+	}
+
+	for _, meta := range candidates {
+		baseDir, manifest, err := s.loadCachedBundle(meta.ID)
+		if err != nil {
+			logger.Warn().Ctx(ctx).Err(err).Str("bundle_id", meta.ID).Msg("Skipping unreadable cached bundle")
+			continue
+		}
+		if version != "" && manifestSchemaVersion(manifest) != version {
+			continue
+		}
+		if manifest.DB.DefaultSchema == "" {
+			return nil, ErrNoDefaultSchema
+		}
+		if !manifest.IsTenantScoped() {
+			return nil, ErrTenantsNotSupported
+		}
+		return &preparedBundle{ID: meta.ID, BaseDir: baseDir, Manifest: manifest}, nil
+	}
+	if version == "" {
+		return nil, ErrNoInstalledBundle
+	}
+	return nil, fmt.Errorf("%w: no cached bundle has tenant schema version %s", ErrNoInstalledBundle, version)
+}
+
+// manifestSchemaVersion is the schema version a database is at once manifest is installed.
+func manifestSchemaVersion(m *setup.Manifest) string {
+	if v := lastMigrationVersion(m); v != "" {
+		return v
+	}
+	return m.Baseline.Version
+}
+
+// DeleteTenant drops the tenant's schema and removes it from the registry. The deletion is
+// written to the audit log first; when that fails nothing is dropped.
+func (s *Service) DeleteTenant(ctx context.Context, id string, actor Actor) error {
+	conn, err := s.repo.DB().Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	unlock, err := s.lockDB(ctx, conn, nil)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.repo.EnsureTenantTables(ctx, conn); err != nil {
+		return fmt.Errorf("failed to ensure tenant tables: %w", err)
+	}
+	tn, err := s.repo.GetTenant(ctx, conn, id)
+	if err != nil {
+		return err
+	}
+	if tn == nil {
+		return fmt.Errorf("%w: %s", ErrTenantNotFound, id)
+	}
+	sc := auditScope{Actor: actor, RunID: newRunID(), Tenant: tn.ID}
+	payload := map[string]any{"schema": tn.Schema, "schema_version": tn.SchemaVersion}
+	if err := s.writeAudit(ctx, conn, sc, AuditEntityTenant, tn.ID, AuditActionDelete, payload); err != nil {
+		return fmt.Errorf("failed to write audit log, tenant not deleted: %w", err)
+	}
+	if err := s.dropTenant(ctx, conn, *tn); err != nil {
+		return err
+	}
+	logger.Info().Ctx(ctx).Str("tenant", id).Str("schema", tn.Schema).Msg("Tenant deleted")
+	return nil
+}
+
+func (s *Service) dropTenant(ctx context.Context, conn *sql.Conn, tn repository.Tenant) error {
+	if _, err := conn.ExecContext(ctx, "DROP SCHEMA IF EXISTS "+pq.QuoteIdentifier(tn.Schema)+" CASCADE"); err != nil {
+		return fmt.Errorf("failed to drop schema %s: %w", tn.Schema, err)
+	}
+	if err := s.repo.DeleteTenant(ctx, conn, tn.ID); err != nil {
+		return fmt.Errorf("failed to unregister tenant: %w", err)
+	}
+	return nil
+}
@@ -1,42 +1,111 @@
 package bootstrap
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"errors"
+	"fmt"
+	"time"
 
 	"agent-service-prototype/internal/config"
+	"agent-service-prototype/pkg/logger"
+	"agent-service-prototype/pkg/tracing"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
-// ensureSchemaExists creates the schema if it doesn't exist
-func ensureSchemaExists(cfg *config.Config) error {
-	dbURL := cfg.DatabaseURL()
+// Backoff between startup connection attempts: doubling from connectBackoffMin up to connectBackoffMax.
+const (
+	connectBackoffMin = time.Second
+	connectBackoffMax = 30 * time.Second
+	// connectAttemptTimeout bounds a single ping + schema creation.
+	connectAttemptTimeout = 10 * time.Second
+)
+
+// ErrDatabaseUnavailable is returned by ConnectDatabase when PostgreSQL cannot be reached
+// within DB_STARTUP_TIMEOUT.
+var ErrDatabaseUnavailable = errors.New("database unavailable")
 
-	db, err := sql.Open("postgres", dbURL)
+// openPool opens the shared connection pool with the configured limits. It does not connect.
+func openPool(cfg *config.Config) (*sql.DB, error) {
+	db, err := tracing.OpenDB("postgres", cfg.DatabaseURL())
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
-	defer db.Close()
+	db.SetMaxOpenConns(cfg.DB.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.DB.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.DB.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.DB.ConnMaxIdleTime)
+	return db, nil
+}
 
-	_, err = db.Exec("CREATE SCHEMA IF NOT EXISTS public")
+// ensureSchemaExists creates the first DB_SCHEMA schema if it doesn't exist
+func ensureSchemaExists(ctx context.Context, db *sql.DB, cfg *config.Config) error {
+	schemas := cfg.SearchPath()
+	if len(schemas) == 0 {
+		return nil
+	}
+	_, err := db.ExecContext(ctx, "CREATE SCHEMA IF NOT EXISTS "+pq.QuoteIdentifier(schemas[0]))
 	return err
 }
 
-// InitDatabase initializes the database connection pool.
-// Ent client creation and auto-migration are commented out until Ent code is generated.
-func InitDatabase(cfg *config.Config) *sql.DB {
-	if err := ensureSchemaExists(cfg); err != nil {
-		log.Fatal("Failed to create schema:", err)
+// connectOnce pings the database and creates the configured schema.
+func connectOnce(ctx context.Context, db *sql.DB, cfg *config.Config) error {
+	ctx, cancel := context.WithTimeout(ctx, connectAttemptTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		return err
+	}
+	if err := ensureSchemaExists(ctx, db, cfg); err != nil {
+		return fmt.Errorf("failed to create schema: %w", err)
 	}
+	return nil
+}
 
-	db, err := sql.Open("postgres", cfg.DatabaseURL())
+// connect retries connectOnce with exponential backoff until it succeeds or ctx is done.
+func connect(ctx context.Context, db *sql.DB, cfg *config.Config) error {
+	backoff := connectBackoffMin
+	for attempt := 1; ; attempt++ {
+		err := connectOnce(ctx, db, cfg)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w: %v", ErrDatabaseUnavailable, err)
+		}
+		logger.Warn().Err(err).Int("attempt", attempt).Dur("retry_in", backoff).Msg("Database not ready, retrying")
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ErrDatabaseUnavailable, err)
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, connectBackoffMax)
+	}
+}
+
+// InitDatabase initializes the shared connection pool and waits up to DB_STARTUP_TIMEOUT for
+// PostgreSQL. If it is not reachable by then, the pool is returned anyway so the server can
+// start in degraded mode (/readyz reports the database as down) while connecting continues in
+// the background until ctx is done. Only an invalid configuration is returned as an error.
+// Ent client creation and auto-migration are commented out until Ent code is generated.
+func InitDatabase(ctx context.Context, cfg *config.Config) (*sql.DB, error) {
+	db, err := openPool(cfg)
 	if err != nil {
-		log.Fatal("Failed to open database:", err)
+		return nil, err
 	}
 
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(5)
+	waitCtx, cancel := context.WithTimeout(ctx, cfg.DB.StartupTimeout)
+	err = connect(waitCtx, db, cfg)
+	cancel()
+	if err != nil {
+		logger.Warn().Err(err).Dur("startup_timeout", cfg.DB.StartupTimeout).Msg("⚠️  Starting in degraded mode, still connecting to the database in the background")
+		go func() {
+			if err := connect(ctx, db, cfg); err == nil {
+				logger.Info().Msg("✅ Database connection established, leaving degraded mode")
+			}
+		}()
+		return db, nil
+	}
 
 	// Ent client + auto-migration commented out — code generation not run yet.
 	// drv := entsql.OpenDB(dialect.Postgres, db)
@@ -47,8 +116,25 @@ func InitDatabase(cfg *config.Config) *sql.DB {
 	// 	log.Fatalf("Failed creating schema resources: %v", err)
 	// }
 
-	log.Println("✅ Database initialized with shared connection pool")
-	return db
+	logger.Info().Int("max_open_conns", cfg.DB.MaxOpenConns).Msg("✅ Database initialized with shared connection pool")
+	return db, nil
+}
+
+// ConnectDatabase opens the shared connection pool for command-line tools. Unlike
+// InitDatabase it fails with ErrDatabaseUnavailable when PostgreSQL cannot be reached
+// within DB_STARTUP_TIMEOUT.
+func ConnectDatabase(ctx context.Context, cfg *config.Config) (*sql.DB, error) {
+	db, err := openPool(cfg)
+	if err != nil {
+		return nil, err
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, cfg.DB.StartupTimeout)
+	defer cancel()
+	if err := connect(waitCtx, db, cfg); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
 }
 
 // CloseDatabase closes the database connection
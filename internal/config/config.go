@@ -4,6 +4,11 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"agent-service-prototype/pkg/maintenance"
 
 	"github.com/joho/godotenv"
 )
@@ -14,13 +19,82 @@ type Config struct {
 }
 
 type HTTPConfig struct {
-	Env  string
-	Port string
-	BundleURL string
-	WorkDir string
-	AdvisoryLockKey string
-	Force string
-	SkipSmoke string
+	Env                  string
+	Port                 string
+	BundleURL            string
+	WorkDir              string
+	AdvisoryLockKey      int64
+	Force                bool
+	SkipSmoke            bool
+	BundleRetentionCount int
+	BundleRetentionDays  int
+	BackupBeforeMigrate  bool
+	InstallConcurrency   int
+	LockTimeout          time.Duration
+	ApplicationName      string
+	// ShutdownTimeout is how long in-flight requests (installations) may run after
+	// SIGINT/SIGTERM before they are cancelled.
+	ShutdownTimeout time.Duration
+	// InteractiveShutdown asks for Y/N confirmation on stdin before shutting down (dev only).
+	InteractiveShutdown bool
+	// RequiredSchemaVersion is the migration version /readyz requires RequiredSchemaApp to have
+	// applied (REQUIRED_SCHEMA_VERSION, REQUIRED_SCHEMA_APP). Empty disables the schema check.
+	RequiredSchemaVersion string
+	RequiredSchemaApp     string
+	// TracingExporter selects where OpenTelemetry spans go (TRACING_EXPORTER): none, otlp,
+	// stdout or file (TRACING_FILE).
+	TracingExporter string
+	TracingFile     string
+	// LogFormat (LOG_FORMAT) is json or console; LogLevel (LOG_LEVEL) is the minimum level logged.
+	LogFormat string
+	LogLevel  string
+	// TLSCertFile and TLSKeyFile (TLS_CERT_FILE, TLS_KEY_FILE) switch the API to HTTPS. The
+	// files are re-read when they change, so renewed certificates need no restart.
+	TLSCertFile string
+	TLSKeyFile  string
+	// AutoInstall (AUTO_INSTALL) installs BUNDLE_URL on the primary database at startup;
+	// the agent is not ready until an installation has succeeded.
+	AutoInstall bool
+	// BundlePollInterval (BUNDLE_POLL_INTERVAL) is how often BUNDLE_URL is checked for a newer
+	// bundle_version, which is then installed inside MaintenanceWindow; 0 disables polling.
+	BundlePollInterval time.Duration
+	// MaintenanceWindow (MAINTENANCE_WINDOW, e.g. "Sat,Sun 02:00-05:00") in
+	// MaintenanceTimezone (MAINTENANCE_TIMEZONE); see Config.Maintenance.
+	MaintenanceWindow   string
+	MaintenanceTimezone string
+	// AuthAPIKeys (AUTH_API_KEYS) and AuthAPIKeysFile (AUTH_API_KEYS_FILE) hold name[:role]=sha256
+	// entries of the static API keys accepted by /setup.
+	AuthAPIKeys     string
+	AuthAPIKeysFile string
+	// AuthJWKSFile (AUTH_JWKS_FILE) enables bearer JWTs signed by its keys; AuthJWTIssuer and
+	// AuthJWTAudience (AUTH_JWT_ISSUER, AUTH_JWT_AUDIENCE) are then required claims.
+	AuthJWKSFile    string
+	AuthJWTIssuer   string
+	AuthJWTAudience string
+	// AuthJWTRoleClaim (AUTH_JWT_ROLE_CLAIM) names the JWT claim holding the caller's role
+	// (a string or an array; the most privileged known role wins).
+	AuthJWTRoleClaim string
+	// AuthDefaultRole (AUTH_DEFAULT_ROLE) is the role of API keys without a :role and of
+	// JWTs without a role claim: viewer, operator or admin.
+	AuthDefaultRole string
+	// AuthDisabled (AUTH_DISABLED) serves /setup without authentication (development only).
+	AuthDisabled bool
+	// MetricsPublic (METRICS_PUBLIC) serves /metrics without authentication.
+	MetricsPublic bool
+	// AuthSessions (AUTH_SESSIONS) enables POST /auth/login and the session cookie for admin
+	// users stored in META_SCHEMA.users.
+	AuthSessions bool
+	// SessionTTL (SESSION_TTL) is the absolute session lifetime, SessionIdleTimeout
+	// (SESSION_IDLE_TIMEOUT) ends inactive sessions and SessionRotateInterval
+	// (SESSION_ROTATE_INTERVAL) is how often the session ID is replaced; 0 disables the latter two.
+	SessionTTL            time.Duration
+	SessionIdleTimeout    time.Duration
+	SessionRotateInterval time.Duration
+	// SessionCookieSecure (SESSION_COOKIE_SECURE) sets the Secure flag on the session cookie.
+	SessionCookieSecure bool
+	// AuditHMACKey (AUDIT_HMAC_KEY) signs the audit chain; it is never written to the database.
+	// Without it the chain uses plain SHA-256 links, which anyone with write access can rebuild.
+	AuditHMACKey string
 }
 
 type DBConfig struct {
@@ -30,33 +104,134 @@ type DBConfig struct {
 	Password string
 	Name     string
 	SSLMode  string
-	Schema	 string
+	// SSLRootCert (DB_SSL_ROOT_CERT) is the CA bundle used by verify-ca and verify-full;
+	// SSLCert and SSLKey (DB_SSL_CERT, DB_SSL_KEY) are the client certificate and key.
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+	// Schema is DB_SCHEMA: the connection's search_path (comma-separated schemas).
+	// The first schema is created at startup if it does not exist.
+	Schema string
+	// Targets is DB_TARGETS: extra databases for multi-target installs,
+	// as comma-separated name=dsn pairs.
+	Targets string
+	// MetaSchema and MetaTable locate the agent's migration history
+	// (META_SCHEMA, META_TABLE); other agent tables live in MetaSchema too.
+	MetaSchema string
+	MetaTable  string
+	// AuditSchema (AUDIT_SCHEMA) is the schema holding the bundle's audit_logs table.
+	AuditSchema string
+	// Pool settings of the shared connection pool (DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS,
+	// DB_CONN_MAX_LIFETIME, DB_CONN_MAX_IDLE_TIME); zero durations mean no limit.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// StartupTimeout (DB_STARTUP_TIMEOUT) is how long startup waits for PostgreSQL before
+	// the server starts in degraded mode and keeps connecting in the background.
+	StartupTimeout time.Duration
+}
+
+// DBTarget is one named database a bundle can be installed on.
+type DBTarget struct {
+	Name string
+	DSN  string
 }
 
+// Load reads the configuration from, in increasing precedence: built-in defaults, the
+// YAML or TOML file named by CONFIG_FILE, and environment variables (a .env file is
+// loaded into the environment first). Every setting is parsed and validated; all
+// problems are reported together in a *ValidationError.
 func Load() (*Config, error) {
 	_ = godotenv.Load()
-	
-	return &Config{
-		HTTP: &HTTPConfig{
-			Env:             getEnv("APP_ENV"),
-			Port:            getEnv("APP_PORT"),
-			BundleURL:       getEnv("BUNDLE_URL"),
-			WorkDir:         getEnvOrDefault("WORK_DIR", "./.work"),
-			AdvisoryLockKey: getEnvOrDefault("ADVISORY_LOCK_KEY", "987654321"),
-			Force:           getEnvOrDefault("FORCE", "false"),
-			SkipSmoke:       getEnvOrDefault("SKIP_SMOKE", "false"),
-		},
-		DB: &DBConfig{
-			Host:     getEnv("DB_HOST"),
-			Port:     getEnv("DB_PORT"),
-			User:     getEnv("DB_USER"),
-			Password: getEnv("DB_PASSWORD"),
-			Name:     getEnv("DB_NAME"),
-			SSLMode:  getEnv("DB_SSL_MODE"),
-		},
-	}, nil
+
+	file := map[string]string{}
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		var err error
+		if file, err = loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &Config{HTTP: &HTTPConfig{}, DB: &DBConfig{}}
+	var problems []string
+	known := make(map[string]bool)
+	for _, s := range c.settings() {
+		known[s.key] = true
+		value, ok := os.LookupEnv(s.env)
+		if !ok {
+			value, ok = file[s.key]
+		}
+		if !ok {
+			value = s.def
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			if s.required {
+				problems = append(problems, fmt.Sprintf("%s (%s) is required", s.env, s.key))
+			}
+			continue
+		}
+		if err := s.parse(value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s (%s): %v", s.env, s.key, err))
+		}
+	}
+	if c.HTTP.RequiredSchemaVersion != "" && c.HTTP.RequiredSchemaApp == "" {
+		problems = append(problems, "REQUIRED_SCHEMA_APP (http.required_schema_app) is required when REQUIRED_SCHEMA_VERSION is set")
+	}
+	if c.HTTP.TracingExporter == "file" && c.HTTP.TracingFile == "" {
+		problems = append(problems, "TRACING_FILE (http.tracing_file) is required when TRACING_EXPORTER is file")
+	}
+	if c.HTTP.AutoInstall && c.HTTP.BundleURL == "" {
+		problems = append(problems, "BUNDLE_URL (http.bundle_url) is required when AUTO_INSTALL is true")
+	}
+	if c.HTTP.BundlePollInterval > 0 {
+		if c.HTTP.BundleURL == "" {
+			problems = append(problems, "BUNDLE_URL (http.bundle_url) is required when BUNDLE_POLL_INTERVAL is set")
+		}
+		if c.HTTP.MaintenanceWindow == "" {
+			problems = append(problems, "MAINTENANCE_WINDOW (http.maintenance_window) is required when BUNDLE_POLL_INTERVAL is set")
+		}
+	}
+	if c.HTTP.MaintenanceWindow != "" && c.HTTP.MaintenanceTimezone != "" {
+		if _, err := c.Maintenance(); err != nil {
+			problems = append(problems, fmt.Sprintf("MAINTENANCE_WINDOW (http.maintenance_window): %v", err))
+		}
+	}
+	if !c.HTTP.AuthDisabled && c.HTTP.AuthAPIKeys == "" && c.HTTP.AuthAPIKeysFile == "" && c.HTTP.AuthJWKSFile == "" && !c.HTTP.AuthSessions {
+		problems = append(problems, "AUTH_API_KEYS, AUTH_API_KEYS_FILE, AUTH_JWKS_FILE or AUTH_SESSIONS is required to protect /setup (or set AUTH_DISABLED=true)")
+	}
+	if c.HTTP.AuthJWKSFile != "" && (c.HTTP.AuthJWTIssuer == "" || c.HTTP.AuthJWTAudience == "") {
+		problems = append(problems, "AUTH_JWT_ISSUER (http.auth_jwt_issuer) and AUTH_JWT_AUDIENCE (http.auth_jwt_audience) are required when AUTH_JWKS_FILE is set")
+	}
+	if (c.HTTP.TLSCertFile == "") != (c.HTTP.TLSKeyFile == "") {
+		problems = append(problems, "TLS_CERT_FILE (http.tls_cert_file) and TLS_KEY_FILE (http.tls_key_file) must be set together")
+	}
+	if (c.DB.SSLCert == "") != (c.DB.SSLKey == "") {
+		problems = append(problems, "DB_SSL_CERT (db.ssl_cert) and DB_SSL_KEY (db.ssl_key) must be set together")
+	}
+	if c.DB.SSLMode == "disable" && (c.DB.SSLRootCert != "" || c.DB.SSLCert != "") {
+		problems = append(problems, "DB_SSL_ROOT_CERT, DB_SSL_CERT and DB_SSL_KEY require DB_SSL_MODE other than disable")
+	}
+	var unknown []string
+	for key := range file {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		problems = append(problems, fmt.Sprintf("%s: unknown key %q", os.Getenv("CONFIG_FILE"), key))
+	}
+
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return c, nil
 }
 
+// DatabaseURL returns the lib/pq connection URL of the primary database, including the
+// TLS parameters (sslmode, sslrootcert, sslcert, sslkey) and the search_path.
 func (c *Config) DatabaseURL() string {
 	u := &url.URL{
 		Scheme: "postgres",
@@ -67,24 +242,60 @@ func (c *Config) DatabaseURL() string {
 
 	q := u.Query()
 	q.Set("sslmode", c.DB.SSLMode)
-	q.Set("search_path", c.DB.Schema)
+	if c.DB.SSLRootCert != "" {
+		q.Set("sslrootcert", c.DB.SSLRootCert)
+	}
+	if c.DB.SSLCert != "" {
+		q.Set("sslcert", c.DB.SSLCert)
+		q.Set("sslkey", c.DB.SSLKey)
+	}
+	if schemas := c.SearchPath(); len(schemas) > 0 {
+		q.Set("search_path", strings.Join(schemas, ","))
+	}
 	u.RawQuery = q.Encode()
 
 	return u.String()
 }
 
-func getEnv(key string) string {
-	value, exists := os.LookupEnv(key)
-	if !exists {
-		panic(fmt.Sprintf("❌ Missing required environment variable: %s", key))
+// Maintenance returns the parsed MAINTENANCE_WINDOW.
+func (c *Config) Maintenance() (*maintenance.Window, error) {
+	loc, err := time.LoadLocation(c.HTTP.MaintenanceTimezone)
+	if err != nil {
+		return nil, err
+	}
+	return maintenance.Parse(c.HTTP.MaintenanceWindow, loc)
+}
+
+// SearchPath returns the schemas of DB_SCHEMA in order.
+func (c *Config) SearchPath() []string {
+	var schemas []string
+	for _, s := range strings.Split(c.DB.Schema, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			schemas = append(schemas, s)
+		}
 	}
-	return value
+	return schemas
 }
 
-func getEnvOrDefault(key, defaultValue string) string {
-	value, exists := os.LookupEnv(key)
-	if !exists {
-		return defaultValue
+// DBTargets parses DB_TARGETS ("bu1=postgres://...,bu2=postgres://...").
+func (c *Config) DBTargets() ([]DBTarget, error) {
+	var targets []DBTarget
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(c.DB.Targets, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, dsn, ok := strings.Cut(entry, "=")
+		name, dsn = strings.TrimSpace(name), strings.TrimSpace(dsn)
+		if !ok || name == "" || dsn == "" {
+			return nil, fmt.Errorf("invalid DB_TARGETS entry %q: expected name=dsn", entry)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate DB_TARGETS name %q", name)
+		}
+		seen[name] = true
+		targets = append(targets, DBTarget{Name: name, DSN: dsn})
 	}
-	return value
+	return targets, nil
 }
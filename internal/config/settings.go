@@ -0,0 +1,369 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"agent-service-prototype/pkg/rbac"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationError lists every configuration problem found by Load.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// identRe matches the unquoted schema and table names accepted in configuration.
+var identRe = regexp.MustCompile(`^[a-z_][a-z0-9_$]{0,62}$`)
+
+// sha256Re matches a hex SHA-256 digest.
+var sha256Re = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// sslModes are the DB_SSL_MODE values lib/pq supports.
+var sslModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// logFormats and logLevels are the LOG_FORMAT and LOG_LEVEL values pkg/logger supports.
+var (
+	logFormats = []string{"json", "console"}
+	logLevels  = []string{"trace", "debug", "info", "warn", "error"}
+)
+
+// traceExporters are the TRACING_EXPORTER values pkg/tracing supports.
+var traceExporters = []string{"none", "otlp", "stdout", "file"}
+
+// setting binds one config field to its environment variable and its config-file key
+// (section.name). Empty values leave the field at its zero value.
+type setting struct {
+	env      string
+	key      string
+	def      string
+	required bool
+	parse    func(string) error
+}
+
+func (c *Config) settings() []setting {
+	h, d := c.HTTP, c.DB
+	return []setting{
+		{"APP_ENV", "http.env", "", false, str(&h.Env)},
+		{"APP_PORT", "http.port", "", true, port(&h.Port)},
+		{"BUNDLE_URL", "http.bundle_url", "", false, str(&h.BundleURL)},
+		{"WORK_DIR", "http.work_dir", "./.work", true, str(&h.WorkDir)},
+		{"ADVISORY_LOCK_KEY", "http.advisory_lock_key", "987654321", true, lockKey(&h.AdvisoryLockKey)},
+		{"FORCE", "http.force", "false", false, boolean(&h.Force)},
+		{"SKIP_SMOKE", "http.skip_smoke", "false", false, boolean(&h.SkipSmoke)},
+		{"BUNDLE_RETENTION_COUNT", "http.bundle_retention_count", "5", false, integer(&h.BundleRetentionCount, 0)},
+		{"BUNDLE_RETENTION_DAYS", "http.bundle_retention_days", "0", false, integer(&h.BundleRetentionDays, 0)},
+		{"BACKUP_BEFORE_MIGRATE", "http.backup_before_migrate", "false", false, boolean(&h.BackupBeforeMigrate)},
+		{"INSTALL_CONCURRENCY", "http.install_concurrency", "4", true, integer(&h.InstallConcurrency, 1)},
+		{"LOCK_TIMEOUT", "http.lock_timeout", "5m", true, duration(&h.LockTimeout, time.Nanosecond)},
+		{"APPLICATION_NAME", "http.application_name", "agent-installer", true, str(&h.ApplicationName)},
+		{"SHUTDOWN_TIMEOUT", "http.shutdown_timeout", "30s", false, duration(&h.ShutdownTimeout, 0)},
+		{"INTERACTIVE_SHUTDOWN", "http.interactive_shutdown", "false", false, boolean(&h.InteractiveShutdown)},
+		{"REQUIRED_SCHEMA_VERSION", "http.required_schema_version", "", false, str(&h.RequiredSchemaVersion)},
+		{"REQUIRED_SCHEMA_APP", "http.required_schema_app", "", false, str(&h.RequiredSchemaApp)},
+		{"TRACING_EXPORTER", "http.tracing_exporter", "none", false, oneOf(&h.TracingExporter, traceExporters)},
+		{"TRACING_FILE", "http.tracing_file", "", false, str(&h.TracingFile)},
+		{"LOG_FORMAT", "http.log_format", "console", false, oneOf(&h.LogFormat, logFormats)},
+		{"LOG_LEVEL", "http.log_level", "info", false, oneOf(&h.LogLevel, logLevels)},
+		{"AUTO_INSTALL", "http.auto_install", "false", false, boolean(&h.AutoInstall)},
+		{"BUNDLE_POLL_INTERVAL", "http.bundle_poll_interval", "0", false, duration(&h.BundlePollInterval, 0)},
+		{"MAINTENANCE_WINDOW", "http.maintenance_window", "", false, str(&h.MaintenanceWindow)},
+		{"MAINTENANCE_TIMEZONE", "http.maintenance_timezone", "UTC", false, timezone(&h.MaintenanceTimezone)},
+		{"AUTH_API_KEYS", "http.auth_api_keys", "", false, apiKeys(&h.AuthAPIKeys)},
+		{"AUTH_API_KEYS_FILE", "http.auth_api_keys_file", "", false, file(&h.AuthAPIKeysFile)},
+		{"AUTH_JWKS_FILE", "http.auth_jwks_file", "", false, file(&h.AuthJWKSFile)},
+		{"AUTH_JWT_ISSUER", "http.auth_jwt_issuer", "", false, str(&h.AuthJWTIssuer)},
+		{"AUTH_JWT_AUDIENCE", "http.auth_jwt_audience", "", false, str(&h.AuthJWTAudience)},
+		{"AUTH_JWT_ROLE_CLAIM", "http.auth_jwt_role_claim", "role", true, str(&h.AuthJWTRoleClaim)},
+		{"AUTH_DEFAULT_ROLE", "http.auth_default_role", "viewer", true, role(&h.AuthDefaultRole)},
+		{"AUTH_DISABLED", "http.auth_disabled", "false", false, boolean(&h.AuthDisabled)},
+		{"METRICS_PUBLIC", "http.metrics_public", "false", false, boolean(&h.MetricsPublic)},
+		{"AUTH_SESSIONS", "http.auth_sessions", "false", false, boolean(&h.AuthSessions)},
+		{"SESSION_TTL", "http.session_ttl", "12h", true, duration(&h.SessionTTL, time.Minute)},
+		{"SESSION_IDLE_TIMEOUT", "http.session_idle_timeout", "30m", false, duration(&h.SessionIdleTimeout, 0)},
+		{"SESSION_ROTATE_INTERVAL", "http.session_rotate_interval", "15m", false, duration(&h.SessionRotateInterval, 0)},
+		{"SESSION_COOKIE_SECURE", "http.session_cookie_secure", "true", false, boolean(&h.SessionCookieSecure)},
+		{"TLS_CERT_FILE", "http.tls_cert_file", "", false, file(&h.TLSCertFile)},
+		{"TLS_KEY_FILE", "http.tls_key_file", "", false, file(&h.TLSKeyFile)},
+		{"AUDIT_HMAC_KEY", "http.audit_hmac_key", "", false, secret(&h.AuditHMACKey, 32)},
+
+		{"DB_HOST", "db.host", "", true, str(&d.Host)},
+		{"DB_PORT", "db.port", "5432", true, port(&d.Port)},
+		{"DB_USER", "db.user", "", true, str(&d.User)},
+		{"DB_PASSWORD", "db.password", "", false, str(&d.Password)},
+		{"DB_NAME", "db.name", "", true, str(&d.Name)},
+		{"DB_SSL_MODE", "db.ssl_mode", "require", true, oneOf(&d.SSLMode, sslModes)},
+		{"DB_SSL_ROOT_CERT", "db.ssl_root_cert", "", false, file(&d.SSLRootCert)},
+		{"DB_SSL_CERT", "db.ssl_cert", "", false, file(&d.SSLCert)},
+		{"DB_SSL_KEY", "db.ssl_key", "", false, file(&d.SSLKey)},
+		{"DB_SCHEMA", "db.schema", "public", false, schemaList(&d.Schema)},
+		{"DB_TARGETS", "db.targets", "", false, c.targets},
+		{"META_SCHEMA", "db.meta_schema", "hris_meta", true, ident(&d.MetaSchema)},
+		{"META_TABLE", "db.meta_table", "schema_migrations", true, ident(&d.MetaTable)},
+		{"AUDIT_SCHEMA", "db.audit_schema", "hris", true, ident(&d.AuditSchema)},
+		{"DB_MAX_OPEN_CONNS", "db.max_open_conns", "25", true, integer(&d.MaxOpenConns, 1)},
+		{"DB_MAX_IDLE_CONNS", "db.max_idle_conns", "5", true, integer(&d.MaxIdleConns, 0)},
+		{"DB_CONN_MAX_LIFETIME", "db.conn_max_lifetime", "30m", false, duration(&d.ConnMaxLifetime, 0)},
+		{"DB_CONN_MAX_IDLE_TIME", "db.conn_max_idle_time", "5m", false, duration(&d.ConnMaxIdleTime, 0)},
+		{"DB_STARTUP_TIMEOUT", "db.startup_timeout", "30s", false, duration(&d.StartupTimeout, 0)},
+	}
+}
+
+func str(dst *string) func(string) error {
+	return func(v string) error {
+		*dst = v
+		return nil
+	}
+}
+
+func boolean(dst *bool) func(string) error {
+	return func(v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", v)
+		}
+		*dst = b
+		return nil
+	}
+}
+
+// integer parses an int of at least min.
+func integer(dst *int, min int) func(string) error {
+	return func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", v)
+		}
+		if n < min {
+			return fmt.Errorf("must be at least %d, got %d", min, n)
+		}
+		*dst = n
+		return nil
+	}
+}
+
+// lockKey parses a non-zero 64-bit advisory lock key.
+func lockKey(dst *int64) func(string) error {
+	return func(v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a 64-bit integer", v)
+		}
+		if n == 0 {
+			return fmt.Errorf("must not be 0")
+		}
+		*dst = n
+		return nil
+	}
+}
+
+// duration parses a Go duration of at least min.
+func duration(dst *time.Duration, min time.Duration) func(string) error {
+	return func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration (e.g. 30s, 5m)", v)
+		}
+		if d < min {
+			if min == time.Nanosecond {
+				return fmt.Errorf("must be positive, got %s", d)
+			}
+			return fmt.Errorf("must be at least %s, got %s", min, d)
+		}
+		*dst = d
+		return nil
+	}
+}
+
+func port(dst *string) func(string) error {
+	return func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("%q is not a port number", v)
+		}
+		*dst = v
+		return nil
+	}
+}
+
+// timezone parses an IANA time zone name (e.g. Asia/Jakarta).
+func timezone(dst *string) func(string) error {
+	return func(v string) error {
+		if _, err := time.LoadLocation(v); err != nil {
+			return fmt.Errorf("%q is not a time zone (e.g. UTC, Asia/Jakarta)", v)
+		}
+		*dst = v
+		return nil
+	}
+}
+
+// apiKeys parses comma-separated name[:role]=sha256 API key entries (the hex SHA-256 of each key).
+func apiKeys(dst *string) func(string) error {
+	return func(v string) error {
+		for _, entry := range strings.Split(v, ",") {
+			name, hash, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			name, role, hasRole := strings.Cut(name, ":")
+			if !ok || name == "" || !sha256Re.MatchString(hash) {
+				return fmt.Errorf("invalid entry %q: expected name[:role]=<sha256 hex of the key>", entry)
+			}
+			if _, err := rbac.Parse(role); hasRole && err != nil {
+				return fmt.Errorf("API key %q: %w", name, err)
+			}
+		}
+		*dst = v
+		return nil
+	}
+}
+
+// role parses a role name of pkg/rbac.
+func role(dst *string) func(string) error {
+	return func(v string) error {
+		r, err := rbac.Parse(v)
+		if err != nil {
+			return err
+		}
+		*dst = string(r)
+		return nil
+	}
+}
+
+// file stores the path of an existing regular file.
+func file(dst *string) func(string) error {
+	return func(v string) error {
+		info, err := os.Stat(v)
+		if err != nil {
+			return fmt.Errorf("%q: %v", v, errors.Unwrap(err))
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%q is not a regular file", v)
+		}
+		*dst = v
+		return nil
+	}
+}
+
+// secret parses a key of at least minLen bytes.
+func secret(dst *string, minLen int) func(string) error {
+	return func(v string) error {
+		if len(v) < minLen {
+			return fmt.Errorf("must be at least %d bytes", minLen)
+		}
+		*dst = v
+		return nil
+	}
+}
+
+func ident(dst *string) func(string) error {
+	return func(v string) error {
+		if !identRe.MatchString(v) {
+			return fmt.Errorf("%q is not a valid name (lower-case letters, digits and _)", v)
+		}
+		*dst = v
+		return nil
+	}
+}
+
+// schemaList parses a comma-separated list of schema names.
+func schemaList(dst *string) func(string) error {
+	return func(v string) error {
+		for _, schema := range strings.Split(v, ",") {
+			if schema = strings.TrimSpace(schema); !identRe.MatchString(schema) {
+				return fmt.Errorf("%q is not a valid schema name", schema)
+			}
+		}
+		*dst = v
+		return nil
+	}
+}
+
+// targets stores DB_TARGETS after checking it parses.
+func (c *Config) targets(v string) error {
+	prev := c.DB.Targets
+	c.DB.Targets = v
+	if _, err := c.DBTargets(); err != nil {
+		c.DB.Targets = prev
+		return err
+	}
+	return nil
+}
+
+func oneOf(dst *string, allowed []string) func(string) error {
+	return func(v string) error {
+		for _, a := range allowed {
+			if v == a {
+				*dst = v
+				return nil
+			}
+		}
+		return fmt.Errorf("%q must be one of %s", v, strings.Join(allowed, ", "))
+	}
+}
+
+// loadFile reads a YAML (.yaml, .yml) or TOML (.toml) config file into section.name keys.
+// db.targets may be given as a name -> dsn table instead of a name=dsn list.
+func loadFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	raw := map[string]any{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported extension %q (use .yaml, .yml or .toml)", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	for section, v := range raw {
+		fields, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("config file %s: %q must be a section (http or db)", path, section)
+		}
+		for name, fv := range fields {
+			key := section + "." + name
+			if targets, ok := fv.(map[string]any); ok && key == "db.targets" {
+				fv = targetList(targets)
+			}
+			switch fv.(type) {
+			case map[string]any, []any:
+				return nil, fmt.Errorf("config file %s: %s must be a single value", path, key)
+			}
+			values[key] = fmt.Sprint(fv)
+		}
+	}
+	return values, nil
+}
+
+// targetList formats a name -> dsn table as DB_TARGETS.
+func targetList(targets map[string]any) string {
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries := make([]string, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, fmt.Sprintf("%s=%v", name, targets[name]))
+	}
+	return strings.Join(entries, ",")
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"agent-service-prototype/pkg/rbac"
+)
+
+// HeaderAPIKey carries a static API key.
+const HeaderAPIKey = "X-API-Key"
+
+// APIKeyAuth authenticates static API keys sent in X-API-Key. Only the SHA-256 of each key is
+// configured, so the keys themselves are never stored.
+type APIKeyAuth struct {
+	// hashes maps the hex SHA-256 of a key to its principal.
+	hashes      map[string]Principal
+	defaultRole rbac.Role
+}
+
+// NewAPIKeyAuth loads name[:role]=sha256 entries from the comma-separated list keys and from
+// file (one entry per line; blank lines and lines starting with # are ignored). Keys without
+// a role get defaultRole.
+func NewAPIKeyAuth(keys, file string, defaultRole rbac.Role) (*APIKeyAuth, error) {
+	a := &APIKeyAuth{hashes: make(map[string]Principal), defaultRole: defaultRole}
+	for _, entry := range strings.Split(keys, ",") {
+		if err := a.add(entry); err != nil {
+			return nil, fmt.Errorf("AUTH_API_KEYS: %w", err)
+		}
+	}
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read API keys file: %w", err)
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for line := 1; scanner.Scan(); line++ {
+			if text := strings.TrimSpace(scanner.Text()); !strings.HasPrefix(text, "#") {
+				if err := a.add(text); err != nil {
+					return nil, fmt.Errorf("%s:%d: %w", file, line, err)
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read API keys file: %w", err)
+		}
+	}
+	if len(a.hashes) == 0 {
+		return nil, errors.New("no API keys configured")
+	}
+	return a, nil
+}
+
+// ParseAPIKey splits a name[:role]=sha256 entry and validates the role and hash. role is
+// empty when the entry has none.
+func ParseAPIKey(entry string) (name string, role rbac.Role, hash string, err error) {
+	name, hash, ok := strings.Cut(entry, "=")
+	name, hash = strings.TrimSpace(name), strings.ToLower(strings.TrimSpace(hash))
+	if !ok || name == "" {
+		return "", "", "", fmt.Errorf("invalid API key entry %q: expected name[:role]=sha256", entry)
+	}
+	if n, r, ok := strings.Cut(name, ":"); ok {
+		if role, err = rbac.Parse(r); err != nil {
+			return "", "", "", fmt.Errorf("API key %q: %w", n, err)
+		}
+		name = n
+	}
+	if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size {
+		return "", "", "", fmt.Errorf("API key %q: hash must be 64 hex characters (SHA-256 of the key)", name)
+	}
+	return name, role, hash, nil
+}
+
+func (a *APIKeyAuth) add(entry string) error {
+	if strings.TrimSpace(entry) == "" {
+		return nil
+	}
+	name, role, hash, err := ParseAPIKey(entry)
+	if err != nil {
+		return err
+	}
+	if prev, ok := a.hashes[hash]; ok {
+		return fmt.Errorf("API keys %q and %q have the same hash", prev.Subject, name)
+	}
+	if role == "" {
+		role = a.defaultRole
+	}
+	a.hashes[hash] = Principal{Subject: name, Method: "api_key", Role: role}
+	return nil
+}
+
+func (a *APIKeyAuth) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(HeaderAPIKey)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	sum := sha256.Sum256([]byte(key))
+	presented := hex.EncodeToString(sum[:])
+	for hash, p := range a.hashes {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(presented)) == 1 {
+			return &p, nil
+		}
+	}
+	return nil, errors.New("invalid API key")
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"agent-service-prototype/pkg/rbac"
+)
+
+func keyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestParseAPIKey(t *testing.T) {
+	hash := keyHash("secret")
+	tests := []struct {
+		entry string
+		name  string
+		role  rbac.Role
+		ok    bool
+	}{
+		{"ci=" + hash, "ci", "", true},
+		{" ci:operator = " + hash, "ci", rbac.RoleOperator, true},
+		{"ci:ADMIN=" + strings.ToUpper(hash), "ci", rbac.RoleAdmin, true},
+		{"ci:root=" + hash, "", "", false},
+		{"ci=" + hash[:63], "", "", false},
+		{"ci=" + hash + "00", "", "", false},
+		{"ci=" + strings.Repeat("z", 64), "", "", false},
+		{"=" + hash, "", "", false},
+		{"ci", "", "", false},
+	}
+	for _, tt := range tests {
+		name, role, got, err := ParseAPIKey(tt.entry)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseAPIKey(%q) error = %v, want ok=%v", tt.entry, err, tt.ok)
+			continue
+		}
+		if tt.ok && (name != tt.name || role != tt.role || got != hash) {
+			t.Errorf("ParseAPIKey(%q) = %q, %q, %q, want %q, %q, %q", tt.entry, name, role, got, tt.name, tt.role, hash)
+		}
+	}
+}
+
+func TestNewAPIKeyAuth(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys")
+	content := "# deploy keys\n\nfile-key:admin=" + keyHash("from-file") + "\n"
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		keys string
+		file string
+		ok   bool
+	}{
+		{"list", "a=" + keyHash("a") + ",b:operator=" + keyHash("b"), "", true},
+		{"file", "", file, true},
+		{"list and file", "a=" + keyHash("a"), file, true},
+		{"none", "", "", false},
+		{"duplicate hash", "a=" + keyHash("a") + ",b=" + keyHash("a"), "", false},
+		{"invalid entry", "a=nothex", "", false},
+		{"missing file", "", filepath.Join(t.TempDir(), "missing"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewAPIKeyAuth(tt.keys, tt.file, rbac.RoleViewer)
+			if (err == nil) != tt.ok {
+				t.Errorf("NewAPIKeyAuth error = %v, want ok=%v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuthenticate(t *testing.T) {
+	auth, err := NewAPIKeyAuth("ci:operator="+keyHash("ci-key")+",reader="+keyHash("reader-key"), "", rbac.RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		subject string
+		role    rbac.Role
+		err     error
+	}{
+		{"key with role", "ci-key", "ci", rbac.RoleOperator, nil},
+		{"key without role gets the default", "reader-key", "reader", rbac.RoleViewer, nil},
+		{"no header", "", "", "", ErrNoCredentials},
+		{"unknown key", "other-key", "", "", errors.New("invalid API key")},
+		{"hash instead of key", keyHash("ci-key"), "", "", errors.New("invalid API key")},
+		{"key with trailing space", "ci-key ", "", "", errors.New("invalid API key")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/setup/status", nil)
+			if tt.key != "" {
+				req.Header.Set(HeaderAPIKey, tt.key)
+			}
+			p, err := auth.Authenticate(req)
+			switch {
+			case tt.err == nil && err != nil:
+				t.Fatalf("Authenticate error = %v", err)
+			case tt.err != nil && (err == nil || err.Error() != tt.err.Error()):
+				t.Fatalf("Authenticate error = %v, want %v", err, tt.err)
+			case tt.err == nil && (p.Subject != tt.subject || p.Role != tt.role || p.Method != "api_key"):
+				t.Errorf("Authenticate = %+v, want subject %q role %q", p, tt.subject, tt.role)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"agent-service-prototype/internal/config"
+	"agent-service-prototype/pkg/logger"
+	"agent-service-prototype/pkg/rbac"
+	"agent-service-prototype/pkg/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request carries no credentials
+// it understands, so the next Authenticator is tried.
+var ErrNoCredentials = errors.New("no credentials")
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	// Subject names the caller: the API key name, the JWT sub claim or the session user.
+	Subject string
+	// Method is the authentication method: "api_key", "jwt", "session", or "none" when
+	// AUTH_DISABLED is set.
+	Method string
+	// Role decides which setup actions the caller may perform (see RequirePermission).
+	Role rbac.Role
+}
+
+// Authenticator verifies the credentials of a request.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Authenticators tries each Authenticator in order; the first one that finds credentials
+// decides.
+type Authenticators []Authenticator
+
+func (as Authenticators) Authenticate(r *http.Request) (*Principal, error) {
+	for _, a := range as {
+		p, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return p, err
+	}
+	return nil, ErrNoCredentials
+}
+
+// NewAuthenticator builds the authenticators configured by AUTH_API_KEYS, AUTH_API_KEYS_FILE
+// and AUTH_JWKS_FILE. It returns nil when AUTH_DISABLED is set.
+func NewAuthenticator(cfg *config.Config) (Authenticator, error) {
+	if cfg.HTTP.AuthDisabled {
+		return nil, nil
+	}
+	defaultRole, err := rbac.Parse(cfg.HTTP.AuthDefaultRole)
+	if err != nil {
+		return nil, err
+	}
+	var auth Authenticators
+	if cfg.HTTP.AuthAPIKeys != "" || cfg.HTTP.AuthAPIKeysFile != "" {
+		keys, err := NewAPIKeyAuth(cfg.HTTP.AuthAPIKeys, cfg.HTTP.AuthAPIKeysFile, defaultRole)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, keys)
+	}
+	if cfg.HTTP.AuthJWKSFile != "" {
+		jwt, err := NewJWTAuth(cfg.HTTP.AuthJWKSFile, cfg.HTTP.AuthJWTIssuer, cfg.HTTP.AuthJWTAudience, cfg.HTTP.AuthJWTRoleClaim, defaultRole)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, jwt)
+	}
+	return auth, nil
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the caller authenticated by AuthMiddleware, or nil.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// AuthMiddleware rejects requests that auth does not authenticate with 401. The principal is
+// stored in the request context (PrincipalFromContext) and logged as "principal". Requests
+// already authenticated by SessionMiddleware pass. A nil auth lets every request through
+// (AUTH_DISABLED) as an admin named by the X-User-ID header.
+func AuthMiddleware(auth Authenticator) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if PrincipalFromContext(req.Context()) != nil {
+				return next(c)
+			}
+			if auth == nil {
+				user := req.Header.Get("X-User-ID")
+				logger.Warn().Ctx(req.Context()).Str("route", c.Path()).Str("remote_ip", c.RealIP()).Str("user", user).
+					Msg("⚠️  AUTH_DISABLED: unauthenticated request granted the admin role")
+				setPrincipal(c, &Principal{Subject: user, Method: "none", Role: rbac.RoleAdmin})
+				return next(c)
+			}
+			p, err := auth.Authenticate(req)
+			if err != nil {
+				reason := err.Error()
+				if errors.Is(err, ErrNoCredentials) {
+					reason = "missing X-API-Key header, bearer token or session cookie"
+				}
+				logger.Warn().Ctx(req.Context()).Str("route", c.Path()).Str("remote_ip", c.RealIP()).Str("reason", reason).Msg("Authentication failed")
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="agent"`)
+				return utils.ErrorResponse(c, http.StatusUnauthorized, "Authentication required", reason, "UNAUTHORIZED")
+			}
+			setPrincipal(c, p)
+			return next(c)
+		}
+	}
+}
+
+// setPrincipal stores p in the request context and adds it to the request's log fields.
+func setPrincipal(c echo.Context, p *Principal) {
+	req := c.Request()
+	ctx := context.WithValue(req.Context(), principalKey{}, p)
+	c.SetRequest(req.WithContext(logger.WithFields(ctx, "principal", p.Subject, "role", string(p.Role))))
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"agent-service-prototype/pkg/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RequireInstallation answers 503 for requests under prefix until installed reports that the
+// startup installation has succeeded, so business routes never run against an old schema.
+// Other paths (probes, /setup, /auth, /metrics) are served throughout.
+func RequireInstallation(installed func() bool, prefix string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if strings.HasPrefix(c.Request().URL.Path, prefix) && !installed() {
+				return utils.ErrorResponse(c, http.StatusServiceUnavailable, "Installation pending",
+					"the AUTO_INSTALL startup installation has not succeeded yet", "NOT_INSTALLED")
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"agent-service-prototype/pkg/logger"
+	"agent-service-prototype/pkg/rbac"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// jwtLeeway tolerates clock skew when checking exp, nbf and iat.
+const jwtLeeway = 30 * time.Second
+
+// jwtMethods are the accepted signing algorithms; HMAC is not, since JWKS holds public keys.
+var jwtMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// JWTAuth authenticates bearer JWTs signed by a key of a local JWKS file. The file is read
+// again when a token names an unknown kid and the file has changed, so keys can be rotated
+// without a restart. The caller's role is read from the role claim.
+type JWTAuth struct {
+	file        string
+	issuer      string
+	audience    string
+	roleClaim   string
+	defaultRole rbac.Role
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	modTime time.Time
+}
+
+// NewJWTAuth loads the JWKS file. Tokens must carry issuer and audience, which are required:
+// without them any token signed by a JWKS key, including one issued for another service, would
+// be accepted. Tokens without a known role in roleClaim get defaultRole.
+func NewJWTAuth(file, issuer, audience, roleClaim string, defaultRole rbac.Role) (*JWTAuth, error) {
+	if issuer == "" || audience == "" {
+		return nil, errors.New("AUTH_JWKS_FILE requires AUTH_JWT_ISSUER and AUTH_JWT_AUDIENCE")
+	}
+	a := &JWTAuth{file: file, issuer: issuer, audience: audience, roleClaim: roleClaim, defaultRole: defaultRole}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *JWTAuth) Authenticate(r *http.Request) (*Principal, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get(echo.HeaderAuthorization), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrNoCredentials
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(jwtMethods), jwt.WithLeeway(jwtLeeway), jwt.WithExpirationRequired(),
+		jwt.WithIssuer(a.issuer), jwt.WithAudience(a.audience),
+	}
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(strings.TrimSpace(token), claims, a.key, opts...); err != nil {
+		return nil, fmt.Errorf("invalid bearer token: %w", err)
+	}
+	sub, err := claims.GetSubject()
+	if err != nil || sub == "" {
+		return nil, errors.New("invalid bearer token: no sub claim")
+	}
+	return &Principal{Subject: sub, Method: "jwt", Role: a.role(claims[a.roleClaim])}, nil
+}
+
+// role maps the role claim, a string or an array of strings, to the most privileged known
+// role it names.
+func (a *JWTAuth) role(claim any) rbac.Role {
+	var names []string
+	switch v := claim.(type) {
+	case string:
+		names = []string{v}
+	case []any:
+		for _, n := range v {
+			if s, ok := n.(string); ok {
+				names = append(names, s)
+			}
+		}
+	}
+	if r := rbac.Highest(names); r != "" {
+		return r
+	}
+	return a.defaultRole
+}
+
+// key returns the JWKS key named by the token's kid, reloading the file once on a miss.
+func (a *JWTAuth) key(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no kid header")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if k, ok := a.keys[kid]; ok {
+		return k, nil
+	}
+	if info, err := os.Stat(a.file); err == nil && !info.ModTime().Equal(a.modTime) {
+		if err := a.loadLocked(); err != nil {
+			logger.Error().Err(err).Str("file", a.file).Msg("Failed to reload JWKS, keeping the current keys")
+		} else {
+			logger.Info().Str("file", a.file).Int("keys", len(a.keys)).Msg("JWKS reloaded")
+		}
+	}
+	if k, ok := a.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown kid %q", kid)
+}
+
+func (a *JWTAuth) load() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.loadLocked()
+}
+
+func (a *JWTAuth) loadLocked() error {
+	info, err := os.Stat(a.file)
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS: %w", err)
+	}
+	data, err := os.ReadFile(a.file)
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS: %w", err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("JWKS %s: %w", a.file, err)
+	}
+	a.keys, a.modTime = keys, info.ModTime()
+	return nil
+}
+
+// jwk is one public key of a JWKS (RFC 7517): RSA (n, e), EC (crv, x, y) or OKP Ed25519 (x).
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS returns the signing keys of a JWKS document by kid.
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if k.Kid == "" {
+			return nil, fmt.Errorf("key %d has no kid", i)
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = pub
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := b64Int(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("n: %w", err)
+		}
+		e, err := b64Int(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid e")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil {
+			return nil, errors.New("invalid x or y")
+		}
+		// JWK coordinates have the fixed length of the curve, as in an uncompressed point.
+		pub, err := ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, err
+		}
+		return pub, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid x")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported kty %q", k.Kty)
+	}
+}
+
+func b64Int(v string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid base64url value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"agent-service-prototype/pkg/rbac"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	testIssuer   = "https://idp.example.com"
+	testAudience = "agent-service"
+)
+
+// newTestJWKS writes a JWKS holding one P-256 key with kid "k1" and returns its path and
+// the private key.
+func newTestJWKS(t *testing.T) (string, *ecdsa.PrivateKey) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt, err := priv.PublicKey.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	data, _ := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "EC", "kid": "k1", "use": "sig", "crv": "P-256", "x": b64(pt[1:33]), "y": b64(pt[33:]),
+	}}})
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file, priv
+}
+
+func TestNewJWTAuthRequiresIssuerAndAudience(t *testing.T) {
+	file, _ := newTestJWKS(t)
+	tests := []struct {
+		name, issuer, audience string
+		ok                     bool
+	}{
+		{"both", testIssuer, testAudience, true},
+		{"no issuer", "", testAudience, false},
+		{"no audience", testIssuer, "", false},
+		{"neither", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewJWTAuth(file, tt.issuer, tt.audience, "role", rbac.RoleViewer)
+			if (err == nil) != tt.ok {
+				t.Errorf("NewJWTAuth error = %v, want ok=%v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	file, priv := newTestJWKS(t)
+	auth, err := NewJWTAuth(file, testIssuer, testAudience, "role", rbac.RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub": "alice", "iss": testIssuer, "aud": testAudience,
+			"iat": now.Unix(), "exp": now.Add(time.Hour).Unix(),
+		}
+	}
+	with := func(key string, value any) jwt.MapClaims {
+		c := valid()
+		if value == nil {
+			delete(c, key)
+		} else {
+			c[key] = value
+		}
+		return c
+	}
+	es256 := func(claims jwt.MapClaims, kid string) string {
+		tok := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		if kid != "" {
+			tok.Header["kid"] = kid
+		}
+		s, err := tok.SignedString(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	hs256 := func() string {
+		tok := jwt.NewWithClaims(jwt.SigningMethodHS256, valid())
+		tok.Header["kid"] = "k1"
+		s, err := tok.SignedString([]byte("shared-secret-shared-secret-0123"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	none := func() string {
+		tok := jwt.NewWithClaims(jwt.SigningMethodNone, valid())
+		tok.Header["kid"] = "k1"
+		s, err := tok.SignedString(jwt.UnsafeAllowNoneSignatureType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	tampered := func() string {
+		parts := strings.Split(es256(valid(), "k1"), ".")
+		payload, _ := json.Marshal(with("sub", "mallory"))
+		parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+		return strings.Join(parts, ".")
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		role   rbac.Role
+		ok     bool
+	}{
+		{"valid", "Bearer " + es256(valid(), "k1"), rbac.RoleViewer, true},
+		{"lower-case scheme", "bearer " + es256(valid(), "k1"), rbac.RoleViewer, true},
+		{"audience list", "Bearer " + es256(with("aud", []string{"other", testAudience}), "k1"), rbac.RoleViewer, true},
+		{"role claim", "Bearer " + es256(with("role", "operator"), "k1"), rbac.RoleOperator, true},
+		{"role claim list", "Bearer " + es256(with("role", []string{"viewer", "admin", "unknown"}), "k1"), rbac.RoleAdmin, true},
+		{"unknown role", "Bearer " + es256(with("role", "root"), "k1"), rbac.RoleViewer, true},
+		{"expired within leeway", "Bearer " + es256(with("exp", now.Add(-jwtLeeway/2).Unix()), "k1"), rbac.RoleViewer, true},
+		{"expired", "Bearer " + es256(with("exp", now.Add(-time.Hour).Unix()), "k1"), "", false},
+		{"no exp", "Bearer " + es256(with("exp", nil), "k1"), "", false},
+		{"not yet valid", "Bearer " + es256(with("nbf", now.Add(time.Hour).Unix()), "k1"), "", false},
+		{"wrong audience", "Bearer " + es256(with("aud", "other-service"), "k1"), "", false},
+		{"no audience", "Bearer " + es256(with("aud", nil), "k1"), "", false},
+		{"wrong issuer", "Bearer " + es256(with("iss", "https://evil.example.com"), "k1"), "", false},
+		{"no issuer", "Bearer " + es256(with("iss", nil), "k1"), "", false},
+		{"no sub", "Bearer " + es256(with("sub", nil), "k1"), "", false},
+		{"no kid", "Bearer " + es256(valid(), ""), "", false},
+		{"unknown kid", "Bearer " + es256(valid(), "k2"), "", false},
+		{"alg none", "Bearer " + none(), "", false},
+		{"alg HS256", "Bearer " + hs256(), "", false},
+		{"tampered payload", "Bearer " + tampered(), "", false},
+		{"garbage", "Bearer not-a-token", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/setup/status", nil)
+			req.Header.Set(echo.HeaderAuthorization, tt.header)
+			p, err := auth.Authenticate(req)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Authenticate error = %v, want ok=%v", err, tt.ok)
+			}
+			if err == ErrNoCredentials {
+				t.Fatalf("Authenticate returned ErrNoCredentials for a bearer token")
+			}
+			if tt.ok && (p.Subject != "alice" || p.Role != tt.role || p.Method != "jwt") {
+				t.Errorf("Authenticate = %+v, want subject alice role %q", p, tt.role)
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticateNoBearer(t *testing.T) {
+	file, _ := newTestJWKS(t)
+	auth, err := NewJWTAuth(file, testIssuer, testAudience, "role", rbac.RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, header := range []string{"", "Basic YWxpY2U6c2VjcmV0", "Bearer"} {
+		req := httptest.NewRequest("GET", "/setup/status", nil)
+		if header != "" {
+			req.Header.Set(echo.HeaderAuthorization, header)
+		}
+		if _, err := auth.Authenticate(req); err != ErrNoCredentials {
+			t.Errorf("Authenticate with Authorization %q = %v, want ErrNoCredentials", header, err)
+		}
+	}
+}
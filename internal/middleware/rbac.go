@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"agent-service-prototype/pkg/logger"
+	"agent-service-prototype/pkg/rbac"
+	"agent-service-prototype/pkg/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Can reports whether the principal of the request may perform perm.
+func Can(c echo.Context, perm rbac.Permission) bool {
+	p := PrincipalFromContext(c.Request().Context())
+	return p != nil && p.Role.Allows(perm)
+}
+
+// Forbidden answers 403 for a principal lacking perm.
+func Forbidden(c echo.Context, perm rbac.Permission) error {
+	var subject string
+	var role rbac.Role
+	if p := PrincipalFromContext(c.Request().Context()); p != nil {
+		subject, role = p.Subject, p.Role
+	}
+	logger.Warn().Ctx(c.Request().Context()).Str("route", c.Path()).Str("permission", string(perm)).Msg("Permission denied")
+	return utils.ErrorResponse(c, http.StatusForbidden, "Permission denied",
+		fmt.Sprintf("%q (role %q) lacks the %q permission", subject, role, perm), "FORBIDDEN")
+}
+
+// RequirePermission rejects requests whose principal's role does not grant perm with 403.
+// It must run after AuthMiddleware.
+func RequirePermission(perm rbac.Permission) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !Can(c, perm) {
+				return Forbidden(c, perm)
+			}
+			return next(c)
+		}
+	}
+}
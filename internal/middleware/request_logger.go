@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"time"
+
+	"agent-service-prototype/pkg/logger"
+	"agent-service-prototype/pkg/utils"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
+)
+
+// RequestLogger assigns every request an ID and logs one line per request (method, route,
+// status, latency, ...). The ID is taken from the X-Request-ID header when the client sends
+// one, echoed in the response, and carried in the request context so installer logs written
+// with logger.X().Ctx(ctx) include request_id.
+func RequestLogger() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			req := c.Request()
+			id := req.Header.Get(echo.HeaderXRequestID)
+			if id == "" {
+				id = utils.GenerateRandomID(16)
+			}
+			c.Response().Header().Set(echo.HeaderXRequestID, id)
+			c.SetRequest(req.WithContext(logger.WithFields(req.Context(), "request_id", id)))
+
+			err := next(c)
+			if err != nil {
+				// Let Echo write the error response now so the logged status is the one sent.
+				c.Error(err)
+			}
+
+			status := c.Response().Status
+			var event *zerolog.Event
+			switch {
+			case status >= 500:
+				event = logger.Error().Err(err)
+			case status >= 400:
+				event = logger.Warn()
+			default:
+				event = logger.Info()
+			}
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			event.Ctx(c.Request().Context()).
+				Str("method", req.Method).
+				Str("route", route).
+				Str("uri", req.RequestURI).
+				Int("status", status).
+				Dur("latency_ms", time.Since(start)).
+				Str("remote_ip", c.RealIP()).
+				Int64("bytes_out", c.Response().Size).
+				Msg("HTTP request")
+			return nil
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"agent-service-prototype/internal/app/agent-service-prototype/service"
+	"agent-service-prototype/internal/config"
+	"agent-service-prototype/pkg/logger"
+	"agent-service-prototype/pkg/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ContextKeyUser is the Echo context key SessionMiddleware stores the logged-in *Principal under.
+const ContextKeyUser = "user"
+
+// SessionStore resolves session cookies; it is implemented by the setup service.
+type SessionStore interface {
+	ResumeSession(ctx context.Context, id string, client service.SessionClient) (*service.Session, error)
+}
+
+// SessionMiddleware loads the admin user of the nuha_session cookie into the Echo context
+// (ContextKeyUser) and the request context (PrincipalFromContext), so AuthMiddleware accepts
+// the request. A rotated session ID is sent back as a new cookie. Requests without a valid
+// session pass through unauthenticated; an invalid cookie is cleared.
+func SessionMiddleware(store SessionStore, cfg *config.Config) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			cookie, err := c.Cookie(utils.SessionCookieName)
+			if err != nil || cookie.Value == "" {
+				return next(c)
+			}
+			req := c.Request()
+			sess, err := store.ResumeSession(req.Context(), cookie.Value, SessionClientOf(c))
+			if errors.Is(err, service.ErrSessionNotFound) {
+				ClearSessionCookie(c, cfg)
+				return next(c)
+			}
+			if err != nil {
+				logger.Error().Ctx(req.Context()).Err(err).Msg("Failed to load session")
+				return utils.ErrorResponse(c, http.StatusServiceUnavailable, "Failed to load session", err.Error(), "SESSION_UNAVAILABLE")
+			}
+			if sess.Rotated {
+				SetSessionCookie(c, cfg, sess)
+			}
+			p := &Principal{Subject: sess.Username, Method: "session", Role: sess.Role}
+			c.Set(ContextKeyUser, p)
+			setPrincipal(c, p)
+			return next(c)
+		}
+	}
+}
+
+// CurrentUser returns the user SessionMiddleware loaded, or nil.
+func CurrentUser(c echo.Context) *Principal {
+	p, _ := c.Get(ContextKeyUser).(*Principal)
+	return p
+}
+
+// SessionClientOf describes the client of a request for the sessions table.
+func SessionClientOf(c echo.Context) service.SessionClient {
+	return service.SessionClient{IP: c.RealIP(), UserAgent: c.Request().UserAgent()}
+}
+
+// SetSessionCookie sends the session ID as an HttpOnly, SameSite=Strict cookie that expires
+// with the session. Secure is set unless SESSION_COOKIE_SECURE=false.
+func SetSessionCookie(c echo.Context, cfg *config.Config, sess *service.Session) {
+	c.SetCookie(&http.Cookie{
+		Name:     utils.SessionCookieName,
+		Value:    sess.ID,
+		Path:     "/",
+		Expires:  sess.ExpiresAt,
+		MaxAge:   int(time.Until(sess.ExpiresAt).Seconds()),
+		HttpOnly: true,
+		Secure:   cfg.HTTP.SessionCookieSecure,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// ClearSessionCookie tells the client to drop the session cookie.
+func ClearSessionCookie(c echo.Context, cfg *config.Config) {
+	c.SetCookie(&http.Cookie{
+		Name:     utils.SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   cfg.HTTP.SessionCookieSecure,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 
 	"agent-service-prototype/internal/app/agent-service-prototype/routes"
+	"agent-service-prototype/internal/app/agent-service-prototype/service"
 	"agent-service-prototype/internal/config"
 	"agent-service-prototype/pkg/logger"
 
@@ -11,15 +12,17 @@ import (
 	// "google.golang.org/grpc"
 )
 
-// InitRouter initializes all HTTP routes by calling RegisterRoutes for each module
-func InitRouter(e *echo.Echo, cfg *config.Config, db *sql.DB) {
+// InitRouter initializes all HTTP routes by calling RegisterRoutes for each module and
+// returns the setup service (used by the server for AUTO_INSTALL). auth protects the /setup routes.
+func InitRouter(e *echo.Echo, cfg *config.Config, db *sql.DB, auth echo.MiddlewareFunc) *service.Service {
 	logger.Info().Msg("🔗 Initializing HTTP router...")
 
-	// Register setup routes (root-level, no auth)
-	routes.RegisterSetupRoutes(e, cfg, db)
+	// Register setup routes (root-level, authenticated)
+	setup := routes.RegisterSetupRoutes(e, cfg, db, auth)
 
 	// RegisterRoutes commented out — Ent code generation has not been run yet.
 	// routes.RegisterRoutes(api, protectedAPI, client, cfg, db)
 
 	logger.Info().Msg("✅ HTTP router initialization completed")
+	return setup
 }
\ No newline at end of file
@@ -4,35 +4,96 @@ import (
 	"bufio"
 	"context"
 	"database/sql"
+	"errors"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	"agent-service-prototype/internal/app/agent-service-prototype/service"
 	"agent-service-prototype/internal/bootstrap"
 	"agent-service-prototype/internal/config"
+	"agent-service-prototype/internal/middleware"
 	"agent-service-prototype/internal/router"
 	"agent-service-prototype/pkg/logger"
+	"agent-service-prototype/pkg/metrics"
+	"agent-service-prototype/pkg/rbac"
+	"agent-service-prototype/pkg/tracing"
+	"agent-service-prototype/pkg/utils"
 
 	"github.com/labstack/echo/v4"
 	echoMiddleware "github.com/labstack/echo/v4/middleware"
 )
 
 type ServerManager struct {
-	HTTP         *echo.Echo
-	RawDB        *sql.DB
-	cfg          *config.Config
+	HTTP  *echo.Echo
+	RawDB *sql.DB
+	cfg   *config.Config
+	// setup runs the AUTO_INSTALL startup installation and bundle polling.
+	setup *service.Service
+	// stopTracing flushes spans that have not been exported yet.
+	stopTracing func(context.Context) error
+	// stopConnecting stops background database connection attempts.
+	stopConnecting context.CancelFunc
 }
 
 func NewServerManager(cfg *config.Config) (*ServerManager, error) {
-	rawDB := bootstrap.InitDatabase(cfg)
+	// Load the certificate up front so a bad TLS_CERT_FILE/TLS_KEY_FILE fails startup.
+	tlsCfg, err := tlsConfig(cfg.HTTP.TLSCertFile, cfg.HTTP.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := middleware.NewAuthenticator(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if auth == nil {
+		logger.Error().Msg("⚠️  AUTH_DISABLED is set: /setup is NOT authenticated and every request is an admin named by X-User-ID. Never use this outside development.")
+	}
+	if cfg.HTTP.AuditHMACKey == "" {
+		logger.Warn().Msg("⚠️  AUDIT_HMAC_KEY is not set: the audit chain is written with unkeyed SHA-256 links, which anyone with write access to the database can forge. Set a key of at least 32 bytes.")
+	}
+
+	stopTracing, err := tracing.Init(context.Background(), tracing.Config{
+		Exporter:    cfg.HTTP.TracingExporter,
+		File:        cfg.HTTP.TracingFile,
+		ServiceName: utils.ServiceName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Cancelled on shutdown to stop connecting in the background when started in degraded mode.
+	dbCtx, stopConnecting := context.WithCancel(context.Background())
+	rawDB, err := bootstrap.InitDatabase(dbCtx, cfg)
+	if err != nil {
+		stopConnecting()
+		return nil, err
+	}
 
 	e := echo.New()
+	e.TLSServer.TLSConfig = tlsCfg
 
 	// Global middleware
-	e.Use(echoMiddleware.Logger())
+	e.Use(middleware.RequestLogger())
 	e.Use(echoMiddleware.Recover())
 	e.Use(echoMiddleware.CORS())
+	e.Use(tracing.Middleware())
+	e.Use(metrics.Middleware())
+
+	// Prometheus metrics, including the shared pool's sql.DBStats; authenticated like /setup
+	// (status permission) unless METRICS_PUBLIC is set.
+	metrics.RegisterDBStats(rawDB, "primary")
+	if cfg.HTTP.MetricsPublic {
+		logger.Warn().Msg("METRICS_PUBLIC is set, /metrics is not authenticated")
+		e.GET("/metrics", metrics.Handler())
+	} else {
+		e.GET("/metrics", metrics.Handler(), middleware.AuthMiddleware(auth), middleware.RequirePermission(rbac.PermStatus))
+	}
 
 	// Health check
 	e.GET("/health", func(c echo.Context) error {
@@ -42,80 +103,194 @@ func NewServerManager(cfg *config.Config) (*ServerManager, error) {
 	// Create API groups (kept for future use when Ent is generated)
 	// api := e.Group("/api/v1")
 	// protectedAPI := e.Group("/api/v1")
-	// protectedAPI.Use(middleware.AuthMiddleware(cfg))
+	// protectedAPI.Use(middleware.SessionMiddleware(setup, cfg), middleware.AuthMiddleware(auth))
 
 	// Setup HTTP router
-	router.InitRouter(e, cfg, rawDB)
+	setup := router.InitRouter(e, cfg, rawDB, middleware.AuthMiddleware(auth))
+
+	// With AUTO_INSTALL the business routes stay closed until the startup installation succeeds.
+	if cfg.HTTP.AutoInstall {
+		e.Use(middleware.RequireInstallation(setup.Installed, "/api/"))
+	}
 
 	return &ServerManager{
-		HTTP:         e,
-		RawDB:        rawDB,
-		cfg:          cfg,
+		HTTP:           e,
+		RawDB:          rawDB,
+		cfg:            cfg,
+		setup:          setup,
+		stopTracing:    stopTracing,
+		stopConnecting: stopConnecting,
 	}, nil
 }
 
+// shutdownCleanupTimeout is how long cancelled requests get to unwind (roll back, release
+// the advisory lock, record the failure) once the grace period has expired.
+const shutdownCleanupTimeout = 15 * time.Second
+
+// Run serves HTTP until SIGINT or SIGTERM, then shuts down gracefully: new connections are
+// refused and in-flight requests (e.g. an installation) get SHUTDOWN_TIMEOUT to finish.
+// After that, or on a second signal, their contexts are cancelled so installations stop,
+// roll back the running migration and release the advisory lock before the process exits.
+// With INTERACTIVE_SHUTDOWN=true the first signal asks for confirmation on stdin.
+// With AUTO_INSTALL the startup installation runs once the server is listening, so probes
+// and /setup/status answer while it runs; with BUNDLE_POLL_INTERVAL bundle polling runs in the
+// background too. On shutdown a running installation is treated like an in-flight request.
 func (s *ServerManager) Run() error {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	srv, scheme := s.HTTP.Server, "http"
+	if s.HTTP.TLSServer.TLSConfig != nil {
+		srv, scheme = s.HTTP.TLSServer, "https"
+	}
+	srv.Addr = ":" + s.cfg.HTTP.Port
+	srv.BaseContext = func(net.Listener) context.Context { return requestCtx }
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	// Start HTTP server
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Info().Str("port", s.cfg.HTTP.Port).Str("scheme", scheme).Msg("🚀 Starting HTTP server")
+		if err := s.HTTP.StartServer(srv); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error().Err(err).Msg("Failed to start HTTP server")
+			serverErr <- err
+		}
+	}()
+
+	// Background installers: waiting (for the database, for the next poll) is abandoned as soon
+	// as shutdown starts; a running installation gets the grace period like any in-flight request.
+	waitCtx, stopWaiting := context.WithCancel(requestCtx)
+	defer stopWaiting()
+	var background sync.WaitGroup
+	background.Go(func() {
+		if s.setup.WaitForDatabase(waitCtx) != nil {
+			return
+		}
+		if err := s.setup.RefreshSchemaMetrics(waitCtx); err != nil {
+			logger.Warn().Err(err).Msg("Failed to read schema versions for metrics")
+		}
+	})
+	if s.cfg.HTTP.AutoInstall {
+		background.Go(func() {
+			if s.setup.WaitForDatabase(waitCtx) == nil {
+				s.setup.AutoInstall(waitCtx, requestCtx)
+			}
+		})
+	}
+	if s.cfg.HTTP.BundlePollInterval > 0 {
+		background.Go(func() { s.setup.PollBundles(waitCtx, requestCtx) })
+	}
+	backgroundDone := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(backgroundDone)
+	}()
+
+	select {
+	case err := <-serverErr:
+		cancelRequests()
+		<-backgroundDone
+		s.stopConnecting()
+		bootstrap.CloseDatabase(s.RawDB)
+		s.flushTraces()
+		return err
+	case <-s.waitForShutdown(sigChan):
+	}
+	stopWaiting()
+
+	grace := s.cfg.HTTP.ShutdownTimeout
+	logger.Info().Dur("grace_period", grace).Msg("🔄 Shutting down, waiting for in-flight requests")
+	graceCtx, cancelGrace := context.WithTimeout(context.Background(), grace)
+	defer cancelGrace()
+	go func() {
+		select {
+		case <-sigChan:
+			logger.Warn().Msg("⚠️  Second shutdown signal received, cancelling in-flight requests")
+			cancelGrace()
+		case <-graceCtx.Done():
+		}
+	}()
+
+	if err := s.shutdown(graceCtx, backgroundDone); err != nil {
+		logger.Warn().Err(err).Msg("⚠️  In-flight requests did not finish in time, cancelling them")
+		cancelRequests()
+		cleanupCtx, cancelCleanup := context.WithTimeout(context.Background(), shutdownCleanupTimeout)
+		if err := s.shutdown(cleanupCtx, backgroundDone); err != nil {
+			logger.Error().Err(err).Msg("Failed to shutdown servers gracefully")
+		}
+		cancelCleanup()
+	}
+
+	s.stopConnecting()
+	bootstrap.CloseDatabase(s.RawDB)
+	s.flushTraces()
+	logger.Info().Msg("🛑 Servers stopped")
+	return nil
+}
 
-	shutdownConfirmed := make(chan bool, 1)
+// traceFlushTimeout bounds exporting the spans still buffered at exit.
+const traceFlushTimeout = 5 * time.Second
 
+// flushTraces exports buffered spans and stops the tracer provider.
+func (s *ServerManager) flushTraces() {
+	ctx, cancel := context.WithTimeout(context.Background(), traceFlushTimeout)
+	defer cancel()
+	if err := s.stopTracing(ctx); err != nil {
+		logger.Error().Err(err).Msg("Failed to flush traces")
+	}
+}
+
+// waitForShutdown returns a channel that is closed once shutdown should start: on the first
+// signal, or in interactive mode once the operator confirms. If stdin cannot be read (e.g. it
+// is closed in a container) the signal is honoured rather than ignored.
+func (s *ServerManager) waitForShutdown(sigChan <-chan os.Signal) <-chan struct{} {
+	done := make(chan struct{})
+	interactive := s.cfg.HTTP.InteractiveShutdown
 	go func() {
+		defer close(done)
+		reader := bufio.NewReader(os.Stdin)
 		for {
-			<-sigChan
-			logger.Warn().Msg("⚠️  Shutdown signal received (Ctrl+C)")
-			logger.Info().Msg("🔄 Are you sure you want to shutdown the server? (Y/N): ")
+			sig := <-sigChan
+			logger.Warn().Str("signal", sig.String()).Msg("⚠️  Shutdown signal received")
+			if !interactive {
+				return
+			}
 
-			reader := bufio.NewReader(os.Stdin)
+			logger.Info().Msg("🔄 Are you sure you want to shutdown the server? (Y/N): ")
 			response, err := reader.ReadString('\n')
 			if err != nil {
-				logger.Error().Err(err).Msg("Failed to read input")
-				logger.Info().Msg("⏸️  Shutdown cancelled, server continues running")
-				continue
+				logger.Warn().Err(err).Msg("Failed to read confirmation, shutting down")
+				return
 			}
 
 			response = strings.TrimSpace(strings.ToUpper(response))
-
 			if response == "Y" || response == "YES" {
 				logger.Info().Msg("✅ Shutdown confirmed")
-				shutdownConfirmed <- true
 				return
-			} else {
-				logger.Info().Msg("⏸️  Shutdown cancelled, server continues running")
 			}
+			logger.Info().Msg("⏸️  Shutdown cancelled, server continues running")
 		}
 	}()
+	return done
+}
 
-	// Start HTTP server
-	go func() {
-		logger.Info().Str("port", s.cfg.HTTP.Port).Msg("🚀 Starting HTTP server")
-		if err := s.HTTP.Start(":" + s.cfg.HTTP.Port); err != nil {
-			logger.Error().Err(err).Msg("Failed to start HTTP server")
-		}
-	}()
-
-	// Wait for confirmed shutdown
-	<-shutdownConfirmed
-	cancel()
-
-	<-ctx.Done()
-
-	if err := s.Shutdown(context.Background()); err != nil {
-		logger.Error().Err(err).Msg("Failed to shutdown servers gracefully")
+// shutdown stops the HTTP server and waits for background installations (closes backgroundDone),
+// both until ctx is done.
+func (s *ServerManager) shutdown(ctx context.Context, backgroundDone <-chan struct{}) error {
+	if err := s.Shutdown(ctx); err != nil {
+		return err
+	}
+	select {
+	case <-backgroundDone:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-
-	bootstrap.CloseDatabase(s.RawDB)
-	logger.Info().Msg("🛑 Servers stopped")
-	return nil
 }
 
+// Shutdown stops the HTTP server, waiting for in-flight requests until ctx is done.
 func (s *ServerManager) Shutdown(ctx context.Context) error {
-	if err := s.HTTP.Shutdown(ctx); err != nil {
-		logger.Error().Err(err).Msg("Failed to shutdown HTTP server")
-	}
-
-	return nil
+	return s.HTTP.Shutdown(ctx)
 }
@@ -0,0 +1,337 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"agent-service-prototype/pkg/logger"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+)
+
+// ErrBackupNotFound is returned when a backup run ID does not exist.
+var ErrBackupNotFound = errors.New("backup not found")
+
+const manifestFile = "backup.json"
+
+// Table is one table saved in a backup.
+type Table struct {
+	Name     string   `json:"table"`
+	Columns  []string `json:"columns"`
+	DataFile string   `json:"data_file"`
+	DDLFile  string   `json:"ddl_file"`
+	Bytes    int64    `json:"bytes"`
+}
+
+// Manifest describes a backup directory (backup.json).
+type Manifest struct {
+	RunID      string     `json:"run_id"`
+	BundleID   string     `json:"bundle_id,omitempty"`
+	Target     string     `json:"target,omitempty"`
+	Migrations []string   `json:"migrations,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Tables     []Table    `json:"tables"`
+	RestoredAt *time.Time `json:"restored_at,omitempty"`
+}
+
+// Create copies each table (schema.table) into dir with COPY ... TO STDOUT, together with
+// its DDL, inside one REPEATABLE READ transaction so all tables come from the same snapshot.
+// Tables that do not exist are skipped. m is completed and written to dir/backup.json.
+func Create(ctx context.Context, dsn, dir string, m *Manifest, tables []string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create backup dir: %w", err)
+	}
+
+	conn, err := pgconn.Connect(ctx, dsn)
+	if err != nil {
+		return fmt.Errorf("failed to connect for backup: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY").ReadAll(); err != nil {
+		return fmt.Errorf("failed to begin backup transaction: %w", err)
+	}
+	defer conn.Exec(context.Background(), "ROLLBACK").ReadAll()
+
+	m.CreatedAt = time.Now().UTC()
+	m.Tables = []Table{}
+	for _, name := range tables {
+		t, err := backupTable(ctx, conn, dir, name)
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			logger.Warn().Str("table", name).Msg("Table does not exist, skipping backup")
+			continue
+		}
+		m.Tables = append(m.Tables, *t)
+		logger.Info().Str("table", name).Int64("bytes", t.Bytes).Msg("Table backed up")
+	}
+	return writeManifest(dir, m)
+}
+
+func backupTable(ctx context.Context, conn *pgconn.PgConn, dir, name string) (*Table, error) {
+	ident, err := quoteTable(name)
+	if err != nil {
+		return nil, err
+	}
+	exists, err := queryStrings(ctx, conn, `SELECT to_regclass($1)::text`, ident)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up %s: %w", name, err)
+	}
+	if len(exists) == 0 || exists[0] == "" {
+		return nil, nil
+	}
+
+	columns, err := queryStrings(ctx, conn, `
+		SELECT a.attname
+		FROM pg_attribute a
+		WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped AND a.attgenerated = ''
+		ORDER BY a.attnum
+	`, ident)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s: %w", name, err)
+	}
+	ddl, err := tableDDL(ctx, conn, ident)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DDL of %s: %w", name, err)
+	}
+
+	t := &Table{
+		Name:     name,
+		Columns:  columns,
+		DataFile: name + ".copy",
+		DDLFile:  name + ".sql",
+	}
+	if err := os.WriteFile(filepath.Join(dir, t.DDLFile), []byte(ddl), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write DDL of %s: %w", name, err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, t.DataFile), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backup file for %s: %w", name, err)
+	}
+	defer f.Close()
+	if _, err := conn.CopyTo(ctx, f, fmt.Sprintf("COPY %s (%s) TO STDOUT", ident, quoteColumns(columns))); err != nil {
+		return nil, fmt.Errorf("failed to copy %s: %w", name, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat backup of %s: %w", name, err)
+	}
+	t.Bytes = info.Size()
+	return t, nil
+}
+
+// tableDDL renders CREATE TABLE, constraints and standalone indexes for ident.
+func tableDDL(ctx context.Context, conn *pgconn.PgConn, ident string) (string, error) {
+	var parts []string
+	create, err := queryStrings(ctx, conn, `
+		SELECT format('CREATE TABLE %s (', $1::regclass) || E'\n' ||
+		       string_agg(format('    %I %s%s%s', a.attname, format_type(a.atttypid, a.atttypmod),
+		                  CASE WHEN a.attnotnull THEN ' NOT NULL' ELSE '' END,
+		                  COALESCE(' DEFAULT ' || pg_get_expr(d.adbin, d.adrelid), '')),
+		                  E',\n' ORDER BY a.attnum) || E'\n);'
+		FROM pg_attribute a
+		LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped
+	`, ident)
+	if err != nil {
+		return "", err
+	}
+	parts = append(parts, create...)
+
+	constraints, err := queryStrings(ctx, conn, `
+		SELECT format('ALTER TABLE %s ADD CONSTRAINT %I %s;', $1::regclass, conname, pg_get_constraintdef(oid))
+		FROM pg_constraint
+		WHERE conrelid = $1::regclass
+		ORDER BY contype, conname
+	`, ident)
+	if err != nil {
+		return "", err
+	}
+	parts = append(parts, constraints...)
+
+	indexes, err := queryStrings(ctx, conn, `
+		SELECT pg_get_indexdef(i.indexrelid) || ';'
+		FROM pg_index i
+		WHERE i.indrelid = $1::regclass
+		  AND NOT EXISTS (SELECT 1 FROM pg_constraint c WHERE c.conindid = i.indexrelid)
+		ORDER BY i.indexrelid::regclass::text
+	`, ident)
+	if err != nil {
+		return "", err
+	}
+	parts = append(parts, indexes...)
+	return strings.Join(parts, "\n\n") + "\n", nil
+}
+
+// Restore loads the tables of the backup in dir back into the database in one transaction:
+// each table is truncated and refilled with COPY ... FROM STDIN. When only is non-empty,
+// just those tables are restored.
+func Restore(ctx context.Context, dsn, dir string, only []string) (*Manifest, error) {
+	m, err := Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	selected := m.Tables
+	if len(only) > 0 {
+		want := make(map[string]bool, len(only))
+		for _, t := range only {
+			want[t] = true
+		}
+		selected = nil
+		for _, t := range m.Tables {
+			if want[t.Name] {
+				selected = append(selected, t)
+				delete(want, t.Name)
+			}
+		}
+		for t := range want {
+			return nil, fmt.Errorf("table %s is not in backup %s", t, m.RunID)
+		}
+	}
+
+	conn, err := pgconn.Connect(ctx, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect for restore: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "BEGIN").ReadAll(); err != nil {
+		return nil, fmt.Errorf("failed to begin restore transaction: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			conn.Exec(context.Background(), "ROLLBACK").ReadAll()
+		}
+	}()
+
+	for _, t := range selected {
+		ident, err := quoteTable(t.Name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := conn.Exec(ctx, "TRUNCATE TABLE "+ident).ReadAll(); err != nil {
+			return nil, fmt.Errorf("failed to truncate %s: %w", t.Name, err)
+		}
+		f, err := os.Open(filepath.Join(dir, t.DataFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open backup of %s: %w", t.Name, err)
+		}
+		tag, err := conn.CopyFrom(ctx, f, fmt.Sprintf("COPY %s (%s) FROM STDIN", ident, quoteColumns(t.Columns)))
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %w", t.Name, err)
+		}
+		logger.Info().Str("table", t.Name).Int64("rows", tag.RowsAffected()).Msg("Table restored")
+	}
+
+	if _, err := conn.Exec(ctx, "COMMIT").ReadAll(); err != nil {
+		return nil, fmt.Errorf("failed to commit restore: %w", err)
+	}
+	committed = true
+
+	now := time.Now().UTC()
+	m.RestoredAt = &now
+	if err := writeManifest(dir, m); err != nil {
+		logger.Error().Err(err).Str("run_id", m.RunID).Msg("Failed to record restore time")
+	}
+	return m, nil
+}
+
+// Load reads dir/backup.json.
+func Load(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrBackupNotFound, filepath.Base(dir))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", manifestFile, err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", manifestFile, err)
+	}
+	return &m, nil
+}
+
+// List returns the backups under root, newest first.
+func List(root string) ([]Manifest, error) {
+	entries, err := os.ReadDir(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Manifest{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backups dir: %w", err)
+	}
+	list := []Manifest{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		m, err := Load(filepath.Join(root, e.Name()))
+		if err != nil {
+			continue
+		}
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list, nil
+}
+
+func writeManifest(dir string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", manifestFile, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, manifestFile), data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", manifestFile, err)
+	}
+	return nil
+}
+
+// queryStrings runs a single-statement query with text parameters and returns the first column.
+func queryStrings(ctx context.Context, conn *pgconn.PgConn, sql string, args ...string) ([]string, error) {
+	params := make([][]byte, len(args))
+	for i, a := range args {
+		params[i] = []byte(a)
+	}
+	res := conn.ExecParams(ctx, sql, params, nil, nil, nil).Read()
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	out := make([]string, 0, len(res.Rows))
+	for _, row := range res.Rows {
+		if len(row) > 0 && row[0] != nil {
+			out = append(out, string(row[0]))
+		}
+	}
+	return out, nil
+}
+
+// quoteTable quotes "schema.table" as an identifier.
+func quoteTable(name string) (string, error) {
+	schema, table, ok := strings.Cut(name, ".")
+	if !ok || schema == "" || table == "" {
+		return "", fmt.Errorf("table %q must be schema-qualified", name)
+	}
+	return pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(table), nil
+}
+
+func quoteColumns(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = pq.QuoteIdentifier(c)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+
+	"agent-service-prototype/pkg/tracing"
 )
 
 // RawQueryHelper provides utilities for executing raw SQL queries across different schemas
@@ -18,9 +20,10 @@ func NewRawQueryHelper(db *sql.DB) *RawQueryHelper {
 	}
 }
 
-// NewRawQueryHelperFromDSN creates a new RawQueryHelper instance from a DSN string
+// NewRawQueryHelperFromDSN creates a new RawQueryHelper instance from a DSN string.
+// Queries are traced as OpenTelemetry spans.
 func NewRawQueryHelperFromDSN(driverName, dataSourceName string) (*RawQueryHelper, error) {
-	db, err := sql.Open(driverName, dataSourceName)
+	db, err := tracing.OpenDB(driverName, dataSourceName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
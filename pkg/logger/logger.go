@@ -1,23 +1,90 @@
 package logger
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"os"
 	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// Init init zerolog config
+// Format log yang didukung LOG_FORMAT
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+// Init init zerolog config (console, level info). Configure menggantinya setelah config dibaca.
 func Init() {
 	// Format waktu
 	zerolog.TimeFieldFormat = time.RFC3339
+	if err := Configure(FormatConsole, zerolog.LevelInfoValue); err != nil {
+		panic(err)
+	}
+}
+
+// Configure mengatur format output (json/console) dan level minimum log
+// (trace, debug, info, warn, error).
+func Configure(format, level string) error {
+	lvl, err := zerolog.ParseLevel(level)
+	if err != nil || lvl == zerolog.NoLevel {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+
+	var out io.Writer
+	switch format {
+	case FormatJSON:
+		// JSON satu baris per event, untuk log shipper
+		out = os.Stderr
+	case FormatConsole:
+		// Output ke console dengan style human friendly
+		out = zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: "15:04:05"}
+	default:
+		return fmt.Errorf("invalid log format %q", format)
+	}
+
+	zerolog.SetGlobalLevel(lvl)
+	log.Logger = zerolog.New(out).With().Timestamp().Logger().Hook(contextHook{})
+	return nil
+}
+
+type fieldsKey struct{}
+
+// field adalah satu pasangan key/value yang dibawa context
+type field struct{ key, value string }
+
+// WithFields mengembalikan context yang membawa field log tambahan (mis. request_id, run_id).
+// Setiap log yang memakai context tersebut (logger.Info().Ctx(ctx)...) ikut mencatat field ini.
+// kv berisi pasangan key, value; value kosong diabaikan.
+func WithFields(ctx context.Context, kv ...string) context.Context {
+	prev, _ := ctx.Value(fieldsKey{}).([]field)
+	fields := append([]field(nil), prev...)
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != "" {
+			fields = append(fields, field{kv[i], kv[i+1]})
+		}
+	}
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+// contextHook menambahkan field dari WithFields serta trace_id dan span_id dari context event
+// (lihat Event.Ctx)
+type contextHook struct{}
 
-	// Output ke console dengan style human friendly
-	log.Logger = log.Output(zerolog.ConsoleWriter{
-		Out:        os.Stderr,
-		TimeFormat: "15:04:05",
-	})
+func (contextHook) Run(e *zerolog.Event, _ zerolog.Level, _ string) {
+	ctx := e.GetCtx()
+	if fields, ok := ctx.Value(fieldsKey{}).([]field); ok {
+		for _, f := range fields {
+			e.Str(f.key, f.value)
+		}
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		e.Str("trace_id", sc.TraceID().String()).Str("span_id", sc.SpanID().String())
+	}
 }
 
 // Export log biar gampang dipakai di seluruh project
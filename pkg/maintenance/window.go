@@ -0,0 +1,132 @@
+// Package maintenance parses maintenance windows such as "Sat,Sun 02:00-05:00".
+package maintenance
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	// Embedded zone database so MAINTENANCE_TIMEZONE works in images without /usr/share/zoneinfo.
+	_ "time/tzdata"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Window is a recurring time range on selected weekdays. A range whose end is not after its
+// start ends on the following day (e.g. 22:00-02:00); the weekday is the day it starts.
+type Window struct {
+	days       [7]bool
+	start, end time.Duration
+	loc        *time.Location
+	spec       string
+}
+
+// Parse parses "[days ]HH:MM-HH:MM" in loc. days is a comma-separated list of weekdays or
+// weekday ranges (e.g. "Mon-Fri", "Sat,Sun"); without it the window is daily.
+func Parse(spec string, loc *time.Location) (*Window, error) {
+	w := &Window{loc: loc, spec: spec}
+	fields := strings.Fields(spec)
+	var days, hours string
+	switch len(fields) {
+	case 1:
+		hours = fields[0]
+		for i := range w.days {
+			w.days[i] = true
+		}
+	case 2:
+		days, hours = fields[0], fields[1]
+		if err := w.parseDays(days); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%q: expected \"[days ]HH:MM-HH:MM\"", spec)
+	}
+
+	from, to, ok := strings.Cut(hours, "-")
+	if !ok {
+		return nil, fmt.Errorf("%q: expected a time range HH:MM-HH:MM", hours)
+	}
+	var err error
+	if w.start, err = clock(from); err != nil {
+		return nil, err
+	}
+	if w.end, err = clock(to); err != nil {
+		return nil, err
+	}
+	if w.end == w.start {
+		return nil, fmt.Errorf("%q: window is empty", hours)
+	}
+	return w, nil
+}
+
+func (w *Window) parseDays(spec string) error {
+	for _, part := range strings.Split(spec, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := weekdays[strings.ToLower(from)]
+		if !ok {
+			return fmt.Errorf("%q is not a weekday (Mon, Tue, ...)", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdays[strings.ToLower(to)]; !ok {
+				return fmt.Errorf("%q is not a weekday (Mon, Tue, ...)", to)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// clock parses HH:MM as an offset from midnight.
+func clock(v string) (time.Duration, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time of day (HH:MM)", v)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Next returns the window containing now, or the next one if now is outside every window.
+func (w *Window) Next(now time.Time) (start, end time.Time) {
+	local := now.In(w.loc)
+	y, m, d := local.Date()
+	// Start a day early: an overnight window of yesterday may still be open.
+	for offset := -1; offset <= 7; offset++ {
+		day := time.Date(y, m, d+offset, 0, 0, 0, 0, w.loc)
+		if !w.days[day.Weekday()] {
+			continue
+		}
+		start = at(day, w.start)
+		end = at(day, w.end)
+		if !end.After(start) {
+			end = at(day.AddDate(0, 0, 1), w.end)
+		}
+		if now.Before(end) {
+			return start, end
+		}
+	}
+	return time.Time{}, time.Time{}
+}
+
+// Contains reports whether now is inside a window.
+func (w *Window) Contains(now time.Time) bool {
+	start, end := w.Next(now)
+	return !now.Before(start) && now.Before(end)
+}
+
+func (w *Window) String() string {
+	return fmt.Sprintf("%s (%s)", w.spec, w.loc)
+}
+
+// at returns the wall-clock time offset after midnight of day (DST-safe).
+func at(day time.Time, offset time.Duration) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), int(offset/time.Hour), int(offset%time.Hour/time.Minute), 0, 0, day.Location())
+}
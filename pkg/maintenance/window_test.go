@@ -0,0 +1,82 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec string
+		ok   bool
+	}{
+		{"02:00-05:00", true},
+		{"22:00-02:00", true},
+		{"Sat,Sun 02:00-05:00", true},
+		{"mon-fri 09:30-10:00", true},
+		{"Fri-Mon 23:00-01:00", true},
+		{"Sat,Mon-Wed 00:00-23:59", true},
+		{"", false},
+		{"02:00", false},
+		{"02:00-02:00", false},
+		{"25:00-26:00", false},
+		{"2am-5am", false},
+		{"Funday 02:00-05:00", false},
+		{"Mon-Someday 02:00-05:00", false},
+		{"Sat Sun 02:00-05:00", false},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.spec, time.UTC)
+		if (err == nil) != tt.ok {
+			t.Errorf("Parse(%q) error = %v, want ok=%v", tt.spec, err, tt.ok)
+		}
+	}
+}
+
+func TestWindow(t *testing.T) {
+	jakarta, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2026-10-17 is a Saturday.
+	utc := func(day, hour, min int) time.Time { return time.Date(2026, 10, day, hour, min, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name       string
+		spec       string
+		loc        *time.Location
+		now        time.Time
+		contains   bool
+		start, end time.Time
+	}{
+		{"daily, inside", "02:00-05:00", time.UTC, utc(14, 3, 0), true, utc(14, 2, 0), utc(14, 5, 0)},
+		{"daily, at start", "02:00-05:00", time.UTC, utc(14, 2, 0), true, utc(14, 2, 0), utc(14, 5, 0)},
+		{"daily, at end", "02:00-05:00", time.UTC, utc(14, 5, 0), false, utc(15, 2, 0), utc(15, 5, 0)},
+		{"daily, before", "02:00-05:00", time.UTC, utc(14, 1, 0), false, utc(14, 2, 0), utc(14, 5, 0)},
+		{"overnight, after midnight", "22:00-02:00", time.UTC, utc(15, 1, 0), true, utc(14, 22, 0), utc(15, 2, 0)},
+		{"overnight, before midnight", "22:00-02:00", time.UTC, utc(14, 23, 0), true, utc(14, 22, 0), utc(15, 2, 0)},
+		{"weekend, on Saturday", "Sat,Sun 02:00-05:00", time.UTC, utc(17, 4, 0), true, utc(17, 2, 0), utc(17, 5, 0)},
+		{"weekend, from Wednesday", "Sat,Sun 02:00-05:00", time.UTC, utc(14, 4, 0), false, utc(17, 2, 0), utc(17, 5, 0)},
+		{"weekend, after Sunday", "Sat,Sun 02:00-05:00", time.UTC, utc(18, 6, 0), false, utc(24, 2, 0), utc(24, 5, 0)},
+		{"overnight from Friday, on Saturday", "Fri 22:00-02:00", time.UTC, utc(17, 1, 0), true, utc(16, 22, 0), utc(17, 2, 0)},
+		{"overnight from Friday, Saturday night", "Fri 22:00-02:00", time.UTC, utc(17, 23, 0), false, utc(23, 22, 0), utc(24, 2, 0)},
+		{"wrapping day range", "Sat-Mon 02:00-05:00", time.UTC, utc(19, 3, 0), true, utc(19, 2, 0), utc(19, 5, 0)},
+		{"wrapping day range, Tuesday", "Sat-Mon 02:00-05:00", time.UTC, utc(20, 3, 0), false, utc(24, 2, 0), utc(24, 5, 0)},
+		{"time zone", "02:00-05:00", jakarta, utc(13, 20, 0), true, utc(13, 19, 0), utc(13, 22, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := Parse(tt.spec, tt.loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := w.Contains(tt.now); got != tt.contains {
+				t.Errorf("Contains(%s) = %v, want %v", tt.now, got, tt.contains)
+			}
+			start, end := w.Next(tt.now)
+			if !start.Equal(tt.start) || !end.Equal(tt.end) {
+				t.Errorf("Next(%s) = %s - %s, want %s - %s", tt.now, start.UTC(), end.UTC(), tt.start, tt.end)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+// Package metrics holds the Prometheus collectors exposed on GET /metrics.
+package metrics
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "agent"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	installationRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "installation_runs_total",
+		Help:      "Installation runs by mode (single, multi) and outcome (success, failed).",
+	}, []string{"mode", "outcome"})
+
+	stepDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "installation_step_duration_seconds",
+		Help:      "Time spent in each installation step.",
+		Buckets:   []float64{0.1, 0.5, 1, 5, 15, 30, 60, 300, 900, 1800},
+	}, []string{"step"})
+
+	migrationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "migration_duration_seconds",
+		Help:      "Execution time of migrations per target and app.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 15, 60, 300, 1800},
+	}, []string{"target", "app"})
+
+	schemaVersion = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "schema_version_info",
+		Help:      "Current schema version per target and app; the value is always 1.",
+	}, []string{"target", "app", "version"})
+)
+
+// Handler serves the default registry in the Prometheus text format.
+func Handler() echo.HandlerFunc {
+	return echo.WrapHandler(promhttp.Handler())
+}
+
+// Middleware counts requests and observes their latency. Routes are labeled by their
+// registered path (e.g. /setup/backups/:id/restore), so IDs do not create new series.
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+			status := c.Response().Status
+			if err != nil {
+				if he, ok := err.(*echo.HTTPError); ok {
+					status = he.Code
+				} else if !c.Response().Committed {
+					status = 500
+				}
+			}
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			labels := []string{c.Request().Method, route, strconv.Itoa(status)}
+			httpRequests.WithLabelValues(labels...).Inc()
+			httpDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
+
+// RegisterDBStats exposes the sql.DBStats of db (open and in-use connections, wait count,
+// wait duration, ...) as go_sql_* metrics labeled db_name=name.
+func RegisterDBStats(db *sql.DB, name string) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// InstallationFinished counts one installation run.
+func InstallationFinished(mode string, success bool) {
+	outcome := "success"
+	if !success {
+		outcome = "failed"
+	}
+	installationRuns.WithLabelValues(mode, outcome).Inc()
+}
+
+// MigrationExecuted observes how long a migration of app took to run on target.
+func MigrationExecuted(target, app string, d time.Duration) {
+	migrationDuration.WithLabelValues(target, app).Observe(d.Seconds())
+}
+
+// SetSchemaVersion replaces the schema version reported for app on target.
+func SetSchemaVersion(target, app, version string) {
+	schemaVersion.DeletePartialMatch(prometheus.Labels{"target": target, "app": app})
+	schemaVersion.WithLabelValues(target, app, version).Set(1)
+}
+
+// StepTimer observes how long each step of an installation takes.
+type StepTimer struct {
+	step  string
+	start time.Time
+}
+
+// Step ends the current step, if any, and starts step.
+func (t *StepTimer) Step(step string) {
+	t.Stop()
+	t.step, t.start = step, time.Now()
+}
+
+// Stop ends the current step.
+func (t *StepTimer) Stop() {
+	if t.step != "" {
+		stepDuration.WithLabelValues(t.step).Observe(time.Since(t.start).Seconds())
+		t.step = ""
+	}
+}
@@ -0,0 +1,90 @@
+// Package rbac defines the roles of /setup callers and the setup actions each role may perform.
+package rbac
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Role is the access level of a caller. Each role includes the permissions of the roles
+// below it: viewer < operator < admin.
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"
+	RoleOperator Role = "operator"
+	RoleAdmin    Role = "admin"
+)
+
+// Roles lists the roles from the least to the most privileged.
+var Roles = []Role{RoleViewer, RoleOperator, RoleAdmin}
+
+// Permission is a setup action a role may be allowed to perform.
+type Permission string
+
+const (
+	// PermStatus reads status, bundles, drift, backups, tenants and the audit chain.
+	PermStatus Permission = "status"
+	// PermPlan inspects a bundle and diffs it against the database without applying it.
+	PermPlan Permission = "plan"
+	// PermInstall runs an installation.
+	PermInstall Permission = "install"
+	// PermForce re-applies migrations that already succeeded ("force": true or FORCE=true).
+	PermForce Permission = "force"
+	// PermRollback restores a pre-migration backup.
+	PermRollback Permission = "rollback"
+	// PermAdopt records or repairs migration history (adopt, repair).
+	PermAdopt Permission = "adopt"
+	// PermBundleUpload uploads a bundle to the cache.
+	PermBundleUpload Permission = "bundle_upload"
+	// PermTenants creates and deletes tenant schemas.
+	PermTenants Permission = "tenants"
+)
+
+// rolePermissions maps each role to the permissions it adds to the role below it.
+var rolePermissions = map[Role][]Permission{
+	RoleViewer:   {PermStatus, PermPlan},
+	RoleOperator: {PermInstall, PermBundleUpload},
+	RoleAdmin:    {PermForce, PermRollback, PermAdopt, PermTenants},
+}
+
+// Parse validates a role name.
+func Parse(s string) (Role, error) {
+	r := Role(strings.ToLower(strings.TrimSpace(s)))
+	if !slices.Contains(Roles, r) {
+		return "", fmt.Errorf("unknown role %q (want viewer, operator or admin)", s)
+	}
+	return r, nil
+}
+
+// Allows reports whether r grants perm. Unknown roles grant nothing.
+func (r Role) Allows(perm Permission) bool {
+	if !slices.Contains(Roles, r) {
+		return false
+	}
+	for _, role := range Roles {
+		if slices.Contains(rolePermissions[role], perm) {
+			return true
+		}
+		if role == r {
+			return false
+		}
+	}
+	return false
+}
+
+// Highest returns the most privileged of the given role names, ignoring unknown ones, or ""
+// when none is known.
+func Highest(names []string) Role {
+	best := -1
+	for _, n := range names {
+		if i := slices.Index(Roles, Role(strings.ToLower(strings.TrimSpace(n)))); i > best {
+			best = i
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	return Roles[best]
+}
@@ -0,0 +1,50 @@
+package rbac
+
+import "testing"
+
+func TestAllows(t *testing.T) {
+	all := []Permission{PermStatus, PermPlan, PermInstall, PermForce, PermRollback, PermAdopt, PermBundleUpload, PermTenants}
+	tests := []struct {
+		role  Role
+		perms []Permission
+	}{
+		{RoleViewer, []Permission{PermStatus, PermPlan}},
+		{RoleOperator, []Permission{PermStatus, PermPlan, PermInstall, PermBundleUpload}},
+		{RoleAdmin, all},
+		{"", nil},
+		{"root", nil},
+		{"Admin", nil},
+	}
+	for _, tt := range tests {
+		granted := map[Permission]bool{}
+		for _, p := range tt.perms {
+			granted[p] = true
+		}
+		for _, p := range all {
+			if got := tt.role.Allows(p); got != granted[p] {
+				t.Errorf("Role(%q).Allows(%q) = %v, want %v", tt.role, p, got, granted[p])
+			}
+		}
+		if tt.role.Allows("unknown") {
+			t.Errorf("Role(%q).Allows(%q) = true, want false", tt.role, "unknown")
+		}
+	}
+}
+
+func TestHighest(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  Role
+	}{
+		{nil, ""},
+		{[]string{"root"}, ""},
+		{[]string{"viewer"}, RoleViewer},
+		{[]string{" Operator ", "viewer"}, RoleOperator},
+		{[]string{"viewer", "ADMIN", "operator"}, RoleAdmin},
+	}
+	for _, tt := range tests {
+		if got := Highest(tt.names); got != tt.want {
+			t.Errorf("Highest(%q) = %q, want %q", tt.names, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,429 @@
+package setup
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"agent-service-prototype/pkg/logger"
+)
+
+// BuildOptions configures BuildBundle.
+type BuildOptions struct {
+	// SrcDir holds baseline/, migrations/ and optionally checks/smoke.sql, schema/snapshot.json
+	// and a hand-maintained manifest.json. BuildBundle only reads it.
+	SrcDir string
+	// OutZip is the path of the zip to write.
+	OutZip string
+	// Prefix is the top-level folder inside the zip (defaults to the base name of SrcDir).
+	Prefix string
+	// App, BundleVersion and DB replace the values of an existing manifest.json; empty
+	// fields leave them alone.
+	App           string
+	BundleVersion string
+	DB            ManifestDB
+	// DefaultApp and DefaultDB fill what neither the options nor manifest.json set.
+	DefaultApp string
+	DefaultDB  ManifestDB
+	// Regenerate ignores SrcDir/manifest.json and infers the whole manifest from the files.
+	Regenerate bool
+}
+
+// sqlFileRe matches <YYYYMMDD>_<NNN>_<name>.sql, e.g. 20260220_001_add_employee_nik.sql.
+var sqlFileRe = regexp.MustCompile(`^(\d{4})(\d{2})(\d{2})_(\d{3})_([a-z0-9_]+)\.sql$`)
+
+// headerRe matches "-- Key: value" header lines at the top of a SQL file.
+var headerRe = regexp.MustCompile(`^--\s*([A-Za-z_ ]+):\s*(.*?)\s*$`)
+
+// zipModTime is the fixed timestamp for zip entries so builds are reproducible.
+var zipModTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// sqlFileInfo is what BuildBundle infers from a SQL file name and its header.
+type sqlFileInfo struct {
+	Version     string
+	Name        string
+	File        string
+	Transaction bool
+}
+
+// inspectSQLFile infers version, name and transaction flag for rel (slash-separated, relative to srcDir).
+// Header lines ("-- Version:", "-- Name:", "-- Transaction: TRUE|FALSE") override the file name;
+// a "_notx" name suffix marks the file as non-transactional.
+func inspectSQLFile(srcDir, rel string) (*sqlFileInfo, error) {
+	m := sqlFileRe.FindStringSubmatch(path.Base(rel))
+	if m == nil {
+		return nil, fmt.Errorf("%s: file name must match YYYYMMDD_NNN_name.sql", rel)
+	}
+	info := &sqlFileInfo{
+		Version:     fmt.Sprintf("%s.%s.%s.%s", m[1], m[2], m[3], m[4]),
+		Name:        m[5],
+		File:        rel,
+		Transaction: true,
+	}
+	if strings.HasSuffix(info.Name, "_notx") {
+		info.Name = strings.TrimSuffix(info.Name, "_notx")
+		info.Transaction = false
+	}
+
+	f, err := os.Open(filepath.Join(srcDir, filepath.FromSlash(rel)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", rel, err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		h := headerRe.FindStringSubmatch(line)
+		if h == nil {
+			break
+		}
+		value := h[2]
+		switch strings.ToLower(strings.TrimSpace(h[1])) {
+		case "version":
+			info.Version = value
+		case "name":
+			info.Name = value
+		case "transaction":
+			tx, err := strconv.ParseBool(strings.ToLower(value))
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid Transaction header %q", rel, value)
+			}
+			info.Transaction = tx
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", rel, err)
+	}
+	return info, nil
+}
+
+// listSQLFiles returns the slash-separated paths of *.sql files directly inside srcDir/dir, sorted.
+func listSQLFiles(srcDir, dir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(srcDir, dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".sql") {
+			files = append(files, path.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// BuildManifest returns the manifest for the files in opts.SrcDir. Entries of an existing
+// manifest.json are kept as written (unless opts.Regenerate is set); migrations it does not
+// list yet, and a missing baseline, smoke check or snapshot, are inferred from the files.
+func BuildManifest(opts BuildOptions) (*Manifest, error) {
+	srcDir := filepath.Clean(opts.SrcDir)
+	m := &Manifest{}
+	if !opts.Regenerate {
+		if _, err := os.Stat(filepath.Join(srcDir, "manifest.json")); err == nil {
+			if m, err = LoadManifest(srcDir); err != nil {
+				return nil, err
+			}
+		}
+	}
+	m.App = firstNonEmpty(opts.App, m.App, opts.DefaultApp)
+	m.BundleVersion = firstNonEmpty(opts.BundleVersion, m.BundleVersion)
+	m.DB.Type = firstNonEmpty(opts.DB.Type, m.DB.Type, opts.DefaultDB.Type)
+	m.DB.DefaultSchema = firstNonEmpty(opts.DB.DefaultSchema, m.DB.DefaultSchema, opts.DefaultDB.DefaultSchema)
+	for _, v := range []int{opts.DB.MinVersion, m.DB.MinVersion, opts.DefaultDB.MinVersion} {
+		if v != 0 {
+			m.DB.MinVersion = v
+			break
+		}
+	}
+
+	if m.Baseline.File == "" {
+		baselines, err := listSQLFiles(srcDir, "baseline")
+		if err != nil {
+			return nil, err
+		}
+		if len(baselines) != 1 {
+			return nil, fmt.Errorf("baseline/ must contain exactly one .sql file, found %d", len(baselines))
+		}
+		base, err := inspectSQLFile(srcDir, baselines[0])
+		if err != nil {
+			return nil, err
+		}
+		m.Baseline = Baseline{Version: base.Version, Name: base.Name, File: base.File, RequiredForFreshDB: true}
+	}
+
+	migFiles, err := listSQLFiles(srcDir, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	listed := make(map[string]bool, len(m.Migrations))
+	for _, mig := range m.Migrations {
+		listed[mig.File] = true
+	}
+	if m.Migrations == nil {
+		m.Migrations = []Migration{}
+	}
+	for _, rel := range migFiles {
+		if listed[rel] {
+			continue
+		}
+		info, err := inspectSQLFile(srcDir, rel)
+		if err != nil {
+			return nil, err
+		}
+		m.Migrations = append(m.Migrations, Migration{
+			Version:     info.Version,
+			Name:        info.Name,
+			File:        info.File,
+			Transaction: info.Transaction,
+		})
+	}
+	sort.SliceStable(m.Migrations, func(i, j int) bool { return m.Migrations[i].Version < m.Migrations[j].Version })
+
+	m.TargetSchemaVersion = m.Baseline.Version
+	if n := len(m.Migrations); n > 0 {
+		m.TargetSchemaVersion = m.Migrations[n-1].Version
+	}
+	if m.BundleVersion == "" {
+		m.BundleVersion = m.TargetSchemaVersion
+	}
+
+	if _, err := os.Stat(filepath.Join(srcDir, "checks", "smoke.sql")); err == nil && m.Checks.Smoke == "" {
+		m.Checks.Smoke = "checks/smoke.sql"
+	}
+	if _, err := os.Stat(filepath.Join(srcDir, "schema", "snapshot.json")); err == nil && m.Snapshot == "" {
+		m.Snapshot = "schema/snapshot.json"
+	}
+	return m, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// BuildChecksums hashes the given files (slash-separated, relative to baseDir).
+func BuildChecksums(baseDir string, files []string) (map[string]string, error) {
+	checksums := make(map[string]string, len(files))
+	for _, rel := range files {
+		if err := ValidateBundlePath(rel); err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(filepath.Join(baseDir, filepath.FromSlash(rel)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", rel, err)
+		}
+		checksums[rel] = "sha256:" + SHA256Hex(data)
+	}
+	return checksums, nil
+}
+
+// marshalJSONFile renders v as indented JSON with a trailing newline.
+func marshalJSONFile(v interface{}) ([]byte, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// BuildBundle builds the manifest and checksums.json, validates them the same way the
+// installer reads them, and packs them with the files the manifest lists into opts.OutZip.
+// Other files in opts.SrcDir (editor backups, earlier builds) are left out, and nothing is
+// written to opts.SrcDir.
+func BuildBundle(opts BuildOptions) (*Manifest, error) {
+	srcDir := filepath.Clean(opts.SrcDir)
+	m, err := BuildManifest(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	manifestJSON, err := marshalJSONFile(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest.json: %w", err)
+	}
+	parsed, err := ParseManifestStrict(manifestJSON)
+	if err != nil {
+		return nil, err
+	}
+	if errs := parsed.Lint(srcDir); len(errs) > 0 {
+		return nil, fmt.Errorf("manifest validation failed: %w", errors.Join(errs...))
+	}
+
+	stage, err := os.MkdirTemp("", "bundle-build-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging dir: %w", err)
+	}
+	defer os.RemoveAll(stage)
+
+	files := []string{"manifest.json"}
+	for _, rel := range parsed.files() {
+		if rel == "" {
+			continue
+		}
+		if err := copyBundleFile(srcDir, stage, rel); err != nil {
+			return nil, err
+		}
+		files = append(files, rel)
+	}
+	if err := os.WriteFile(filepath.Join(stage, "manifest.json"), manifestJSON, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write manifest.json: %w", err)
+	}
+
+	checksums, err := BuildChecksums(stage, files)
+	if err != nil {
+		return nil, err
+	}
+	checksumsJSON, err := marshalJSONFile(checksums)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode checksums.json: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(stage, "checksums.json"), checksumsJSON, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write checksums.json: %w", err)
+	}
+	loaded, err := LoadChecksums(stage)
+	if err != nil {
+		return nil, err
+	}
+	if err := VerifyChecksums(stage, loaded); err != nil {
+		return nil, err
+	}
+	if errs := LintBundle(stage, parsed, loaded); len(errs) > 0 {
+		return nil, fmt.Errorf("bundle validation failed: %w", errors.Join(errs...))
+	}
+
+	prefix := opts.Prefix
+	if prefix == "" {
+		prefix = filepath.Base(srcDir)
+	}
+	if err := writeDeterministicZip(stage, prefix, opts.OutZip); err != nil {
+		return nil, err
+	}
+
+	logger.Info().
+		Str("bundle_version", m.BundleVersion).
+		Str("target_schema_version", m.TargetSchemaVersion).
+		Int("migrations", len(m.Migrations)).
+		Str("zip", opts.OutZip).
+		Msg("Bundle built")
+	return m, nil
+}
+
+// copyBundleFile copies rel from srcDir to the same path under dst.
+func copyBundleFile(srcDir, dst, rel string) error {
+	data, err := os.ReadFile(filepath.Join(srcDir, filepath.FromSlash(rel)))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", rel, err)
+	}
+	target := filepath.Join(dst, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to stage %s: %w", rel, err)
+	}
+	if err := os.WriteFile(target, data, 0644); err != nil {
+		return fmt.Errorf("failed to stage %s: %w", rel, err)
+	}
+	return nil
+}
+
+// writeDeterministicZip zips srcDir under prefix/ with sorted entries, a fixed
+// timestamp and fixed permissions, so identical inputs produce identical bytes.
+func writeDeterministicZip(srcDir, prefix, outZip string) error {
+	var dirs, files []string
+	err := filepath.WalkDir(srcDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if d.IsDir() {
+			dirs = append(dirs, filepath.ToSlash(rel))
+		} else if d.Type().IsRegular() {
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to walk %s: %w", srcDir, err)
+	}
+
+	type entry struct {
+		name string
+		dir  bool
+	}
+	entries := []entry{{name: prefix + "/", dir: true}}
+	for _, d := range dirs {
+		entries = append(entries, entry{name: path.Join(prefix, d) + "/", dir: true})
+	}
+	for _, f := range files {
+		entries = append(entries, entry{name: path.Join(prefix, f)})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Modified: zipModTime}
+		if e.dir {
+			hdr.SetMode(fs.ModeDir | 0755)
+			if _, err := zw.CreateHeader(hdr); err != nil {
+				return fmt.Errorf("failed to add %s: %w", e.name, err)
+			}
+			continue
+		}
+		hdr.Method = zip.Deflate
+		hdr.SetMode(0644)
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return fmt.Errorf("failed to add %s: %w", e.name, err)
+		}
+		src, err := os.Open(filepath.Join(srcDir, filepath.FromSlash(strings.TrimPrefix(e.name, prefix+"/"))))
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", e.name, err)
+		}
+		_, err = io.Copy(w, src)
+		src.Close()
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", e.name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to finalize zip: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outZip), 0755); err != nil {
+		return fmt.Errorf("failed to create dir for %s: %w", outZip, err)
+	}
+	if err := os.WriteFile(outZip, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", outZip, err)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package setup
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeBundleSrc creates a bundle source directory from rel path → content.
+func writeBundleSrc(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "src")
+	for rel, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// dirSnapshot returns the content of every file under dir.
+func dirSnapshot(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		rel, _ := filepath.Rel(dir, p)
+		files[filepath.ToSlash(rel)] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// zipEntries returns the names and contents of the entries of a zip.
+func zipEntries(t *testing.T, file string) map[string]string {
+	t.Helper()
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	entries := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		buf.ReadFrom(rc)
+		rc.Close()
+		entries[f.Name] = buf.String()
+	}
+	return entries
+}
+
+var builderSrc = map[string]string{
+	"baseline/20260220_000_baseline.sql":       "CREATE TABLE t (id INT);\n",
+	"migrations/20260220_001_add_a.sql":        "ALTER TABLE t ADD COLUMN a INT;\n",
+	"migrations/20260220_002_index_a_notx.sql": "CREATE INDEX CONCURRENTLY t_a ON t (a);\n",
+	"migrations/20260221_001_headers.sql":      "-- Version: 2026.02.21.005\n-- Name: renamed\n-- Transaction: FALSE\nSELECT 1;\n",
+	"checks/smoke.sql":                         "SELECT 1;\n",
+	"migrations/20260220_001_add_a.sql~":       "editor backup",
+	"migrations/.DS_Store":                     "finder",
+	"migrations/README.md":                     "notes",
+	"baseline/old/20250101_000_baseline.sql":   "old baseline",
+	"out/db-bundle.zip":                        "earlier build",
+	".DS_Store":                                "finder",
+}
+
+func TestBuildManifestInference(t *testing.T) {
+	dir := writeBundleSrc(t, builderSrc)
+	m, err := BuildManifest(BuildOptions{SrcDir: dir, DefaultApp: "hris", DefaultDB: ManifestDB{Type: "postgres", MinVersion: 13, DefaultSchema: "hris"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Migration{
+		{Version: "2026.02.20.001", Name: "add_a", File: "migrations/20260220_001_add_a.sql", Transaction: true},
+		{Version: "2026.02.20.002", Name: "index_a", File: "migrations/20260220_002_index_a_notx.sql", Transaction: false},
+		{Version: "2026.02.21.005", Name: "renamed", File: "migrations/20260221_001_headers.sql", Transaction: false},
+	}
+	if !slices.EqualFunc(m.Migrations, want, func(a, b Migration) bool {
+		return a.Version == b.Version && a.Name == b.Name && a.File == b.File && a.Transaction == b.Transaction
+	}) {
+		t.Errorf("Migrations = %+v, want %+v", m.Migrations, want)
+	}
+	if m.Baseline != (Baseline{Version: "2026.02.20.000", Name: "baseline", File: "baseline/20260220_000_baseline.sql", RequiredForFreshDB: true}) {
+		t.Errorf("Baseline = %+v", m.Baseline)
+	}
+	if m.TargetSchemaVersion != "2026.02.21.005" || m.BundleVersion != "2026.02.21.005" {
+		t.Errorf("TargetSchemaVersion, BundleVersion = %q, %q, want 2026.02.21.005", m.TargetSchemaVersion, m.BundleVersion)
+	}
+	if m.App != "hris" || m.DB.DefaultSchema != "hris" || m.DB.MinVersion != 13 || m.Checks.Smoke != "checks/smoke.sql" || m.Snapshot != "" {
+		t.Errorf("manifest = %+v", m)
+	}
+}
+
+func TestBuildManifestKeepsExistingManifest(t *testing.T) {
+	src := map[string]string{}
+	for k, v := range builderSrc {
+		src[k] = v
+	}
+	src["manifest.json"] = `{
+  "bundle_version": "1.0.0",
+  "app": "payroll",
+  "db": {"type": "postgres", "min_version": 15, "default_schema": "payroll"},
+  "baseline": {"version": "2026.02.20.000", "name": "initial schema", "file": "baseline/20260220_000_baseline.sql"},
+  "migrations": [
+    {"version": "2026.02.20.001", "name": "add column a", "file": "migrations/20260220_001_add_a.sql", "transaction": true, "backup_tables": ["payroll.t"]}
+  ],
+  "checks": {}
+}`
+	dir := writeBundleSrc(t, src)
+	defaults := BuildOptions{SrcDir: dir, DefaultApp: "hris", DefaultDB: ManifestDB{Type: "postgres", MinVersion: 13, DefaultSchema: "hris"}}
+
+	m, err := BuildManifest(defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.App != "payroll" || m.BundleVersion != "1.0.0" || m.DB.MinVersion != 15 || m.DB.DefaultSchema != "payroll" {
+		t.Errorf("existing fields not kept: %+v", m)
+	}
+	if m.Baseline.Name != "initial schema" || m.Migrations[0].Name != "add column a" || !slices.Equal(m.Migrations[0].BackupTables, []string{"payroll.t"}) {
+		t.Errorf("existing entries not kept: %+v %+v", m.Baseline, m.Migrations[0])
+	}
+	if len(m.Migrations) != 3 || m.Migrations[1].Name != "index_a" || m.TargetSchemaVersion != "2026.02.21.005" {
+		t.Errorf("new migrations not added: %+v", m.Migrations)
+	}
+
+	opts := defaults
+	opts.App, opts.BundleVersion, opts.DB.MinVersion = "hr", "2.0.0", 16
+	if m, err = BuildManifest(opts); err != nil {
+		t.Fatal(err)
+	}
+	if m.App != "hr" || m.BundleVersion != "2.0.0" || m.DB.MinVersion != 16 || m.DB.DefaultSchema != "payroll" {
+		t.Errorf("options did not replace the given fields: %+v", m)
+	}
+
+	opts = defaults
+	opts.Regenerate = true
+	if m, err = BuildManifest(opts); err != nil {
+		t.Fatal(err)
+	}
+	if m.App != "hris" || m.Baseline.Name != "baseline" || m.Migrations[0].Name != "add_a" || m.BundleVersion != "2026.02.21.005" {
+		t.Errorf("Regenerate kept the existing manifest: %+v", m)
+	}
+}
+
+func TestBuildBundle(t *testing.T) {
+	dir := writeBundleSrc(t, builderSrc)
+	before := dirSnapshot(t, dir)
+	out := t.TempDir()
+
+	opts := BuildOptions{SrcDir: dir, OutZip: filepath.Join(out, "a.zip"), Prefix: "db-bundle", DefaultApp: "hris"}
+	if _, err := BuildBundle(opts); err != nil {
+		t.Fatal(err)
+	}
+	opts.OutZip = filepath.Join(out, "b.zip")
+	if _, err := BuildBundle(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := os.ReadFile(filepath.Join(out, "a.zip"))
+	b, _ := os.ReadFile(filepath.Join(out, "b.zip"))
+	if !bytes.Equal(a, b) {
+		t.Error("rebuilding the same source produced a different zip")
+	}
+
+	after := dirSnapshot(t, dir)
+	if len(after) != len(before) {
+		t.Errorf("BuildBundle wrote to the source dir: %d files before, %d after", len(before), len(after))
+	}
+
+	entries := zipEntries(t, filepath.Join(out, "a.zip"))
+	var files []string
+	for name := range entries {
+		if name[len(name)-1] != '/' {
+			files = append(files, name)
+		}
+	}
+	slices.Sort(files)
+	want := []string{
+		"db-bundle/baseline/20260220_000_baseline.sql",
+		"db-bundle/checks/smoke.sql",
+		"db-bundle/checksums.json",
+		"db-bundle/manifest.json",
+		"db-bundle/migrations/20260220_001_add_a.sql",
+		"db-bundle/migrations/20260220_002_index_a_notx.sql",
+		"db-bundle/migrations/20260221_001_headers.sql",
+	}
+	if !slices.Equal(files, want) {
+		t.Errorf("zip files = %q, want %q", files, want)
+	}
+
+	var checksums map[string]string
+	if err := json.Unmarshal([]byte(entries["db-bundle/checksums.json"]), &checksums); err != nil {
+		t.Fatal(err)
+	}
+	if len(checksums) != len(want)-1 {
+		t.Errorf("checksums.json lists %d files, want %d", len(checksums), len(want)-1)
+	}
+	if got := checksums["manifest.json"]; got != "sha256:"+SHA256Hex([]byte(entries["db-bundle/manifest.json"])) {
+		t.Errorf("checksums.json does not match manifest.json: %s", got)
+	}
+}
@@ -16,34 +16,63 @@ import (
 
 // DownloadBundle downloads a file from url to dest.
 func DownloadBundle(ctx context.Context, url, dest string) error {
+	_, _, err := DownloadBundleIfModified(ctx, url, dest, "")
+	return err
+}
+
+// DownloadBundleIfModified downloads url to dest unless the server answers 304 Not Modified
+// to If-None-Match: etag (sent when etag is non-empty). It returns the response ETag and
+// whether dest was written.
+func DownloadBundleIfModified(ctx context.Context, url, dest, etag string) (string, bool, error) {
 	logger.Info().Str("url", url).Str("dest", dest).Msg("Downloading bundle")
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return "", false, fmt.Errorf("failed to create request: %w", err)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
 	}
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to download bundle: %w", err)
+		return "", false, fmt.Errorf("failed to download bundle: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && etag != "" {
+		logger.Info().Str("url", url).Msg("Bundle not modified")
+		return etag, false, nil
+	}
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status %d from bundle URL", resp.StatusCode)
+		return "", false, fmt.Errorf("unexpected status %d from bundle URL", resp.StatusCode)
 	}
 
 	out, err := os.Create(dest)
 	if err != nil {
-		return fmt.Errorf("failed to create file %s: %w", dest, err)
+		return "", false, fmt.Errorf("failed to create file %s: %w", dest, err)
 	}
 	defer out.Close()
 
 	if _, err := io.Copy(out, resp.Body); err != nil {
-		return fmt.Errorf("failed to write bundle: %w", err)
+		return "", false, fmt.Errorf("failed to write bundle: %w", err)
 	}
 
 	logger.Info().Str("dest", dest).Msg("Bundle downloaded")
+	return resp.Header.Get("ETag"), true, nil
+}
+
+// ValidateBundlePath rejects absolute paths and paths with a ".." element, so a bundle
+// (zip entry, manifest or checksums.json) cannot refer to files outside its directory.
+func ValidateBundlePath(rel string) error {
+	if filepath.IsAbs(rel) || strings.HasPrefix(rel, "/") || strings.HasPrefix(rel, `\`) || filepath.VolumeName(rel) != "" {
+		return fmt.Errorf("illegal path %s: must be relative to the bundle", rel)
+	}
+	for _, part := range strings.FieldsFunc(rel, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return fmt.Errorf("illegal path %s: must stay inside the bundle", rel)
+		}
+	}
 	return nil
 }
 
@@ -59,6 +88,9 @@ func ExtractZip(src, dest string) error {
 
 	destClean := filepath.Clean(dest)
 	for _, f := range r.File {
+		if err := ValidateBundlePath(f.Name); err != nil {
+			return fmt.Errorf("illegal path in zip: %w", err)
+		}
 		target := filepath.Join(dest, f.Name)
 		targetClean := filepath.Clean(target)
 		if targetClean != destClean && !strings.HasPrefix(targetClean, destClean+string(os.PathSeparator)) {
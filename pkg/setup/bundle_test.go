@@ -0,0 +1,61 @@
+package setup
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateBundlePath(t *testing.T) {
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"manifest.json", true},
+		{"migrations/0001_init.sql", true},
+		{"db-bundle/migrations/0001..0002.sql", true},
+		{"", true},
+		{"../etc/passwd", false},
+		{"migrations/../../x.sql", false},
+		{`migrations\..\..\x.sql`, false},
+		{"..", false},
+		{"/etc/passwd", false},
+		{`\windows\system32`, false},
+	}
+	for _, tt := range tests {
+		err := ValidateBundlePath(tt.path)
+		if (err == nil) != tt.ok {
+			t.Errorf("ValidateBundlePath(%q) = %v, want ok=%v", tt.path, err, tt.ok)
+		}
+	}
+}
+
+func TestExtractZipRejectsUnsafeEntries(t *testing.T) {
+	for _, name := range []string{"../evil.sql", "/tmp/evil.sql", "a/../../evil.sql"} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "bundle.zip")
+			f, err := os.Create(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			zw := zip.NewWriter(f)
+			w, err := zw.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte("SELECT 1;"))
+			zw.Close()
+			f.Close()
+
+			dest := filepath.Join(dir, "out")
+			if err := ExtractZip(src, dest); err == nil {
+				t.Fatalf("ExtractZip accepted entry %q", name)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "evil.sql")); !os.IsNotExist(err) {
+				t.Fatalf("entry %q was written outside dest", name)
+			}
+		})
+	}
+}
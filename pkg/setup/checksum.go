@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"agent-service-prototype/pkg/logger"
@@ -17,6 +18,122 @@ func SHA256Hex(data []byte) string {
 	return hex.EncodeToString(h[:])
 }
 
+// CanonicalSHA256Hex returns the SHA-256 of CanonicalSQL(data), so edits that only change
+// whitespace between SQL tokens keep the same value.
+func CanonicalSHA256Hex(data []byte) string {
+	return SHA256Hex([]byte(CanonicalSQL(string(data))))
+}
+
+// CanonicalSQL collapses every run of whitespace between tokens to a single space and trims
+// the ends. String literals, quoted identifiers, dollar-quoted bodies and comments are kept
+// verbatim, since whitespace inside them is part of what the migration does or says.
+func CanonicalSQL(sql string) string {
+	var b strings.Builder
+	b.Grow(len(sql))
+	space := false
+	emit := func(tok string) {
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteString(tok)
+	}
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		end := i + 1
+		switch {
+		case isSQLSpace(c):
+			space = true
+			i++
+			continue
+		case c == '\'':
+			escapes := i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e') && (i == 1 || !isIdentByte(sql[i-2]))
+			end = quotedEnd(sql, i, '\'', escapes)
+		case c == '"':
+			end = quotedEnd(sql, i, '"', false)
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end = len(sql)
+			if n := strings.IndexAny(sql[i:], "\r\n"); n >= 0 {
+				end = i + n
+			}
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end = blockCommentEnd(sql, i)
+		case c == '$' && (i == 0 || !isIdentByte(sql[i-1])):
+			if tag := dollarTag(sql[i:]); tag != "" {
+				end = len(sql)
+				if n := strings.Index(sql[i+len(tag):], tag); n >= 0 {
+					end = i + len(tag) + n + len(tag)
+				}
+			}
+		}
+		emit(sql[i:end])
+		i = end
+	}
+	return b.String()
+}
+
+func isSQLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// quotedEnd returns the index after the quote that closes the literal opened at sql[start].
+// A doubled quote is an escaped quote; with escapes, so is a backslash-escaped one (E'...').
+// An unterminated literal runs to the end of sql.
+func quotedEnd(sql string, start int, quote byte, escapes bool) int {
+	for i := start + 1; i < len(sql); i++ {
+		switch {
+		case escapes && sql[i] == '\\':
+			i++
+		case sql[i] == quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(sql)
+}
+
+// blockCommentEnd returns the index after the comment opened at sql[start]; Postgres block
+// comments nest.
+func blockCommentEnd(sql string, start int) int {
+	depth := 0
+	for i := start; i+1 < len(sql); i++ {
+		switch {
+		case sql[i] == '/' && sql[i+1] == '*':
+			depth++
+			i++
+		case sql[i] == '*' && sql[i+1] == '/':
+			depth--
+			i++
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(sql)
+}
+
+// dollarTag returns the $tag$ or $$ opening a dollar-quoted string at the start of sql, or "".
+func dollarTag(sql string) string {
+	for i := 1; i < len(sql); i++ {
+		c := sql[i]
+		if c == '$' {
+			return sql[:i+1]
+		}
+		if !isIdentByte(c) || (i == 1 && '0' <= c && c <= '9') {
+			return ""
+		}
+	}
+	return ""
+}
+
 // NormalizeChecksum strips an optional "sha256:" or "SHA256:" prefix from the expected value.
 func NormalizeChecksum(expected string) string {
 	const prefix = "sha256:"
@@ -30,6 +147,9 @@ func NormalizeChecksum(expected string) string {
 // Expected values may include an optional "sha256:" prefix.
 func VerifyChecksums(baseDir string, checksums map[string]string) error {
 	for relPath, expected := range checksums {
+		if err := ValidateBundlePath(relPath); err != nil {
+			return err
+		}
 		data, err := os.ReadFile(filepath.Join(baseDir, relPath))
 		if err != nil {
 			return fmt.Errorf("failed to read %s for checksum: %w", relPath, err)
@@ -43,3 +163,54 @@ func VerifyChecksums(baseDir string, checksums map[string]string) error {
 	logger.Info().Int("files", len(checksums)).Msg("All checksums verified")
 	return nil
 }
+
+// ChecksumResult is the verification outcome for one file listed in checksums.json.
+type ChecksumResult struct {
+	File     string `json:"file"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual,omitempty"`
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+}
+
+// CheckChecksums verifies every file in checksums and reports each result instead of
+// stopping at the first mismatch. Results are sorted by file.
+func CheckChecksums(baseDir string, checksums map[string]string) []ChecksumResult {
+	results := make([]ChecksumResult, 0, len(checksums))
+	for relPath, expected := range checksums {
+		res := ChecksumResult{File: relPath, Expected: NormalizeChecksum(expected)}
+		if err := ValidateBundlePath(relPath); err != nil {
+			res.Error = err.Error()
+			results = append(results, res)
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(baseDir, relPath))
+		if err != nil {
+			res.Error = fmt.Sprintf("failed to read file: %v", err)
+		} else {
+			res.Actual = SHA256Hex(data)
+			res.OK = res.Actual == res.Expected
+			if !res.OK {
+				res.Error = "checksum mismatch"
+			}
+		}
+		results = append(results, res)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].File < results[j].File })
+	return results
+}
+
+// LintBundle runs Manifest.Lint and additionally reports manifest files that
+// checksums.json does not cover, since those would be installed unverified.
+func LintBundle(baseDir string, m *Manifest, checksums map[string]string) []error {
+	errs := m.Lint(baseDir)
+	for _, f := range m.files() {
+		if f == "" {
+			continue
+		}
+		if _, ok := checksums[f]; !ok {
+			errs = append(errs, fmt.Errorf("%s is not listed in checksums.json", f))
+		}
+	}
+	return errs
+}
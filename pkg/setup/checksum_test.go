@@ -0,0 +1,59 @@
+package setup
+
+import "testing"
+
+func TestCanonicalSQL(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"collapses whitespace", "CREATE  TABLE\tfoo (\n  id INT\n);\n", "CREATE TABLE foo ( id INT );"},
+		{"trims", "\n\n  SELECT 1;  \n", "SELECT 1;"},
+		{"string literal", "SELECT 'a  b\n c';", "SELECT 'a  b\n c';"},
+		{"doubled quote", "SELECT 'it''s   here'  ;", "SELECT 'it''s   here' ;"},
+		{"escape string", `SELECT E'a\'  b'   ;`, `SELECT E'a\'  b' ;`},
+		{"backslash in standard string", `SELECT 'C:\'   ,  'x  y';`, `SELECT 'C:\' , 'x  y';`},
+		{"quoted identifier", `ALTER TABLE "my   table"  ADD  x INT;`, `ALTER TABLE "my   table" ADD x INT;`},
+		{"dollar quote", "CREATE FUNCTION f() RETURNS int AS $$\n  SELECT  1\n$$  LANGUAGE sql;", "CREATE FUNCTION f() RETURNS int AS $$\n  SELECT  1\n$$ LANGUAGE sql;"},
+		{"tagged dollar quote", "DO $body$ BEGIN  RAISE  NOTICE '$$'; END $body$;", "DO $body$ BEGIN  RAISE  NOTICE '$$'; END $body$;"},
+		{"positional parameter", "SELECT  $1,   $2", "SELECT $1, $2"},
+		{"line comment", "--  keep   this\nSELECT   1;", "--  keep   this SELECT 1;"},
+		{"block comment", "/* a   /* nested  */  b */   SELECT 1;", "/* a   /* nested  */  b */ SELECT 1;"},
+		{"unterminated literal", "SELECT 'a   b", "SELECT 'a   b"},
+		{"empty", " \n\t ", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanonicalSQL(tt.in); got != tt.want {
+				t.Errorf("CanonicalSQL(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalSHA256Hex(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		same bool
+	}{
+		{"indentation only", "CREATE TABLE t (\n  id INT\n);", "CREATE TABLE t ( id INT );", true},
+		{"trailing newline", "SELECT 1;", "SELECT 1;\n\n", true},
+		{"CRLF line endings", "SELECT 1;\r\nSELECT 2;", "SELECT 1;\nSELECT 2;", true},
+		{"CRLF after comment", "-- note\r\nSELECT 1;", "-- note\nSELECT 1;", true},
+		{"whitespace in literal", "SELECT 'a b';", "SELECT 'a  b';", false},
+		{"whitespace in quoted identifier", `SELECT "a b";`, `SELECT "a  b";`, false},
+		{"whitespace in function body", "AS $$ SELECT 1 $$", "AS $$  SELECT 1 $$", false},
+		{"token change", "SELECT 1;", "SELECT 2;", false},
+		{"joined tokens", "SELECT a b;", "SELECT ab;", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			same := CanonicalSHA256Hex([]byte(tt.a)) == CanonicalSHA256Hex([]byte(tt.b))
+			if same != tt.same {
+				t.Errorf("CanonicalSHA256Hex(%q) == CanonicalSHA256Hex(%q) is %v, want %v", tt.a, tt.b, same, tt.same)
+			}
+		})
+	}
+}
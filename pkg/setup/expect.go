@@ -0,0 +1,76 @@
+package setup
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	lineCommentRe   = regexp.MustCompile(`--[^\n]*`)
+	createSchemaRe  = regexp.MustCompile(`(?i)^CREATE\s+SCHEMA\s+(?:IF\s+NOT\s+EXISTS\s+)?(` + sqlIdent + `)`)
+	createTableRe   = regexp.MustCompile(`(?i)^CREATE\s+(?:UNLOGGED\s+)?TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?(` + sqlQualifiedName + `)`)
+	createIndexRe   = regexp.MustCompile(`(?i)^CREATE\s+(?:UNIQUE\s+)?INDEX\s+(?:CONCURRENTLY\s+)?(?:IF\s+NOT\s+EXISTS\s+)?(` + sqlIdent + `)\s+ON\s+(?:ONLY\s+)?(` + sqlQualifiedName + `)`)
+	addColumnRe     = regexp.MustCompile(`(?i)\bADD\s+COLUMN\s+(?:IF\s+NOT\s+EXISTS\s+)?(` + sqlIdent + `)`)
+	addConstraintRe = regexp.MustCompile(`(?i)\bADD\s+CONSTRAINT\s+(` + sqlIdent + `)`)
+)
+
+// ExpectedObjects returns the schemas, tables, indexes, columns and constraints that sql
+// creates, named as in a SchemaSnapshot (definitions are left empty). It understands
+// plain CREATE SCHEMA/TABLE/INDEX and ALTER TABLE ... ADD COLUMN/CONSTRAINT statements;
+// anything else is ignored, so the result is a lower bound of what sql creates.
+func ExpectedObjects(sql, defaultSchema string) []SchemaObject {
+	var objects []SchemaObject
+	seen := make(map[SchemaObject]bool)
+	add := func(kind, name string) {
+		o := SchemaObject{Kind: kind, Name: name}
+		if !seen[o] {
+			seen[o] = true
+			objects = append(objects, o)
+		}
+	}
+
+	sql = lineCommentRe.ReplaceAllString(sql, "")
+	for _, stmt := range strings.Split(sql, ";") {
+		stmt = strings.TrimSpace(stmt)
+		if m := createSchemaRe.FindStringSubmatch(stmt); m != nil {
+			add(ObjectSchema, identName(m[1]))
+			continue
+		}
+		if m := createTableRe.FindStringSubmatch(stmt); m != nil {
+			add(ObjectTable, qualifiedName(m[1], defaultSchema))
+			continue
+		}
+		if m := createIndexRe.FindStringSubmatch(stmt); m != nil {
+			table := qualifiedName(m[2], defaultSchema)
+			schema, _, _ := strings.Cut(table, ".")
+			add(ObjectIndex, schema+"."+identName(m[1]))
+			continue
+		}
+		if m := alterTableRe.FindStringSubmatch(stmt); m != nil && strings.HasPrefix(strings.ToUpper(stmt), "ALTER") {
+			table := qualifiedName(m[1], defaultSchema)
+			for _, c := range addColumnRe.FindAllStringSubmatch(stmt, -1) {
+				add(ObjectColumn, table+"."+identName(c[1]))
+			}
+			for _, c := range addConstraintRe.FindAllStringSubmatch(stmt, -1) {
+				add(ObjectConstraint, table+"."+identName(c[1]))
+			}
+		}
+	}
+	return objects
+}
+
+// MissingObjects returns the objects of expected that actual does not contain (by kind and name).
+func MissingObjects(expected []SchemaObject, actual *SchemaSnapshot) []SchemaObject {
+	type key struct{ kind, name string }
+	have := make(map[key]bool, len(actual.Objects))
+	for _, o := range actual.Objects {
+		have[key{o.Kind, o.Name}] = true
+	}
+	missing := []SchemaObject{}
+	for _, o := range expected {
+		if !have[key{o.Kind, o.Name}] {
+			missing = append(missing, o)
+		}
+	}
+	return missing
+}
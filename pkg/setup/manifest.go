@@ -1,49 +1,92 @@
 package setup
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 
 	"agent-service-prototype/pkg/logger"
+
+	"github.com/lib/pq"
 )
 
-// BaselinePath unmarshals from either a JSON string ("path/to/baseline.sql")
-// or an object with a "file" or "path" key.
-type BaselinePath string
+// Placeholders understood in db.default_schema and in bundle SQL files.
+const (
+	// TenantPlaceholder in db.default_schema ("hris_{{tenant}}") makes a bundle
+	// tenant-scoped: it is applied once per registered tenant schema.
+	TenantPlaceholder = "{{tenant}}"
+	// SchemaPlaceholder in SQL is replaced with the quoted target schema.
+	SchemaPlaceholder = "{{schema}}"
+)
 
-func (b *BaselinePath) UnmarshalJSON(data []byte) error {
+// Baseline unmarshals from either a JSON string ("path/to/baseline.sql")
+// or an object with a "file" or "path" key plus optional metadata.
+type Baseline struct {
+	Version            string `json:"version,omitempty"`
+	Name               string `json:"name,omitempty"`
+	File               string `json:"file"`
+	RequiredForFreshDB bool   `json:"required_for_fresh_db,omitempty"`
+}
+
+func (b *Baseline) UnmarshalJSON(data []byte) error {
 	if len(data) >= 2 && data[0] == '"' {
 		var s string
 		if err := json.Unmarshal(data, &s); err != nil {
 			return err
 		}
-		*b = BaselinePath(s)
+		*b = Baseline{File: s}
 		return nil
 	}
 	var obj struct {
-		File string `json:"file"`
-		Path string `json:"path"`
+		Version            string `json:"version"`
+		Name               string `json:"name"`
+		File               string `json:"file"`
+		Path               string `json:"path"`
+		RequiredForFreshDB bool   `json:"required_for_fresh_db"`
 	}
 	if err := json.Unmarshal(data, &obj); err != nil {
 		return err
 	}
-	if obj.File != "" {
-		*b = BaselinePath(obj.File)
-	} else {
-		*b = BaselinePath(obj.Path)
+	*b = Baseline{
+		Version:            obj.Version,
+		Name:               obj.Name,
+		File:               obj.File,
+		RequiredForFreshDB: obj.RequiredForFreshDB,
+	}
+	if b.File == "" {
+		b.File = obj.Path
 	}
 	return nil
 }
 
+// ManifestDB describes the target database of a bundle.
+type ManifestDB struct {
+	Type          string `json:"type,omitempty"`
+	MinVersion    int    `json:"min_version,omitempty"`
+	DefaultSchema string `json:"default_schema,omitempty"`
+}
+
+// ManifestChecks lists the check scripts run after migrations.
+type ManifestChecks struct {
+	Smoke string `json:"smoke,omitempty"`
+}
+
 // Manifest describes the db-bundle structure.
 type Manifest struct {
-	Baseline   BaselinePath `json:"baseline"`
-	Migrations []Migration  `json:"migrations"`
-	Checks     struct {
-		Smoke string `json:"smoke"`
-	} `json:"checks"`
+	BundleVersion       string         `json:"bundle_version,omitempty"`
+	App                 string         `json:"app,omitempty"`
+	TargetSchemaVersion string         `json:"target_schema_version,omitempty"`
+	DB                  ManifestDB     `json:"db"`
+	Baseline            Baseline       `json:"baseline"`
+	Migrations          []Migration    `json:"migrations"`
+	Checks              ManifestChecks `json:"checks"`
+	// Snapshot is an optional schema snapshot (SchemaSnapshot JSON) describing
+	// the expected schema after all migrations.
+	Snapshot string `json:"snapshot,omitempty"`
 }
 
 // Migration describes a single migration file.
@@ -52,6 +95,72 @@ type Migration struct {
 	Name        string `json:"name"`
 	File        string `json:"file"`
 	Transaction bool   `json:"transaction"`
+	// BackupTables lists the tables (schema.table) to back up before this migration runs.
+	// When empty they are inferred from the ALTER TABLE targets in the file.
+	BackupTables []string `json:"backup_tables,omitempty"`
+}
+
+// IsTenantScoped reports whether db.default_schema is templated per tenant.
+func (m *Manifest) IsTenantScoped() bool {
+	return strings.Contains(m.DB.DefaultSchema, TenantPlaceholder)
+}
+
+// TenantSchema returns db.default_schema with {{tenant}} replaced by tenant.
+func (m *Manifest) TenantSchema(tenant string) string {
+	return strings.ReplaceAll(m.DB.DefaultSchema, TenantPlaceholder, tenant)
+}
+
+// RenderSQL replaces {{schema}} in sql with the quoted schema name.
+func RenderSQL(sql, schema string) string {
+	if !strings.Contains(sql, SchemaPlaceholder) {
+		return sql
+	}
+	return strings.ReplaceAll(sql, SchemaPlaceholder, pq.QuoteIdentifier(schema))
+}
+
+// sqlIdent matches one quoted or unquoted SQL identifier.
+const sqlIdent = `(?:"[^"]+"|[\w$]+)`
+
+// sqlQualifiedName matches an optionally schema-qualified SQL name.
+const sqlQualifiedName = sqlIdent + `(?:\s*\.\s*` + sqlIdent + `)?`
+
+var alterTableRe = regexp.MustCompile(`(?i)\bALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?(` + sqlQualifiedName + `)`)
+
+// InferAlteredTables returns the distinct ALTER TABLE targets in sql as schema.table,
+// qualifying bare names with defaultSchema. Unquoted identifiers are lower-cased.
+func InferAlteredTables(sql, defaultSchema string) []string {
+	var tables []string
+	seen := make(map[string]bool)
+	for _, m := range alterTableRe.FindAllStringSubmatch(sql, -1) {
+		name := qualifiedName(m[1], defaultSchema)
+		if !seen[name] {
+			seen[name] = true
+			tables = append(tables, name)
+		}
+	}
+	return tables
+}
+
+// qualifiedName turns a matched sqlQualifiedName into schema.name, qualifying bare
+// names with defaultSchema.
+func qualifiedName(raw, defaultSchema string) string {
+	var parts []string
+	for _, p := range strings.Split(raw, ".") {
+		parts = append(parts, identName(p))
+	}
+	if len(parts) == 1 {
+		parts = []string{defaultSchema, parts[0]}
+	}
+	return strings.Join(parts, ".")
+}
+
+// identName unquotes a quoted identifier and lower-cases an unquoted one.
+func identName(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if strings.HasPrefix(raw, `"`) {
+		return strings.Trim(raw, `"`)
+	}
+	return strings.ToLower(raw)
 }
 
 // LoadManifest reads and parses manifest.json from baseDir.
@@ -64,10 +173,122 @@ func LoadManifest(baseDir string) (*Manifest, error) {
 	if err := json.Unmarshal(data, &m); err != nil {
 		return nil, fmt.Errorf("failed to parse manifest.json: %w", err)
 	}
+	for _, rel := range m.files() {
+		if err := ValidateBundlePath(rel); err != nil {
+			return nil, fmt.Errorf("invalid manifest.json: %w", err)
+		}
+	}
 	logger.Info().
-		Str("baseline", string(m.Baseline)).
+		Str("bundle_version", m.BundleVersion).
+		Str("baseline", m.Baseline.File).
 		Int("migrations", len(m.Migrations)).
 		Str("smoke", m.Checks.Smoke).
 		Msg("Manifest parsed")
 	return &m, nil
 }
+
+// ParseManifestStrict parses manifest data and rejects unknown fields,
+// so a manifest that round-trips through it is read by LoadManifest as written.
+func ParseManifestStrict(data []byte) (*Manifest, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var m Manifest
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest.json: %w", err)
+	}
+	return &m, nil
+}
+
+// files returns every file the manifest refers to; unset optional files are empty.
+func (m *Manifest) files() []string {
+	files := []string{m.Baseline.File, m.Checks.Smoke, m.Snapshot}
+	for _, mig := range m.Migrations {
+		files = append(files, mig.File)
+	}
+	return files
+}
+
+var concurrentlyRe = regexp.MustCompile(`(?i)\bCONCURRENTLY\b`)
+
+// Lint checks the manifest against the files in baseDir and returns every problem found.
+func (m *Manifest) Lint(baseDir string) []error {
+	var errs []error
+	// Paths are checked before anything is read, so a hostile manifest cannot make Lint
+	// open files outside baseDir.
+	for _, rel := range m.files() {
+		if err := ValidateBundlePath(rel); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	fileExists := func(rel string) bool {
+		_, err := os.Stat(filepath.Join(baseDir, rel))
+		return err == nil
+	}
+
+	if m.Baseline.File == "" {
+		errs = append(errs, fmt.Errorf("baseline: file is required"))
+	} else if !fileExists(m.Baseline.File) {
+		errs = append(errs, fmt.Errorf("baseline: file %s not found", m.Baseline.File))
+	}
+
+	seen := make(map[string]bool, len(m.Migrations))
+	prev := m.Baseline.Version
+	for i, mig := range m.Migrations {
+		label := fmt.Sprintf("migrations[%d]", i)
+		if mig.Version == "" {
+			errs = append(errs, fmt.Errorf("%s: version is required", label))
+		} else {
+			label = fmt.Sprintf("migration %s", mig.Version)
+			if seen[mig.Version] {
+				errs = append(errs, fmt.Errorf("%s: duplicate version", label))
+			}
+			seen[mig.Version] = true
+			if prev != "" && mig.Version <= prev {
+				errs = append(errs, fmt.Errorf("%s: version must be greater than %s", label, prev))
+			}
+			prev = mig.Version
+		}
+		if mig.Name == "" {
+			errs = append(errs, fmt.Errorf("%s: name is required", label))
+		}
+		if mig.File == "" {
+			errs = append(errs, fmt.Errorf("%s: file is required", label))
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(baseDir, mig.File))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: file %s not found", label, mig.File))
+			continue
+		}
+		if mig.Transaction && concurrentlyRe.Match(data) {
+			errs = append(errs, fmt.Errorf("%s: uses CONCURRENTLY and must not run in a transaction", label))
+		}
+		if m.DB.DefaultSchema == "" && bytes.Contains(data, []byte(SchemaPlaceholder)) {
+			errs = append(errs, fmt.Errorf("%s: uses %s but db.default_schema is not set", label, SchemaPlaceholder))
+		}
+	}
+
+	if m.TargetSchemaVersion != "" && len(m.Migrations) > 0 {
+		if last := m.Migrations[len(m.Migrations)-1].Version; last != m.TargetSchemaVersion {
+			errs = append(errs, fmt.Errorf("target_schema_version %s does not match last migration %s", m.TargetSchemaVersion, last))
+		}
+	}
+
+	if m.Checks.Smoke != "" && !fileExists(m.Checks.Smoke) {
+		errs = append(errs, fmt.Errorf("checks: smoke file %s not found", m.Checks.Smoke))
+	}
+
+	if m.Snapshot != "" {
+		if !fileExists(m.Snapshot) {
+			errs = append(errs, fmt.Errorf("snapshot file %s not found", m.Snapshot))
+		} else if _, err := LoadSnapshot(baseDir, m.Snapshot); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
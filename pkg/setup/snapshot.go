@@ -0,0 +1,109 @@
+package setup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	ObjectSchema     = "schema"
+	ObjectTable      = "table"
+	ObjectView       = "view"
+	ObjectColumn     = "column"
+	ObjectIndex      = "index"
+	ObjectConstraint = "constraint"
+	ObjectFunction   = "function"
+	ObjectGrant      = "grant"
+)
+
+const (
+	DriftAdded   = "added"
+	DriftRemoved = "removed"
+	DriftChanged = "changed"
+)
+
+// SchemaObject is one database object in a snapshot. Name is unique per Kind
+// (e.g. "hris.employees.nik" for a column) and Definition is its canonical form.
+type SchemaObject struct {
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Definition string `json:"definition"`
+}
+
+// SchemaSnapshot is a canonical, sorted description of the user schemas of a database.
+type SchemaSnapshot struct {
+	Objects []SchemaObject `json:"objects"`
+}
+
+// DriftItem is one difference between an expected and an actual snapshot.
+type DriftItem struct {
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+	Change   string `json:"change"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// Sort orders objects by kind and name so equal schemas serialize identically.
+func (s *SchemaSnapshot) Sort() {
+	sort.Slice(s.Objects, func(i, j int) bool {
+		if s.Objects[i].Kind != s.Objects[j].Kind {
+			return s.Objects[i].Kind < s.Objects[j].Kind
+		}
+		return s.Objects[i].Name < s.Objects[j].Name
+	})
+}
+
+// LoadSnapshot reads a snapshot JSON file shipped in a bundle.
+func LoadSnapshot(baseDir, rel string) (*SchemaSnapshot, error) {
+	data, err := os.ReadFile(filepath.Join(baseDir, rel))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s: %w", rel, err)
+	}
+	var snap SchemaSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %s: %w", rel, err)
+	}
+	snap.Sort()
+	return &snap, nil
+}
+
+// DiffSnapshots reports every object that was added to, removed from or changed
+// in actual compared with expected, sorted by kind and name.
+func DiffSnapshots(expected, actual *SchemaSnapshot) []DriftItem {
+	type key struct{ kind, name string }
+	exp := make(map[key]string, len(expected.Objects))
+	for _, o := range expected.Objects {
+		exp[key{o.Kind, o.Name}] = o.Definition
+	}
+	act := make(map[key]string, len(actual.Objects))
+	for _, o := range actual.Objects {
+		act[key{o.Kind, o.Name}] = o.Definition
+	}
+
+	items := []DriftItem{}
+	for k, def := range exp {
+		got, ok := act[k]
+		switch {
+		case !ok:
+			items = append(items, DriftItem{Kind: k.kind, Name: k.name, Change: DriftRemoved, Expected: def})
+		case got != def:
+			items = append(items, DriftItem{Kind: k.kind, Name: k.name, Change: DriftChanged, Expected: def, Actual: got})
+		}
+	}
+	for k, def := range act {
+		if _, ok := exp[k]; !ok {
+			items = append(items, DriftItem{Kind: k.kind, Name: k.name, Change: DriftAdded, Actual: def})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Kind != items[j].Kind {
+			return items[i].Kind < items[j].Kind
+		}
+		return items[i].Name < items[j].Name
+	})
+	return items
+}
@@ -4,11 +4,48 @@ import "time"
 
 // StatusPayload is the JSON payload for setup status (GET /setup/status and 409 conflict).
 type StatusPayload struct {
-	Status     string     `json:"status"`
-	Step       string     `json:"step,omitempty"`
-	Error      string     `json:"error,omitempty"`
-	StartedAt  *time.Time `json:"started_at,omitempty"`
-	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Status        string `json:"status"`
+	Step          string `json:"step,omitempty"`
+	Error         string `json:"error,omitempty"`
+	BundleID      string `json:"bundle_id,omitempty"`
+	BundleVersion string `json:"bundle_version,omitempty"`
+	// LockHolder is set while the installer waits for the advisory lock.
+	LockHolder *LockHolder `json:"lock_holder,omitempty"`
+	// Targets holds the per-target step of a multi-target installation.
+	Targets map[string]string `json:"targets,omitempty"`
+	// StartedBy and StartedByRole name the caller that started the run and its role.
+	StartedBy     string     `json:"started_by,omitempty"`
+	StartedByRole string     `json:"started_by_role,omitempty"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+	// Updates is set when bundle polling (BUNDLE_POLL_INTERVAL) is enabled.
+	Updates *UpdateStatus `json:"updates,omitempty"`
+}
+
+// UpdateStatus describes bundle polling: the last check of BUNDLE_URL, the bundle found there,
+// the schema and bundle version the database has installed and the maintenance window of the upgrade.
+type UpdateStatus struct {
+	LastCheckAt            *time.Time `json:"last_check_at,omitempty"`
+	LastError              string     `json:"last_error,omitempty"`
+	AvailableVersion       string     `json:"available_version,omitempty"`
+	AvailableBundleID      string     `json:"available_bundle_id,omitempty"`
+	AvailableSchemaVersion string     `json:"available_schema_version,omitempty"`
+	InstalledSchemaVersion string     `json:"installed_schema_version,omitempty"`
+	InstalledVersion       string     `json:"installed_version,omitempty"`
+	UpgradePending         bool       `json:"upgrade_pending"`
+	NextWindowStart        *time.Time `json:"next_window_start,omitempty"`
+	NextWindowEnd          *time.Time `json:"next_window_end,omitempty"`
+}
+
+// LockHolder describes the session holding the installer's advisory lock.
+// For sessions not tagged by an agent, HeldSince is the start of the holder's
+// connection, so the real hold time may be shorter.
+type LockHolder struct {
+	PID         int       `json:"pid"`
+	Application string    `json:"application,omitempty"`
+	ClientAddr  string    `json:"client_addr,omitempty"`
+	HeldSince   time.Time `json:"held_since"`
+	HeldSeconds float64   `json:"held_seconds"`
 }
 
 // NewStatusPayload builds a StatusPayload from individual fields.
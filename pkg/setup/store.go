@@ -0,0 +1,322 @@
+package setup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrBundleNotFound is returned when a bundle ID is not in the store.
+var ErrBundleNotFound = errors.New("bundle not found")
+
+var bundleIDRe = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// BundleMeta is stored next to each cached bundle as meta.json.
+type BundleMeta struct {
+	ID            string     `json:"bundle_id"`
+	BundleVersion string     `json:"bundle_version,omitempty"`
+	App           string     `json:"app,omitempty"`
+	Source        string     `json:"source"`
+	SizeBytes     int64      `json:"size_bytes"`
+	FetchedAt     time.Time  `json:"fetched_at"`
+	InstalledAt   *time.Time `json:"installed_at,omitempty"`
+}
+
+// lastUsed is the later of FetchedAt and InstalledAt.
+func (m BundleMeta) lastUsed() time.Time {
+	if m.InstalledAt != nil && m.InstalledAt.After(m.FetchedAt) {
+		return *m.InstalledAt
+	}
+	return m.FetchedAt
+}
+
+// BundleStore keeps bundle zips under dir, keyed by the SHA-256 of their content:
+// <dir>/<sha256>/bundle.zip, with metadata in meta.json and the extracted
+// content in extracted/.
+type BundleStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewBundleStore creates a store rooted at dir.
+func NewBundleStore(dir string) *BundleStore {
+	return &BundleStore{dir: dir}
+}
+
+// Put writes r into the store and returns its ID (hex SHA-256).
+// source is recorded in meta.json (e.g. "upload" or the download URL).
+// Storing the same content twice keeps the bundle and refreshes its fetch time.
+func (s *BundleStore) Put(r io.Reader, source string) (string, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create bundle store: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.dir, "upload-*.zip")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write bundle: %w", err)
+	}
+
+	id := hex.EncodeToString(h.Sum(nil))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, err := s.readMeta(id)
+	if err != nil {
+		meta = &BundleMeta{ID: id}
+	}
+	if _, err := os.Stat(s.zipPath(id)); err != nil {
+		if err := os.MkdirAll(filepath.Join(s.dir, id), 0755); err != nil {
+			return "", fmt.Errorf("failed to create bundle dir: %w", err)
+		}
+		if err := os.Rename(tmp.Name(), s.zipPath(id)); err != nil {
+			return "", fmt.Errorf("failed to store bundle: %w", err)
+		}
+	}
+	meta.Source = source
+	meta.SizeBytes = size
+	meta.FetchedAt = time.Now().UTC()
+	if err := s.writeMeta(meta); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Fetch downloads url into the store and returns the bundle ID.
+func (s *BundleStore) Fetch(ctx context.Context, url string) (string, error) {
+	id, _, err := s.FetchIfModified(ctx, url, "")
+	return id, err
+}
+
+// FetchIfModified downloads url into the store unless its ETag still matches etag, and
+// returns the bundle ID and the new ETag. The ID is empty when the bundle was not modified.
+func (s *BundleStore) FetchIfModified(ctx context.Context, url, etag string) (string, string, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", "", fmt.Errorf("failed to create bundle store: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.dir, "download-*.zip")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	newETag, modified, err := DownloadBundleIfModified(ctx, url, tmp.Name(), etag)
+	if err != nil || !modified {
+		return "", newETag, err
+	}
+	f, err := os.Open(tmp.Name())
+	if err != nil {
+		return "", "", fmt.Errorf("failed to open downloaded bundle: %w", err)
+	}
+	defer f.Close()
+	id, err := s.Put(f, url)
+	return id, newETag, err
+}
+
+// Path returns the zip path for id, or ErrBundleNotFound.
+func (s *BundleStore) Path(id string) (string, error) {
+	if !bundleIDRe.MatchString(id) {
+		return "", fmt.Errorf("%w: invalid bundle id %q", ErrBundleNotFound, id)
+	}
+	p := s.zipPath(id)
+	if _, err := os.Stat(p); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrBundleNotFound, id)
+	}
+	return p, nil
+}
+
+// ExtractDir returns the directory a bundle is extracted into.
+func (s *BundleStore) ExtractDir(id string) string {
+	return filepath.Join(s.dir, id, "extracted")
+}
+
+// Meta returns the metadata of bundle id.
+func (s *BundleStore) Meta(id string) (*BundleMeta, error) {
+	if !bundleIDRe.MatchString(id) {
+		return nil, fmt.Errorf("%w: invalid bundle id %q", ErrBundleNotFound, id)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readMeta(id)
+}
+
+// UpdateManifest records the bundle version and app read from the bundle manifest.
+func (s *BundleStore) UpdateManifest(id string, m *Manifest) error {
+	return s.update(id, func(meta *BundleMeta) {
+		meta.BundleVersion = m.BundleVersion
+		meta.App = m.App
+	})
+}
+
+// MarkInstalled records that bundle id was installed at t.
+func (s *BundleStore) MarkInstalled(id string, t time.Time) error {
+	return s.update(id, func(meta *BundleMeta) {
+		ut := t.UTC()
+		meta.InstalledAt = &ut
+	})
+}
+
+// List returns the metadata of all cached bundles, most recently used first.
+func (s *BundleStore) List() ([]BundleMeta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.list()
+}
+
+// LastInstalled returns the bundle with the latest InstalledAt, or nil.
+func (s *BundleStore) LastInstalled() (*BundleMeta, error) {
+	metas, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	var last *BundleMeta
+	for i := range metas {
+		m := metas[i]
+		if m.InstalledAt != nil && (last == nil || m.InstalledAt.After(*last.InstalledAt)) {
+			last = &m
+		}
+	}
+	return last, nil
+}
+
+// Prune applies the retention policy: a bundle is kept when it is among the keep most
+// recently used bundles or was used within maxAge. Zero disables that rule; when both
+// are zero nothing is removed. IDs in protect are always kept. Returns the removed IDs.
+func (s *BundleStore) Prune(keep int, maxAge time.Duration, protect ...string) ([]string, error) {
+	if keep <= 0 && maxAge <= 0 {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	metas, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	protected := make(map[string]bool, len(protect))
+	for _, id := range protect {
+		protected[id] = true
+	}
+
+	var removed []string
+	now := time.Now()
+	for i, m := range metas {
+		if protected[m.ID] {
+			continue
+		}
+		if keep > 0 && i < keep {
+			continue
+		}
+		if maxAge > 0 && now.Sub(m.lastUsed()) <= maxAge {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.dir, m.ID)); err != nil {
+			return removed, fmt.Errorf("failed to remove bundle %s: %w", m.ID, err)
+		}
+		removed = append(removed, m.ID)
+	}
+	return removed, nil
+}
+
+// Delete removes the bundle id and everything stored next to it.
+func (s *BundleStore) Delete(id string) error {
+	if !bundleIDRe.MatchString(id) {
+		return fmt.Errorf("%w: invalid bundle id %q", ErrBundleNotFound, id)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return os.RemoveAll(filepath.Join(s.dir, id))
+}
+
+func (s *BundleStore) update(id string, fn func(*BundleMeta)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	meta, err := s.readMeta(id)
+	if err != nil {
+		return err
+	}
+	fn(meta)
+	return s.writeMeta(meta)
+}
+
+func (s *BundleStore) list() ([]BundleMeta, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []BundleMeta{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle store: %w", err)
+	}
+	metas := []BundleMeta{}
+	for _, e := range entries {
+		if !e.IsDir() || !bundleIDRe.MatchString(e.Name()) {
+			continue
+		}
+		meta, err := s.readMeta(e.Name())
+		if err != nil {
+			continue
+		}
+		metas = append(metas, *meta)
+	}
+	sort.Slice(metas, func(i, j int) bool { return metas[i].lastUsed().After(metas[j].lastUsed()) })
+	return metas, nil
+}
+
+func (s *BundleStore) readMeta(id string) (*BundleMeta, error) {
+	data, err := os.ReadFile(s.metaPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrBundleNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle metadata: %w", err)
+	}
+	var meta BundleMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse bundle metadata: %w", err)
+	}
+	return &meta, nil
+}
+
+func (s *BundleStore) writeMeta(meta *BundleMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bundle metadata: %w", err)
+	}
+	tmp := s.metaPath(meta.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write bundle metadata: %w", err)
+	}
+	if err := os.Rename(tmp, s.metaPath(meta.ID)); err != nil {
+		return fmt.Errorf("failed to write bundle metadata: %w", err)
+	}
+	return nil
+}
+
+func (s *BundleStore) zipPath(id string) string {
+	return filepath.Join(s.dir, id, "bundle.zip")
+}
+
+func (s *BundleStore) metaPath(id string) string {
+	return filepath.Join(s.dir, id, "meta.json")
+}